@@ -0,0 +1,122 @@
+package auth
+
+import "sync"
+
+// EventType — тип события, транслируемого клиентам через SSE/WebSocket
+type EventType string
+
+const (
+	EventAuthNeedsCode       EventType = "auth.needs_code"
+	EventAuthNeedsPassword   EventType = "auth.needs_password"
+	EventAuthAuthorized      EventType = "auth.authorized"
+	EventChatNewMessage      EventType = "chat.new_message"
+	EventChatEditedMessage   EventType = "chat.edited_message"
+	EventChatDeletedMessages EventType = "chat.deleted_messages"
+	EventChatRead            EventType = "chat.read"
+	EventChatTyping          EventType = "chat.typing"
+	EventMediaDownloaded     EventType = "media.downloaded"
+	EventMediaProgress       EventType = "media.progress"
+	EventPhoneCall           EventType = "phone.call"
+)
+
+// DeletedMessages — данные события EventChatDeletedMessages: ChatID равен 0
+// для приватных чатов и групп, поскольку updateDeleteMessages в MTProto не
+// несёт информации о чате (клиент обязан сам знать, каким сообщениям
+// принадлежат ID); для каналов ChatID заполняется из updateDeleteChannelMessages
+type DeletedMessages struct {
+	ChatID int64
+	IDs    []int
+}
+
+// Event — единица данных, публикуемая в шину событий. ID монотонно
+// возрастает и используется клиентами SSE для переподключения через
+// заголовок Last-Event-ID.
+type Event struct {
+	ID   uint64
+	Type EventType
+	Data interface{}
+}
+
+// eventBacklogSize — сколько последних событий хранится для повторной
+// отправки клиентам, переподключившимся с Last-Event-ID
+const eventBacklogSize = 256
+
+// EventBus — простая in-memory шина публикации/подписки поверх каналов,
+// заменяющая файлы-сигналы вроде /tmp/vi-tg-needs-code
+type EventBus struct {
+	mu      sync.Mutex
+	nextID  uint64
+	subs    map[chan Event]struct{}
+	backlog []Event
+}
+
+// NewEventBus создаёт пустую шину событий
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe регистрирует нового подписчика и возвращает канал событий и
+// функцию отписки, которую нужно вызвать по завершении соединения
+func (b *EventBus) Subscribe() (chan Event, func()) {
+	ch := make(chan Event, 32)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+
+	return ch, unsubscribe
+}
+
+// Since возвращает события с ID строго больше lastID — используется для
+// докатки пропущенных событий при переподключении по Last-Event-ID
+func (b *EventBus) Since(lastID uint64) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var result []Event
+	for _, evt := range b.backlog {
+		if evt.ID > lastID {
+			result = append(result, evt)
+		}
+	}
+	return result
+}
+
+// Publish рассылает событие всем текущим подписчикам, не блокируясь на
+// медленных читателях, и сохраняет его в журнале для докатки
+func (b *EventBus) Publish(eventType EventType, data interface{}) {
+	b.mu.Lock()
+	b.nextID++
+	evt := Event{ID: b.nextID, Type: eventType, Data: data}
+
+	b.backlog = append(b.backlog, evt)
+	if len(b.backlog) > eventBacklogSize {
+		b.backlog = b.backlog[len(b.backlog)-eventBacklogSize:]
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+	b.mu.Unlock()
+}
+
+// events — глобальная шина пакета auth, используемая вместо файлов-сигналов
+// для оповещения о состоянии авторизации и (в дальнейшем) обновлений MTProto
+var events = NewEventBus()
+
+// Events возвращает шину событий пакета auth для подписки извне (SSE/WS)
+func Events() *EventBus {
+	return events
+}
@@ -0,0 +1,46 @@
+//go:build vi_tg_filesignal
+
+// Этот файл собирается только с тегом vi_tg_filesignal и восстанавливает
+// старый опрос /tmp/vi-tg-needs-code и /tmp/vi-tg-auth-code вместо
+// ConsoleAuth.CodeChan/TUIAuth — оставлено как путь отладки при миграции на
+// канало-ориентированную авторизацию (chunk4-5)
+package auth
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	needsCodeSignalFile = "/tmp/vi-tg-needs-code"
+	authCodeSignalFile  = "/tmp/vi-tg-auth-code"
+)
+
+// WaitForFileSignalCode опрашивает authCodeSignalFile каждые 100мс, как это
+// делал ConsoleAuth.Code до появления codeCh — годится только для отладки на
+// одной машине и под одним пользователем, см. CHANGELOG chunk4-5
+func WaitForFileSignalCode(ctx context.Context) (string, error) {
+	if f, err := os.Create(needsCodeSignalFile); err == nil {
+		f.Close()
+	}
+	defer os.Remove(needsCodeSignalFile)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			data, err := os.ReadFile(authCodeSignalFile)
+			if err != nil {
+				continue
+			}
+			os.Remove(authCodeSignalFile)
+			return strings.TrimSpace(string(data)), nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
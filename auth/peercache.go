@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gotd/td/tg"
+)
+
+// cachedPeer хранит тип и access hash peer'а — ровно то, что нужно, чтобы
+// построить tg.InputPeerClass без повторного запроса диалогов или
+// channels.getChannels
+type cachedPeer struct {
+	Type       string `json:"type"` // "user" | "group" | "channel" — те же значения, что и Dialog.Type
+	AccessHash int64  `json:"access_hash,omitempty"`
+}
+
+// inputPeer строит tg.InputPeerClass для peer с данным ID на основе
+// сохранённого типа и access hash
+func (p cachedPeer) inputPeer(id int64) tg.InputPeerClass {
+	switch p.Type {
+	case "group":
+		return &tg.InputPeerChat{ChatID: id}
+	case "channel":
+		return &tg.InputPeerChannel{ChannelID: id, AccessHash: p.AccessHash}
+	default:
+		return &tg.InputPeerUser{UserID: id, AccessHash: p.AccessHash}
+	}
+}
+
+// peerCache — потокобезопасная карта peerID -> cachedPeer. Заполняется из
+// GetDialogs, processMessage, ResolveUsername и JoinByInvite, так что
+// SendMessage/GetMessages перестают гадать тип peer'а и ходить за
+// channels.getChannels на каждый вызов, как это делал GetMessages раньше.
+// Персистится рядом с session.json, чтобы access hash'и не терялись между
+// перезапусками (chunk4-3, аналогично кешу контактов в telegabber)
+type peerCache struct {
+	mu    sync.Mutex
+	peers map[int64]cachedPeer
+}
+
+// globalPeerCache — кеш пакета auth, общий для всех методов MTProtoClient
+var globalPeerCache = newPeerCache()
+
+func newPeerCache() *peerCache {
+	c := &peerCache{peers: make(map[int64]cachedPeer)}
+	c.load()
+	return c
+}
+
+// put запоминает тип и access hash peer'а и сохраняет кеш на диск
+func (c *peerCache) put(id int64, typ string, accessHash int64) {
+	c.mu.Lock()
+	c.peers[id] = cachedPeer{Type: typ, AccessHash: accessHash}
+	snapshot := make(map[int64]cachedPeer, len(c.peers))
+	for k, v := range c.peers {
+		snapshot[k] = v
+	}
+	c.mu.Unlock()
+
+	c.save(snapshot)
+}
+
+// get возвращает сохранённые данные peer'а, если он уже встречался
+func (c *peerCache) get(id int64) (cachedPeer, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.peers[id]
+	return p, ok
+}
+
+// resolve строит tg.InputPeerClass для отправки сообщений: если peer уже
+// встречался, используется его реальный тип и access hash, иначе —
+// InputPeerUser без access hash, как и раньше для ещё не увиденных ID
+func (c *peerCache) resolve(id int64) tg.InputPeerClass {
+	if p, ok := c.get(id); ok {
+		return p.inputPeer(id)
+	}
+	return &tg.InputPeerUser{UserID: id}
+}
+
+// load читает кеш с диска при старте процесса; отсутствие файла не ошибка —
+// кеш просто начинается пустым и заполняется заново по мере работы
+func (c *peerCache) load() {
+	data, err := os.ReadFile(getPeerCachePath())
+	if err != nil {
+		return
+	}
+
+	var peers map[int64]cachedPeer
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.peers = peers
+	c.mu.Unlock()
+}
+
+// save пишет снимок кеша на диск рядом с session.json
+func (c *peerCache) save(peers map[int64]cachedPeer) {
+	data, err := json.MarshalIndent(peers, "", "  ")
+	if err != nil {
+		return
+	}
+
+	path := getPeerCachePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	_ = os.WriteFile(path, data, 0644)
+}
+
+func getPeerCachePath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	return filepath.Join(homeDir, ".vi-tg", "peers.json")
+}
+
+// cachePeersFromEntities запоминает в globalPeerCache всех пользователей и
+// чаты, пришедшие вместе с сообщением или списком диалогов — users/chats
+// сопровождают почти каждый ответ MTProto, так что это не требует
+// дополнительных запросов
+func cachePeersFromEntities(users []tg.UserClass, chats []tg.ChatClass) {
+	for _, userRaw := range users {
+		if u, ok := userRaw.(*tg.User); ok {
+			globalPeerCache.put(int64(u.ID), "user", u.AccessHash)
+		}
+	}
+	for _, chatRaw := range chats {
+		switch c := chatRaw.(type) {
+		case *tg.Chat:
+			globalPeerCache.put(int64(c.ID), "group", 0)
+		case *tg.Channel:
+			globalPeerCache.put(int64(c.ID), "channel", c.AccessHash)
+		}
+	}
+}
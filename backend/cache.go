@@ -0,0 +1,22 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"vi-tg/internal/staticmap"
+)
+
+// getCacheStats отдаёт метрики кеша тайлов карт (попадания/промахи, число
+// записей и занимаемое место на диске) для мониторинга и отладки квоты/TTL
+func (s *APIServer) getCacheStats(w http.ResponseWriter, r *http.Request) {
+	cache := staticmap.Cache()
+	if cache == nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(staticmap.TileCacheStats{})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cache.Stats())
+}
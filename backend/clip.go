@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// clipJob отслеживает прогресс одной операции извлечения фрагмента
+type clipJob struct {
+	mu       sync.Mutex
+	progress float64 // 0..1
+	done     bool
+	err      error
+}
+
+// clipJobs хранит активные джобы извлечения по идентификатору job=
+var clipJobs = struct {
+	sync.Mutex
+	m map[string]*clipJob
+}{m: make(map[string]*clipJob)}
+
+func newClipJob(jobID string) *clipJob {
+	job := &clipJob{}
+	clipJobs.Lock()
+	clipJobs.m[jobID] = job
+	clipJobs.Unlock()
+	return job
+}
+
+func getClipJob(jobID string) *clipJob {
+	clipJobs.Lock()
+	defer clipJobs.Unlock()
+	return clipJobs.m[jobID]
+}
+
+// findVideoPath ищет файл видео по ID с перебором известных расширений
+func findVideoPath(videoID int64) string {
+	for _, ext := range []string{".mp4", ".avi", ".mkv", ".mov", ".webm", ".flv"} {
+		path := fmt.Sprintf("/tmp/vi-tg_video_%d%s", videoID, ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// findAudioPath ищет файл аудио по ID с перебором известных расширений
+func findAudioPath(audioID int64) string {
+	for _, ext := range []string{".mp3", ".m4a", ".aac", ".wav", ".ogg", ".flac"} {
+		path := fmt.Sprintf("/tmp/vi-tg_audio_%d%s", audioID, ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// findVoicePath ищет файл голосового сообщения по ID с перебором известных расширений
+func findVoicePath(voiceID int64) string {
+	for _, ext := range []string{".ogg", ".oga", ".mp3", ".wav", ".m4a", ".aac"} {
+		path := fmt.Sprintf("/tmp/vi-tg_voice_%d%s", voiceID, ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// clipContentType возвращает Content-Type и расширение файла для формата клипа
+func clipContentType(format string) (string, string) {
+	switch format {
+	case "wav":
+		return "audio/wav", "wav"
+	case "mp4":
+		return "video/mp4", "mp4"
+	case "gif":
+		return "image/gif", "gif"
+	default:
+		return "audio/mpeg", "mp3"
+	}
+}
+
+// serveClip запускает ffmpeg на диапазон [start, end) исходного файла и стримит
+// результат клиенту, отменяя перекодирование при разрыве соединения
+func (s *APIServer) serveClip(w http.ResponseWriter, r *http.Request, srcPath, format string) {
+	start := r.URL.Query().Get("start")
+	if start == "" {
+		start = "0"
+	}
+	end := r.URL.Query().Get("end")
+	duration := r.URL.Query().Get("duration")
+
+	contentType, ext := clipContentType(format)
+
+	args := []string{"-ss", start}
+	if end != "" {
+		args = append(args, "-to", end)
+	} else if duration != "" {
+		args = append(args, "-t", duration)
+	}
+	args = append(args, "-i", srcPath, "-f", format, "pipe:1")
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		s.sendError(w, "Ошибка запуска ffmpeg", http.StatusInternalServerError)
+		return
+	}
+
+	jobID := r.URL.Query().Get("job")
+	var job *clipJob
+	if jobID != "" {
+		job = newClipJob(jobID)
+	}
+
+	if err := cmd.Start(); err != nil {
+		s.sendError(w, "Ошибка запуска ffmpeg", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=clip.%s", ext))
+
+	if job != nil {
+		job.mu.Lock()
+		job.progress = 0.1
+		job.mu.Unlock()
+	}
+
+	if _, err := io.Copy(w, stdout); err != nil {
+		cancel()
+	}
+
+	waitErr := cmd.Wait()
+
+	if job != nil {
+		job.mu.Lock()
+		job.done = true
+		if waitErr != nil && ctx.Err() == nil {
+			job.err = waitErr
+			job.progress = 0
+		} else {
+			job.progress = 1
+		}
+		job.mu.Unlock()
+	}
+}
+
+// getVideoClip обрабатывает GET /api/videos/{video_id}/clip
+func (s *APIServer) getVideoClip(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	videoID, err := strconv.ParseInt(vars["video_id"], 10, 64)
+	if err != nil {
+		s.sendError(w, "Неверный ID видео", http.StatusBadRequest)
+		return
+	}
+
+	videoPath := findVideoPath(videoID)
+	if videoPath == "" {
+		s.sendError(w, "Видео не найдено", http.StatusNotFound)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "mp4"
+	}
+
+	s.serveClip(w, r, videoPath, format)
+}
+
+// getAudioClip обрабатывает GET /api/audios/{audio_id}/clip
+func (s *APIServer) getAudioClip(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	audioID, err := strconv.ParseInt(vars["audio_id"], 10, 64)
+	if err != nil {
+		s.sendError(w, "Неверный ID аудио сообщения", http.StatusBadRequest)
+		return
+	}
+
+	audioPath := findAudioPath(audioID)
+	if audioPath == "" {
+		s.sendError(w, "Аудио сообщение не найдено", http.StatusNotFound)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "mp3"
+	}
+
+	s.serveClip(w, r, audioPath, format)
+}
+
+// getVoiceClip обрабатывает GET /api/voices/{voice_id}/clip
+func (s *APIServer) getVoiceClip(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	voiceID, err := strconv.ParseInt(vars["voice_id"], 10, 64)
+	if err != nil {
+		s.sendError(w, "Неверный ID голосового сообщения", http.StatusBadRequest)
+		return
+	}
+
+	voicePath := findVoicePath(voiceID)
+	if voicePath == "" {
+		s.sendError(w, "Голосовое сообщение не найдено", http.StatusNotFound)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "mp3"
+	}
+
+	s.serveClip(w, r, voicePath, format)
+}
+
+// getAudioClipProgress отдаёт прогресс извлечения клипа через Server-Sent Events
+func (s *APIServer) getAudioClipProgress(w http.ResponseWriter, r *http.Request) {
+	jobID := r.URL.Query().Get("job")
+	if jobID == "" {
+		s.sendError(w, "Не указан job", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.sendError(w, "Streaming не поддерживается", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ticker := time.NewTicker(300 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			job := getClipJob(jobID)
+			if job == nil {
+				fmt.Fprintf(w, "event: error\ndata: {\"error\":\"job not found\"}\n\n")
+				flusher.Flush()
+				return
+			}
+
+			job.mu.Lock()
+			payload, _ := json.Marshal(map[string]interface{}{
+				"progress": job.progress,
+				"done":     job.done,
+			})
+			done := job.done
+			job.mu.Unlock()
+
+			fmt.Fprintf(w, "data: %s\n\n", payload)
+			flusher.Flush()
+
+			if done {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+
+	"vi-tg/auth"
+)
+
+// wsUpgrader настраивает апгрейд соединения до WebSocket; Origin не проверяем,
+// т.к. сервер и так открыт для всех источников через CORS
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// getEvents отдаёт события шины пакета auth как Server-Sent Events: авторизация,
+// новые/отредактированные/прочитанные сообщения, набор текста и скачанное медиа.
+// Поддерживает переподключение через заголовок Last-Event-ID
+func (s *APIServer) getEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.sendError(w, "Потоковая передача не поддерживается", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	bus := auth.Events()
+
+	if lastID, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		for _, evt := range bus.Since(lastID) {
+			writeSSEEvent(w, evt)
+		}
+		flusher.Flush()
+	}
+
+	ch, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case evt, ok := <-ch:
+			if !ok {
+				return
+			}
+			writeSSEEvent(w, evt)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSEEvent сериализует событие шины в формат text/event-stream
+func writeSSEEvent(w http.ResponseWriter, evt auth.Event) {
+	data, err := json.Marshal(evt.Data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", evt.ID, evt.Type, data)
+}
+
+// getWS предоставляет тот же поток событий по WebSocket вместо SSE и, в
+// отличие от /api/events, читает входящие сообщения клиента — это нужно
+// для двунаправленных сценариев вроде typing-индикаторов и read receipts
+func (s *APIServer) getWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Ошибка апгрейда WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	bus := auth.Events()
+	ch, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case evt, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := conn.WriteJSON(evt); err != nil {
+				return
+			}
+		case <-done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
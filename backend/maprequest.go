@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strconv"
+
+	"vi-tg/internal/staticmap"
+)
+
+// mapCenterRequest — явный центр карты в запросе на построение карты с
+// произвольной геометрией
+type mapCenterRequest struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+type mapLatLngRequest struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+type mapMarkerRequest struct {
+	Lat         float64 `json:"lat"`
+	Lng         float64 `json:"lng"`
+	Color       string  `json:"color,omitempty"`
+	Radius      float64 `json:"radius,omitempty"`
+	StrokeColor string  `json:"stroke_color,omitempty"`
+	StrokeWidth float64 `json:"stroke_width,omitempty"`
+	Label       string  `json:"label,omitempty"`
+}
+
+type mapPathRequest struct {
+	Points      []mapLatLngRequest `json:"points"`
+	StrokeColor string             `json:"stroke_color,omitempty"`
+	StrokeWidth float64            `json:"stroke_width,omitempty"`
+}
+
+type mapAreaRequest struct {
+	Points      []mapLatLngRequest `json:"points"`
+	FillColor   string             `json:"fill_color,omitempty"`
+	StrokeColor string             `json:"stroke_color,omitempty"`
+	StrokeWidth float64            `json:"stroke_width,omitempty"`
+}
+
+type mapCircleRequest struct {
+	Lat          float64 `json:"lat"`
+	Lng          float64 `json:"lng"`
+	RadiusMeters float64 `json:"radius_meters"`
+	FillColor    string  `json:"fill_color,omitempty"`
+	StrokeColor  string  `json:"stroke_color,omitempty"`
+	StrokeWidth  float64 `json:"stroke_width,omitempty"`
+}
+
+// mapOverlayRequest — тело POST-запроса на построение карты с произвольным
+// набором маркеров/путей/областей/окружностей. Center и Zoom необязательны —
+// если ни то ни другое не задано, Context сам подбирает их по объектам
+// (см. internal/staticmap.Context.Render)
+type mapOverlayRequest struct {
+	Center   *mapCenterRequest  `json:"center,omitempty"`
+	Zoom     int                `json:"zoom,omitempty"`
+	Width    int                `json:"width,omitempty"`
+	Height   int                `json:"height,omitempty"`
+	Provider string             `json:"provider,omitempty"`
+	Overlay  []string           `json:"overlay,omitempty"`
+	Markers  []mapMarkerRequest `json:"markers,omitempty"`
+	Paths    []mapPathRequest   `json:"paths,omitempty"`
+	Areas    []mapAreaRequest   `json:"areas,omitempty"`
+	Circles  []mapCircleRequest `json:"circles,omitempty"`
+}
+
+// postLocationMap рендерит карту произвольного размера с набором маркеров,
+// путей, закрашенных областей и окружностей, описанным в теле запроса JSON.
+// В отличие от getLocationMap результат не кешируется на диске — набор
+// объектов каждый раз свой, а сам рендер недорог за счёт кеша тайлов
+func (s *APIServer) postLocationMap(w http.ResponseWriter, r *http.Request) {
+	var req mapOverlayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, "Неверный формат запроса", http.StatusBadRequest)
+		return
+	}
+
+	width, height := 600, 400
+	if req.Width > 0 {
+		width = req.Width
+	}
+	if req.Height > 0 {
+		height = req.Height
+	}
+
+	mapCtx := staticmap.NewContext().
+		SetProvider(s.resolveTileProvider(req.Provider)).
+		SetSize(width, height)
+
+	if req.Center != nil {
+		mapCtx.SetCenter(req.Center.Lat, req.Center.Lng)
+	}
+	if req.Zoom > 0 {
+		mapCtx.SetZoom(req.Zoom)
+	}
+
+	for _, name := range req.Overlay {
+		if overlay, ok := staticmap.Provider(name); ok {
+			mapCtx.AddOverlay(overlay)
+		}
+	}
+
+	for _, m := range req.Markers {
+		mapCtx.AddMarker(staticmap.Marker{
+			Lat:         m.Lat,
+			Lng:         m.Lng,
+			Color:       parseHexColor(m.Color),
+			Radius:      m.Radius,
+			StrokeColor: parseHexColor(m.StrokeColor),
+			StrokeWidth: m.StrokeWidth,
+			Label:       m.Label,
+		})
+	}
+	for _, p := range req.Paths {
+		mapCtx.AddPath(staticmap.Path{
+			Points:      toLatLngs(p.Points),
+			StrokeColor: parseHexColor(p.StrokeColor),
+			StrokeWidth: p.StrokeWidth,
+		})
+	}
+	for _, a := range req.Areas {
+		mapCtx.AddArea(staticmap.Area{
+			Points:      toLatLngs(a.Points),
+			FillColor:   parseHexColor(a.FillColor),
+			StrokeColor: parseHexColor(a.StrokeColor),
+			StrokeWidth: a.StrokeWidth,
+		})
+	}
+	for _, c := range req.Circles {
+		mapCtx.AddCircle(staticmap.Circle{
+			Lat:          c.Lat,
+			Lng:          c.Lng,
+			RadiusMeters: c.RadiusMeters,
+			FillColor:    parseHexColor(c.FillColor),
+			StrokeColor:  parseHexColor(c.StrokeColor),
+			StrokeWidth:  c.StrokeWidth,
+		})
+	}
+
+	img, err := mapCtx.Render()
+	if err != nil {
+		s.sendError(w, "Ошибка рендеринга карты", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, img)
+}
+
+// toLatLngs конвертирует точки пути/области из формата запроса в
+// internal/staticmap.LatLng
+func toLatLngs(points []mapLatLngRequest) []staticmap.LatLng {
+	result := make([]staticmap.LatLng, len(points))
+	for i, p := range points {
+		result[i] = staticmap.LatLng{Lat: p.Lat, Lng: p.Lng}
+	}
+	return result
+}
+
+// parseHexColor разбирает цвет вида "#RRGGBB" или "#RRGGBBAA"; пустая или
+// некорректная строка возвращает nil — вызывающий код сам подставляет
+// дефолтный цвет для конкретного типа объекта
+func parseHexColor(s string) color.Color {
+	if s == "" {
+		return nil
+	}
+	if s[0] == '#' {
+		s = s[1:]
+	}
+
+	switch len(s) {
+	case 6:
+		r, err1 := strconv.ParseUint(s[0:2], 16, 8)
+		g, err2 := strconv.ParseUint(s[2:4], 16, 8)
+		b, err3 := strconv.ParseUint(s[4:6], 16, 8)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return nil
+		}
+		return color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: 255}
+	case 8:
+		r, err1 := strconv.ParseUint(s[0:2], 16, 8)
+		g, err2 := strconv.ParseUint(s[2:4], 16, 8)
+		b, err3 := strconv.ParseUint(s[4:6], 16, 8)
+		a, err4 := strconv.ParseUint(s[6:8], 16, 8)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			return nil
+		}
+		return color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: uint8(a)}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+// storeMediaPath ищет путь к локальному файлу в хранилище метаданных.
+// Возвращает пустую строку, если хранилище не подключено или записи нет —
+// в этом случае вызывающий код должен откатиться на сканирование /tmp.
+func (s *APIServer) storeMediaPath(kind string, id int64) string {
+	if s.store == nil {
+		return ""
+	}
+
+	media, err := s.store.GetMedia(kind, id)
+	if err != nil || media == nil {
+		return ""
+	}
+
+	return media.LocalPath
+}
+
+func contentTypeForImageExt(ext string) string {
+	switch ext {
+	case ".png":
+		return "image/png"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".webp":
+		return "image/webp"
+	case ".gif":
+		return "image/gif"
+	default:
+		return "image/png"
+	}
+}
+
+func contentTypeForVideoExt(ext string) string {
+	switch ext {
+	case ".mp4":
+		return "video/mp4"
+	case ".avi":
+		return "video/x-msvideo"
+	case ".mkv":
+		return "video/x-matroska"
+	case ".mov":
+		return "video/quicktime"
+	case ".webm":
+		return "video/webm"
+	case ".flv":
+		return "video/x-flv"
+	default:
+		return "video/mp4"
+	}
+}
+
+func contentTypeForVoiceExt(ext string) string {
+	switch ext {
+	case ".ogg", ".oga":
+		return "audio/ogg"
+	case ".mp3":
+		return "audio/mpeg"
+	case ".wav":
+		return "audio/wav"
+	case ".m4a":
+		return "audio/mp4"
+	case ".aac":
+		return "audio/aac"
+	default:
+		return "audio/ogg"
+	}
+}
+
+func contentTypeForAudioExt(ext string) string {
+	switch ext {
+	case ".mp3":
+		return "audio/mpeg"
+	case ".m4a":
+		return "audio/mp4"
+	case ".aac":
+		return "audio/aac"
+	case ".wav":
+		return "audio/wav"
+	case ".ogg":
+		return "audio/ogg"
+	case ".flac":
+		return "audio/flac"
+	default:
+		return "audio/mpeg"
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultPeaksBins = 100
+
+// loadMTProtoWaveform читает waveform, сохранённый auth-пакетом при скачивании
+// голосового сообщения, и передискретизирует его до нужного числа bins
+func loadMTProtoWaveform(voiceID int64, bins int) ([]float64, bool) {
+	path := fmt.Sprintf("/tmp/vi-tg_waveform_%d.json", voiceID)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var raw []float64
+	if err := json.Unmarshal(data, &raw); err != nil || len(raw) == 0 {
+		return nil, false
+	}
+
+	if len(raw) == bins {
+		return raw, true
+	}
+
+	resampled := make([]float64, bins)
+	samplesPerBin := float64(len(raw)) / float64(bins)
+	for i := 0; i < bins; i++ {
+		start := int(float64(i) * samplesPerBin)
+		end := int(float64(i+1) * samplesPerBin)
+		if end > len(raw) {
+			end = len(raw)
+		}
+		if start >= end {
+			resampled[i] = 0
+			continue
+		}
+
+		maxVal := 0.0
+		for _, v := range raw[start:end] {
+			if v > maxVal {
+				maxVal = v
+			}
+		}
+		resampled[i] = maxVal
+	}
+
+	return resampled, true
+}
+
+// peaksCachePath возвращает путь кеша посчитанных пиков для источника и числа бинов
+func peaksCachePath(kind string, id int64, bins int) string {
+	return fmt.Sprintf("/tmp/vi-tg_peaks_%s_%d_%d.json", kind, id, bins)
+}
+
+// computePeaks декодирует srcPath в 16-битный mono PCM через ffmpeg и считает
+// максимум по модулю амплитуды для каждого из bins равных отрезков
+func computePeaks(srcPath string, bins int) ([]float64, error) {
+	cmd := exec.Command("ffmpeg", "-i", srcPath, "-f", "s16le", "-ac", "1", "-ar", "8000", "pipe:1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запуска ffmpeg: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("ошибка запуска ffmpeg: %w", err)
+	}
+
+	var samples []int16
+	buf := make([]byte, 4096)
+	for {
+		n, readErr := stdout.Read(buf)
+		for i := 0; i+1 < n; i += 2 {
+			samples = append(samples, int16(binary.LittleEndian.Uint16(buf[i:i+2])))
+		}
+		if readErr != nil {
+			break
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("ffmpeg завершился с ошибкой: %w", err)
+	}
+
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("не удалось получить сэмплы из %s", srcPath)
+	}
+
+	peaks := make([]float64, bins)
+	samplesPerBin := len(samples) / bins
+	if samplesPerBin == 0 {
+		samplesPerBin = 1
+	}
+
+	for i := 0; i < bins; i++ {
+		start := i * samplesPerBin
+		end := start + samplesPerBin
+		if start >= len(samples) {
+			break
+		}
+		if end > len(samples) {
+			end = len(samples)
+		}
+
+		maxAbs := 0
+		for _, s := range samples[start:end] {
+			abs := int(math.Abs(float64(s)))
+			if abs > maxAbs {
+				maxAbs = abs
+			}
+		}
+		peaks[i] = float64(maxAbs) / float64(math.MaxInt16)
+	}
+
+	return peaks, nil
+}
+
+// servePeaks считает (или берёт из кеша) нормализованные амплитуды для srcPath
+// и отдаёт их как JSON-массив
+func (s *APIServer) servePeaks(w http.ResponseWriter, r *http.Request, kind string, id int64, srcPath string) {
+	binsStr := r.URL.Query().Get("bins")
+	bins := defaultPeaksBins
+	if binsStr != "" {
+		if parsed, err := strconv.Atoi(binsStr); err == nil && parsed > 0 {
+			bins = parsed
+		}
+	}
+
+	cachePath := peaksCachePath(kind, id, bins)
+	if data, err := os.ReadFile(cachePath); err == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+		return
+	}
+
+	var peaks []float64
+	if kind == "voice" {
+		if mtprotoPeaks, ok := loadMTProtoWaveform(id, bins); ok {
+			peaks = mtprotoPeaks
+		}
+	}
+
+	if peaks == nil {
+		computed, err := computePeaks(srcPath, bins)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("Ошибка вычисления waveform: %v", err), http.StatusInternalServerError)
+			return
+		}
+		peaks = computed
+	}
+
+	data, err := json.Marshal(peaks)
+	if err != nil {
+		s.sendError(w, "Ошибка сериализации waveform", http.StatusInternalServerError)
+		return
+	}
+
+	os.WriteFile(cachePath, data, 0644)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// getVoicePeaks обрабатывает GET /api/voices/{voice_id}/peaks
+func (s *APIServer) getVoicePeaks(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	voiceID, err := strconv.ParseInt(vars["voice_id"], 10, 64)
+	if err != nil {
+		s.sendError(w, "Неверный ID голосового сообщения", http.StatusBadRequest)
+		return
+	}
+
+	voicePath := findVoicePath(voiceID)
+	if voicePath == "" {
+		s.sendError(w, "Голосовое сообщение не найдено", http.StatusNotFound)
+		return
+	}
+
+	s.servePeaks(w, r, "voice", voiceID, voicePath)
+}
+
+// getAudioPeaks обрабатывает GET /api/audios/{audio_id}/peaks
+func (s *APIServer) getAudioPeaks(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	audioID, err := strconv.ParseInt(vars["audio_id"], 10, 64)
+	if err != nil {
+		s.sendError(w, "Неверный ID аудио сообщения", http.StatusBadRequest)
+		return
+	}
+
+	audioPath := findAudioPath(audioID)
+	if audioPath == "" {
+		s.sendError(w, "Аудио сообщение не найдено", http.StatusNotFound)
+		return
+	}
+
+	s.servePeaks(w, r, "audio", audioID, audioPath)
+}
@@ -1,35 +1,37 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"image"
 	"image/color"
 	"image/png"
-	"io"
 	"log"
-	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/fogleman/gg"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
+	"golang.org/x/image/font/basicfont"
 
 	"vi-tg/auth"
 	"vi-tg/config"
+	"vi-tg/internal/staticmap"
+	"vi-tg/store"
 )
 
 type APIServer struct {
 	mtproto *auth.MTProtoClient
 	config  *config.Config
 	ctx     context.Context
+	store   *store.Store
 }
 
 // API Response types
@@ -133,10 +135,25 @@ func NewAPIServer() *APIServer {
 
 	mtproto := auth.NewMTProtoClient()
 
+	mediaStore, err := store.Open()
+	if err != nil {
+		log.Fatal("Ошибка открытия хранилища:", err)
+	}
+	auth.SetStore(mediaStore)
+
+	tileCacheDir := filepath.Join(os.TempDir(), "vi-tg-tile-cache")
+	fsCache := staticmap.NewFSTileCache(
+		tileCacheDir,
+		time.Duration(cfg.TileCacheTTLHours)*time.Hour,
+		int64(cfg.TileCacheQuotaMB)*1024*1024,
+	)
+	staticmap.SetCache(staticmap.NewMemoryLRUTileCache(fsCache, 256))
+
 	return &APIServer{
 		mtproto: mtproto,
 		config:  cfg,
 		ctx:     context.Background(),
+		store:   mediaStore,
 	}
 }
 
@@ -155,6 +172,11 @@ func (s *APIServer) Start() error {
 	api.HandleFunc("/chats", s.getChats).Methods("GET")
 	api.HandleFunc("/chats/{chat_id}/messages", s.getMessages).Methods("GET")
 	api.HandleFunc("/chats/{chat_id}/messages", s.sendMessage).Methods("POST")
+	api.HandleFunc("/chats/{chat_id}/voice", s.uploadVoice).Methods("POST")
+	api.HandleFunc("/chats/{chat_id}/audio", s.uploadAudio).Methods("POST")
+	api.HandleFunc("/chats/{chat_id}/photo", s.uploadPhoto).Methods("POST")
+	api.HandleFunc("/chats/{chat_id}/video", s.uploadVideo).Methods("POST")
+	api.HandleFunc("/chats/{chat_id}/location", s.uploadLocation).Methods("POST")
 
 	// Sticker endpoints
 	api.HandleFunc("/stickers/{sticker_id}", s.getSticker).Methods("GET")
@@ -164,16 +186,30 @@ func (s *APIServer) Start() error {
 
 	// Video endpoints
 	api.HandleFunc("/videos/{video_id}", s.getVideo).Methods("GET")
+	api.HandleFunc("/videos/{video_id}/clip", s.getVideoClip).Methods("GET")
 
 	// Voice endpoints
 	api.HandleFunc("/voices/{voice_id}", s.getVoice).Methods("GET")
+	api.HandleFunc("/voices/{voice_id}/clip", s.getVoiceClip).Methods("GET")
+	api.HandleFunc("/voices/{voice_id}/peaks", s.getVoicePeaks).Methods("GET")
 
 	// Audio endpoints
 	api.HandleFunc("/audios/{audio_id}", s.getAudio).Methods("GET")
+	api.HandleFunc("/audios/{audio_id}/clip", s.getAudioClip).Methods("GET")
+	api.HandleFunc("/audios/{audio_id}/clip/progress", s.getAudioClipProgress).Methods("GET")
+	api.HandleFunc("/audios/{audio_id}/peaks", s.getAudioPeaks).Methods("GET")
 
 	// Location endpoints
 	api.HandleFunc("/locations/{location_id}", s.getLocation).Methods("GET")
 	api.HandleFunc("/locations/{location_id}/map", s.getLocationMap).Methods("GET")
+	api.HandleFunc("/locations/{location_id}/map", s.postLocationMap).Methods("POST")
+
+	// Events endpoints (SSE и WebSocket вместо опроса и файлов-сигналов)
+	api.HandleFunc("/events", s.getEvents).Methods("GET")
+	api.HandleFunc("/ws", s.getWS).Methods("GET")
+
+	// Cache endpoints
+	api.HandleFunc("/cache/stats", s.getCacheStats).Methods("GET")
 
 	// Health check
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -221,12 +257,7 @@ func (s *APIServer) getAuthStatus(w http.ResponseWriter, r *http.Request) {
 	// Проверяем состояние авторизации
 	authorized := s.mtproto.IsAuthorized()
 	phoneNumber := s.config.PhoneNumber
-
-	// Проверяем, нужен ли код подтверждения
-	needsCode := false
-	if _, err := os.Stat("/tmp/vi-tg-needs-code"); err == nil {
-		needsCode = true
-	}
+	needsCode := auth.NeedsCode()
 
 	response := AuthStatusResponse{
 		Authorized:  authorized,
@@ -286,12 +317,8 @@ func (s *APIServer) sendCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Записываем код в файл для MTProto клиента
-	codeFile := "/tmp/vi-tg-auth-code"
-	if err := os.WriteFile(codeFile, []byte(req.Code), 0644); err != nil {
-		s.sendError(w, "Ошибка записи кода", http.StatusInternalServerError)
-		return
-	}
+	// Передаём код ожидающему ConsoleAuth.Code через канал вместо файла-сигнала
+	s.mtproto.SetAuthCode(req.Code)
 
 	// Ждем некоторое время для обработки кода
 	time.Sleep(2 * time.Second)
@@ -334,6 +361,16 @@ func (s *APIServer) getChats(w http.ResponseWriter, r *http.Request) {
 			chat.LastMessage = &dialog.LastMsg
 		}
 
+		if s.store != nil {
+			s.store.UpsertChat(store.Chat{
+				ID:          dialog.ID,
+				Title:       dialog.Title,
+				Type:        dialog.Type,
+				Unread:      dialog.Unread,
+				LastMessage: dialog.LastMsg,
+			})
+		}
+
 		chats = append(chats, chat)
 	}
 
@@ -525,6 +562,17 @@ func (s *APIServer) getMessages(w http.ResponseWriter, r *http.Request) {
 			msgResponse.LocationMapPath = &mapPath
 		}
 
+		if s.store != nil {
+			s.store.UpsertMessage(store.MessageRecord{
+				ID:        msg.ID,
+				ChatID:    msg.ChatID,
+				Text:      msg.Text,
+				From:      msg.From,
+				Type:      msg.Type,
+				Timestamp: msg.Timestamp,
+			})
+		}
+
 		messageResponses = append(messageResponses, msgResponse)
 	}
 
@@ -585,14 +633,17 @@ func (s *APIServer) getSticker(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Ищем файл стикера
-	stickerPath := fmt.Sprintf("/tmp/vi-tg_sticker_%d.webp", stickerID)
-	if _, err := os.Stat(stickerPath); err != nil {
-		// Пробуем PNG версию
-		stickerPath = fmt.Sprintf("/tmp/vi-tg_sticker_%d.png", stickerID)
+	// Сначала смотрим в хранилище метаданных, и только если там пусто — сканируем /tmp
+	stickerPath := s.storeMediaPath("sticker", stickerID)
+	if stickerPath == "" {
+		stickerPath = fmt.Sprintf("/tmp/vi-tg_sticker_%d.webp", stickerID)
 		if _, err := os.Stat(stickerPath); err != nil {
-			s.sendError(w, "Стикер не найден", http.StatusNotFound)
-			return
+			// Пробуем PNG версию
+			stickerPath = fmt.Sprintf("/tmp/vi-tg_sticker_%d.png", stickerID)
+			if _, err := os.Stat(stickerPath); err != nil {
+				s.sendError(w, "Стикер не найден", http.StatusNotFound)
+				return
+			}
 		}
 	}
 
@@ -627,29 +678,23 @@ func (s *APIServer) getImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Ищем файл изображения с различными расширениями
-	possibleExtensions := []string{".png", ".jpg", ".jpeg", ".webp", ".gif"}
-	var imagePath string
+	// Сначала смотрим в хранилище метаданных, и только если там пусто — сканируем /tmp
+	imagePath := s.storeMediaPath("photo", imageID)
 	var contentType string
 
-	for _, ext := range possibleExtensions {
-		testPath := fmt.Sprintf("/tmp/vi-tg_image_%d%s", imageID, ext)
-		if _, err := os.Stat(testPath); err == nil {
-			imagePath = testPath
-			// Определяем MIME тип на основе расширения
-			switch ext {
-			case ".png":
-				contentType = "image/png"
-			case ".jpg", ".jpeg":
-				contentType = "image/jpeg"
-			case ".webp":
-				contentType = "image/webp"
-			case ".gif":
-				contentType = "image/gif"
-			default:
-				contentType = "image/png"
+	if imagePath != "" {
+		contentType = contentTypeForImageExt(filepath.Ext(imagePath))
+	} else {
+		// Ищем файл изображения с различными расширениями
+		possibleExtensions := []string{".png", ".jpg", ".jpeg", ".webp", ".gif"}
+
+		for _, ext := range possibleExtensions {
+			testPath := fmt.Sprintf("/tmp/vi-tg_image_%d%s", imageID, ext)
+			if _, err := os.Stat(testPath); err == nil {
+				imagePath = testPath
+				contentType = contentTypeForImageExt(ext)
+				break
 			}
-			break
 		}
 	}
 
@@ -683,33 +728,22 @@ func (s *APIServer) getVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Ищем файл видео с различными расширениями
-	videoExtensions := []string{".mp4", ".avi", ".mkv", ".mov", ".webm", ".flv"}
-	var videoPath string
+	// Сначала смотрим в хранилище метаданных, и только если там пусто — сканируем /tmp
+	videoPath := s.storeMediaPath("video", videoID)
 	var contentType string
 
-	for _, ext := range videoExtensions {
-		testPath := fmt.Sprintf("/tmp/vi-tg_video_%d%s", videoID, ext)
-		if _, err := os.Stat(testPath); err == nil {
-			videoPath = testPath
-			// Определяем MIME тип на основе расширения
-			switch ext {
-			case ".mp4":
-				contentType = "video/mp4"
-			case ".avi":
-				contentType = "video/x-msvideo"
-			case ".mkv":
-				contentType = "video/x-matroska"
-			case ".mov":
-				contentType = "video/quicktime"
-			case ".webm":
-				contentType = "video/webm"
-			case ".flv":
-				contentType = "video/x-flv"
-			default:
-				contentType = "video/mp4"
+	if videoPath != "" {
+		contentType = contentTypeForVideoExt(filepath.Ext(videoPath))
+	} else {
+		videoExtensions := []string{".mp4", ".avi", ".mkv", ".mov", ".webm", ".flv"}
+
+		for _, ext := range videoExtensions {
+			testPath := fmt.Sprintf("/tmp/vi-tg_video_%d%s", videoID, ext)
+			if _, err := os.Stat(testPath); err == nil {
+				videoPath = testPath
+				contentType = contentTypeForVideoExt(ext)
+				break
 			}
-			break
 		}
 	}
 
@@ -718,20 +752,9 @@ func (s *APIServer) getVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Читаем файл
-	data, err := os.ReadFile(videoPath)
-	if err != nil {
-		s.sendError(w, "Ошибка чтения файла видео", http.StatusInternalServerError)
-		return
-	}
-
-	// Устанавливаем заголовки
-	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
-	w.Header().Set("Cache-Control", "public, max-age=3600")
-
-	// Отправляем данные
-	w.Write(data)
+	// Отдаём файл с поддержкой Range-запросов (перемотка в браузере) и
+	// опциональным перекодированием форматов, которые браузер не проигрывает нативно
+	s.serveMediaFile(w, r, videoPath, contentType, "video")
 }
 
 func (s *APIServer) getVoice(w http.ResponseWriter, r *http.Request) {
@@ -743,31 +766,22 @@ func (s *APIServer) getVoice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Ищем файл голосового сообщения с различными расширениями
-	voiceExtensions := []string{".ogg", ".oga", ".mp3", ".wav", ".m4a", ".aac"}
-	var voicePath string
+	// Сначала смотрим в хранилище метаданных, и только если там пусто — сканируем /tmp
+	voicePath := s.storeMediaPath("voice", voiceID)
 	var contentType string
 
-	for _, ext := range voiceExtensions {
-		testPath := fmt.Sprintf("/tmp/vi-tg_voice_%d%s", voiceID, ext)
-		if _, err := os.Stat(testPath); err == nil {
-			voicePath = testPath
-			// Определяем MIME тип на основе расширения
-			switch ext {
-			case ".ogg", ".oga":
-				contentType = "audio/ogg"
-			case ".mp3":
-				contentType = "audio/mpeg"
-			case ".wav":
-				contentType = "audio/wav"
-			case ".m4a":
-				contentType = "audio/mp4"
-			case ".aac":
-				contentType = "audio/aac"
-			default:
-				contentType = "audio/ogg"
+	if voicePath != "" {
+		contentType = contentTypeForVoiceExt(filepath.Ext(voicePath))
+	} else {
+		voiceExtensions := []string{".ogg", ".oga", ".mp3", ".wav", ".m4a", ".aac"}
+
+		for _, ext := range voiceExtensions {
+			testPath := fmt.Sprintf("/tmp/vi-tg_voice_%d%s", voiceID, ext)
+			if _, err := os.Stat(testPath); err == nil {
+				voicePath = testPath
+				contentType = contentTypeForVoiceExt(ext)
+				break
 			}
-			break
 		}
 	}
 
@@ -776,20 +790,7 @@ func (s *APIServer) getVoice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Читаем файл
-	data, err := os.ReadFile(voicePath)
-	if err != nil {
-		s.sendError(w, "Ошибка чтения файла голосового сообщения", http.StatusInternalServerError)
-		return
-	}
-
-	// Устанавливаем заголовки
-	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
-	w.Header().Set("Cache-Control", "public, max-age=3600")
-
-	// Отправляем данные
-	w.Write(data)
+	s.serveMediaFile(w, r, voicePath, contentType, "audio")
 }
 
 func (s *APIServer) getAudio(w http.ResponseWriter, r *http.Request) {
@@ -801,33 +802,22 @@ func (s *APIServer) getAudio(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Ищем файл аудио сообщения с различными расширениями
-	audioExtensions := []string{".mp3", ".m4a", ".aac", ".wav", ".ogg", ".flac"}
-	var audioPath string
+	// Сначала смотрим в хранилище метаданных, и только если там пусто — сканируем /tmp
+	audioPath := s.storeMediaPath("audio", audioID)
 	var contentType string
 
-	for _, ext := range audioExtensions {
-		testPath := fmt.Sprintf("/tmp/vi-tg_audio_%d%s", audioID, ext)
-		if _, err := os.Stat(testPath); err == nil {
-			audioPath = testPath
-			// Определяем MIME тип на основе расширения
-			switch ext {
-			case ".mp3":
-				contentType = "audio/mpeg"
-			case ".m4a":
-				contentType = "audio/mp4"
-			case ".aac":
-				contentType = "audio/aac"
-			case ".wav":
-				contentType = "audio/wav"
-			case ".ogg":
-				contentType = "audio/ogg"
-			case ".flac":
-				contentType = "audio/flac"
-			default:
-				contentType = "audio/mpeg"
+	if audioPath != "" {
+		contentType = contentTypeForAudioExt(filepath.Ext(audioPath))
+	} else {
+		audioExtensions := []string{".mp3", ".m4a", ".aac", ".wav", ".ogg", ".flac"}
+
+		for _, ext := range audioExtensions {
+			testPath := fmt.Sprintf("/tmp/vi-tg_audio_%d%s", audioID, ext)
+			if _, err := os.Stat(testPath); err == nil {
+				audioPath = testPath
+				contentType = contentTypeForAudioExt(ext)
+				break
 			}
-			break
 		}
 	}
 
@@ -836,20 +826,7 @@ func (s *APIServer) getAudio(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Читаем файл
-	data, err := os.ReadFile(audioPath)
-	if err != nil {
-		s.sendError(w, "Ошибка чтения файла аудио сообщения", http.StatusInternalServerError)
-		return
-	}
-
-	// Устанавливаем заголовки
-	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
-	w.Header().Set("Cache-Control", "public, max-age=3600")
-
-	// Отправляем данные
-	w.Write(data)
+	s.serveMediaFile(w, r, audioPath, contentType, "audio")
 }
 
 func (s *APIServer) getLocation(w http.ResponseWriter, r *http.Request) {
@@ -861,14 +838,24 @@ func (s *APIServer) getLocation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// For now, return mock location data
-	// In a real implementation, this would fetch from a database or cache
+	// Если локация сохранена в хранилище — берём реальные координаты,
+	// иначе отдаём мок (Красная площадь) как и раньше
+	lat, lng := 55.7558, 37.6173
+	title, address := "Red Square", "Red Square, Moscow, Russia"
+
+	if s.store != nil {
+		if media, err := s.store.GetMedia("location", locationID); err == nil && media != nil {
+			lat, lng = media.Lat, media.Lng
+			title, address = "", ""
+		}
+	}
+
 	location := map[string]interface{}{
 		"id":        locationID,
-		"latitude":  55.7558,
-		"longitude": 37.6173,
-		"title":     "Red Square",
-		"address":   "Red Square, Moscow, Russia",
+		"latitude":  lat,
+		"longitude": lng,
+		"title":     title,
+		"address":   address,
 		"map_path":  fmt.Sprintf("/tmp/vi-tg_location_map_%d.png", locationID),
 	}
 
@@ -905,15 +892,61 @@ func (s *APIServer) getLocationMap(w http.ResponseWriter, r *http.Request) {
 		lng = 37.6173
 	}
 
-	// Ищем файл карты
-	mapPath := fmt.Sprintf("/tmp/vi-tg_location_map_%d.png", locationID)
-	if _, err := os.Stat(mapPath); err != nil {
+	zoom := 15
+	if zoomStr := r.URL.Query().Get("zoom"); zoomStr != "" {
+		if parsed, err := strconv.Atoi(zoomStr); err == nil && parsed > 0 {
+			zoom = parsed
+		}
+	}
+
+	width, height := 600, 400
+	if sizeStr := r.URL.Query().Get("size"); sizeStr != "" {
+		if w, h, ok := parseMapSize(sizeStr); ok {
+			width, height = w, h
+		}
+	}
+	if widthStr := r.URL.Query().Get("width"); widthStr != "" {
+		if parsed, err := strconv.Atoi(widthStr); err == nil && parsed > 0 {
+			width = parsed
+		}
+	}
+	if heightStr := r.URL.Query().Get("height"); heightStr != "" {
+		if parsed, err := strconv.Atoi(heightStr); err == nil && parsed > 0 {
+			height = parsed
+		}
+	}
+
+	providerName := r.URL.Query().Get("provider")
+
+	var overlayNames []string
+	if overlayStr := r.URL.Query().Get("overlay"); overlayStr != "" {
+		overlayNames = strings.Split(overlayStr, ",")
+	}
+
+	// Ищем файл карты; провайдер и оверлеи входят в имя, чтобы не путать кеш разных слоёв
+	mapPath := fmt.Sprintf("/tmp/vi-tg_location_map_%d_%d_%dx%d_%s_%s.png",
+		locationID, zoom, width, height, providerOrDefault(providerName), strings.Join(overlayNames, "+"))
+	info, err := os.Stat(mapPath)
+	if err != nil {
 		// Если файл карты не существует, создаем карту с реальными координатами
-		if err := s.generateLocationMap(locationID, lat, lng, mapPath); err != nil {
+		if err := s.generateLocationMap(lat, lng, zoom, width, height, providerName, overlayNames, mapPath); err != nil {
 			log.Printf("Error generating map: %v", err)
 			s.sendError(w, "Ошибка генерации карты", http.StatusInternalServerError)
 			return
 		}
+		info, err = os.Stat(mapPath)
+		if err != nil {
+			s.sendError(w, "Ошибка чтения файла карты", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Клиент уже загружал этот же вариант карты — отвечаем без тела
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		if t, err := http.ParseTime(ims); err == nil && !info.ModTime().After(t) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 	}
 
 	// Читаем файл карты
@@ -924,214 +957,133 @@ func (s *APIServer) getLocationMap(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Устанавливаем заголовки
+	mapSource := "tiles"
+	if _, err := os.Stat(mapPath + ".fallback"); err == nil {
+		mapSource = "fallback"
+	}
+
 	w.Header().Set("Content-Type", "image/png")
 	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
 	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	w.Header().Set("X-Map-Source", mapSource)
 
 	// Отправляем данные
 	w.Write(data)
 }
 
-func (s *APIServer) generateLocationMap(locationID int64, lat, lng float64, mapPath string) error {
-	// Use Yandex Maps API to generate a real map image
-	// Convert coordinates to tile numbers and fetch the map tile
-
-	// Yandex Maps API configuration
-	apiKey := "2a565807-86b7-4e0a-8170-edc9f6bbc99e"
-	zoom := 15 // Good zoom level for location details
-
-	// Convert lat/lng to tile coordinates
-	x, y := s.latLngToTileNumbers(lat, lng, zoom)
-
-	// Fetch map tile from Yandex Maps API
-	tileURL := fmt.Sprintf("https://tiles.api-maps.yandex.ru/v1/tiles/?&x=%d&y=%d&z=%d&lang=ru_RU&l=map&apikey=%s",
-		x, y, zoom, apiKey)
-
-	resp, err := http.Get(tileURL)
-	if err != nil {
-		log.Printf("Error fetching map tile: %v", err)
-		return s.generateFallbackMap(lat, lng, mapPath)
+// providerOrDefault возвращает имя провайдера для ключа кеша карты, заменяя
+// пустое значение (используется провайдер по умолчанию) на "default"
+func providerOrDefault(name string) string {
+	if name == "" {
+		return "default"
 	}
-	defer resp.Body.Close()
+	return name
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Yandex Maps API returned status: %d", resp.StatusCode)
-		return s.generateFallbackMap(lat, lng, mapPath)
+// parseMapSize разбирает строку вида "600x400" в ширину и высоту карты
+func parseMapSize(size string) (int, int, bool) {
+	parts := strings.SplitN(size, "x", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
 	}
 
-	// Read the tile image
-	tileData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading tile data: %v", err)
-		return s.generateFallbackMap(lat, lng, mapPath)
+	width, err := strconv.Atoi(parts[0])
+	if err != nil || width <= 0 {
+		return 0, 0, false
 	}
 
-	// Decode the tile image
-	tileImg, _, err := image.Decode(bytes.NewReader(tileData))
-	if err != nil {
-		log.Printf("Error decoding tile image: %v", err)
-		return s.generateFallbackMap(lat, lng, mapPath)
+	height, err := strconv.Atoi(parts[1])
+	if err != nil || height <= 0 {
+		return 0, 0, false
 	}
 
-	// Create a larger canvas for the final map
-	finalWidth := 400
-	finalHeight := 300
-	finalImg := image.NewRGBA(image.Rect(0, 0, finalWidth, finalHeight))
-
-	// Calculate position for the tile on the canvas (center it)
-	tileBounds := tileImg.Bounds()
-	tileWidth := tileBounds.Dx()
-	tileHeight := tileBounds.Dy()
-
-	tileX := (finalWidth - tileWidth) / 2
-	tileY := (finalHeight - tileHeight) / 2
+	return width, height, true
+}
 
-	// Draw the tile on the canvas
-	for y := 0; y < tileHeight; y++ {
-		for x := 0; x < tileWidth; x++ {
-			srcColor := tileImg.At(tileBounds.Min.X+x, tileBounds.Min.Y+y)
-			finalImg.Set(tileX+x, tileY+y, srcColor)
+// resolveTileProvider выбирает провайдер тайлов по имени из реестра
+// staticmap; пустое или неизвестное имя приводит к дефолту — серверу из
+// конфига (обратная совместимость с TileServerURL), а при его отсутствии к OSM
+func (s *APIServer) resolveTileProvider(name string) staticmap.TileProvider {
+	if name != "" {
+		if p, ok := staticmap.Provider(name); ok {
+			return p
 		}
 	}
-
-	// Add a marker at the exact location
-	s.addLocationMarker(finalImg, lat, lng, zoom, x, y, tileX, tileY)
-
-	// Save the final map image
-	file, err := os.Create(mapPath)
-	if err != nil {
-		return err
+	if s.config.TileServerURL != "" {
+		return staticmap.NewGenericProvider("config", s.config.TileServerURL, staticmap.DefaultTileSize, 19)
 	}
-	defer file.Close()
-
-	return png.Encode(file, finalImg)
+	return staticmap.NewOSMProvider()
 }
 
-// latLngToTileNumbers converts latitude/longitude to tile X,Y coordinates
-func (s *APIServer) latLngToTileNumbers(lat, lng float64, zoom int) (int, int) {
-	// Use proper WGS84 Mercator projection (same as JavaScript implementation)
-	e := 0.0818191908426 // WGS84 eccentricity
-
-	// Convert to radians
-	beta := lat * math.Pi / 180.0
-
-	// Calculate phi (accounts for ellipsoidal Earth)
-	phi := (1 - e*math.Sin(beta)) / (1 + e*math.Sin(beta))
-
-	// Calculate theta
-	theta := math.Tan(math.Pi/4+beta/2) * math.Pow(phi, e/2)
-
-	// Calculate pixel coordinates at zoom level
-	rho := math.Pow(2, float64(zoom)+8) / 2
-
-	xPixel := rho * (1 + lng/180)
-	yPixel := rho * (1 - math.Log(theta)/math.Pi)
-
-	// Convert to tile numbers
-	x := int(math.Floor(xPixel / 256))
-	y := int(math.Floor(yPixel / 256))
-
-	return x, y
-}
-
-// latLngToPixel converts latitude/longitude to pixel coordinates at given zoom level
-func (s *APIServer) latLngToPixel(lat, lng float64, zoom int) (float64, float64) {
-	// Use the same proper WGS84 Mercator projection as latLngToTileNumbers
-	e := 0.0818191908426 // WGS84 eccentricity
-
-	// Convert to radians
-	beta := lat * math.Pi / 180.0
-
-	// Calculate phi (accounts for ellipsoidal Earth)
-	phi := (1 - e*math.Sin(beta)) / (1 + e*math.Sin(beta))
-
-	// Calculate theta
-	theta := math.Tan(math.Pi/4+beta/2) * math.Pow(phi, e/2)
-
-	// Calculate pixel coordinates at zoom level (consistent with tile calculation)
-	rho := math.Pow(2, float64(zoom)+8) / 2
-
-	x := rho * (1 + lng/180)
-	y := rho * (1 - math.Log(theta)/math.Pi)
-
-	return x, y
-}
-
-// addLocationMarker adds a red marker at the exact location on the map
-func (s *APIServer) addLocationMarker(img *image.RGBA, lat, lng float64, zoom, tileX, tileY, offsetX, offsetY int) {
-	// Calculate pixel position within the tile
-	tilePixelX, tilePixelY := s.latLngToPixel(lat, lng, zoom)
-
-	// Calculate pixel position within this specific tile
-	pixelX := int(tilePixelX) - (tileX * 256)
-	pixelY := int(tilePixelY) - (tileY * 256)
-
-	// Position on the final image (centered tile + pixel offset)
-	markerX := offsetX + pixelX
-	markerY := offsetY + pixelY
-
-	// Draw a red circle marker
-	red := color.RGBA{255, 0, 0, 255}
-	radius := 10
-
-	for dy := -radius; dy <= radius; dy++ {
-		for dx := -radius; dx <= radius; dx++ {
-			if dx*dx+dy*dy <= radius*radius {
-				imgX := markerX + dx
-				imgY := markerY + dy
-
-				// Check bounds
-				if imgX >= 0 && imgX < img.Bounds().Dx() && imgY >= 0 && imgY < img.Bounds().Dy() {
-					img.Set(imgX, imgY, red)
-				}
-			}
+// generateLocationMap рендерит карту произвольного размера через
+// internal/staticmap, центрируя маркер локации точно в центре холста.
+// providerName выбирает базовый слой (osm, yandex, зарегистрированный
+// кастомный или конфиг по умолчанию), overlayNames — стек прозрачных
+// оверлеев (пробки, тематические слои), рисуемых поверх базового
+func (s *APIServer) generateLocationMap(lat, lng float64, zoom, width, height int, providerName string, overlayNames []string, mapPath string) error {
+	ctx := staticmap.NewContext().
+		SetProvider(s.resolveTileProvider(providerName)).
+		SetSize(width, height).
+		SetCenter(lat, lng).
+		SetZoom(zoom).
+		AddMarker(staticmap.Marker{Lat: lat, Lng: lng})
+
+	for _, name := range overlayNames {
+		if overlay, ok := staticmap.Provider(name); ok {
+			ctx.AddOverlay(overlay)
 		}
 	}
 
-	// Add a small black border around the marker
-	black := color.RGBA{0, 0, 0, 255}
-	borderRadius := radius + 2
-	for dy := -borderRadius; dy <= borderRadius; dy++ {
-		for dx := -borderRadius; dx <= borderRadius; dx++ {
-			if dx*dx+dy*dy <= borderRadius*borderRadius && dx*dx+dy*dy > radius*radius {
-				imgX := markerX + dx
-				imgY := markerY + dy
-
-				// Check bounds
-				if imgX >= 0 && imgX < img.Bounds().Dx() && imgY >= 0 && imgY < img.Bounds().Dy() {
-					img.Set(imgX, imgY, black)
-				}
-			}
+	finalImg, err := ctx.Render()
+	if err != nil {
+		log.Printf("Error rendering map: %v", err)
+		if fbErr := s.generateFallbackMap(lat, lng, zoom, mapPath); fbErr != nil {
+			return fbErr
 		}
+		return os.WriteFile(mapPath+".fallback", nil, 0644)
 	}
-}
-
-// generateFallbackMap creates a simple placeholder map when API fails
-func (s *APIServer) generateFallbackMap(lat, lng float64, mapPath string) error {
-	// Create a simple colored rectangle as a map placeholder
-	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
-	blue := color.RGBA{100, 150, 200, 255}
+	os.Remove(mapPath + ".fallback")
 
-	// Fill with blue color
-	for y := 0; y < img.Bounds().Dy(); y++ {
-		for x := 0; x < img.Bounds().Dx(); x++ {
-			img.Set(x, y, blue)
-		}
+	file, err := os.Create(mapPath)
+	if err != nil {
+		return err
 	}
+	defer file.Close()
 
-	// Add a simple marker (red dot)
-	centerX, centerY := 200, 150
-	markerColor := color.RGBA{255, 0, 0, 255}
-	for dy := -5; dy <= 5; dy++ {
-		for dx := -5; dx <= 5; dx++ {
-			if dx*dx+dy*dy <= 25 { // Circle
-				img.Set(centerX+dx, centerY+dy, markerColor)
-			}
-		}
-	}
+	return png.Encode(file, finalImg)
+}
 
-	// Add coordinates text (if we had a font system)
-	// For now, just save the image
+// generateFallbackMap рисует автономную заглушку карты для случаев, когда
+// тайловый сервер недоступен: лёгкую сетку параллелей/меридианов вокруг
+// точки, маркер-каплю с чёрной обводкой и подписи с координатами, зумом и
+// временем построения — вместо пустого синего прямоугольника пользователь
+// получает самодостаточное изображение с информацией о месте
+func (s *APIServer) generateFallbackMap(lat, lng float64, zoom int, mapPath string) error {
+	const width, height = 400, 300
+
+	dc := gg.NewContext(width, height)
+	dc.SetColor(color.RGBA{R: 230, G: 230, B: 225, A: 255})
+	dc.Clear()
+
+	dc.SetColor(color.RGBA{R: 200, G: 200, B: 195, A: 255})
+	dc.SetLineWidth(1)
+	for i := -4; i <= 4; i++ {
+		frac := float64(i) / 4
+		x := width/2 + frac*width/2
+		y := height/2 + frac*height/2
+		dc.DrawLine(x, 0, x, height)
+		dc.DrawLine(0, y, width, y)
+		dc.Stroke()
+	}
+
+	drawTeardropMarker(dc, width/2, height/2-20)
+
+	dc.SetFontFace(basicfont.Face7x13)
+	dc.SetColor(color.Black)
+	dc.DrawStringAnchored(fmt.Sprintf("%.4f, %.4f", lat, lng), width/2, height-48, 0.5, 0.5)
+	dc.DrawStringAnchored(fmt.Sprintf("Zoom: %d", zoom), width/2, height-32, 0.5, 0.5)
+	dc.DrawStringAnchored("Карта недоступна · "+time.Now().Format("2006-01-02 15:04:05"), width/2, height-16, 0.5, 0.5)
 
 	file, err := os.Create(mapPath)
 	if err != nil {
@@ -1139,7 +1091,31 @@ func (s *APIServer) generateFallbackMap(lat, lng float64, mapPath string) error
 	}
 	defer file.Close()
 
-	return png.Encode(file, img)
+	return png.Encode(file, dc.Image())
+}
+
+// drawTeardropMarker рисует маркер-каплю (классический пин карты) с чёрной
+// обводкой и белым кружком-отверстием сверху, остриём вниз в точке (x, y)
+func drawTeardropMarker(dc *gg.Context, x, y float64) {
+	const r = 14.0
+	tipY := y + r*1.8
+
+	dc.NewSubPath()
+	dc.MoveTo(x, tipY)
+	dc.LineTo(x-r*0.85, y-r*0.2)
+	dc.QuadraticTo(x-r*0.85, y-r*1.6, x, y-r*1.6)
+	dc.QuadraticTo(x+r*0.85, y-r*1.6, x+r*0.85, y-r*0.2)
+	dc.ClosePath()
+
+	dc.SetColor(color.RGBA{R: 220, G: 40, B: 40, A: 255})
+	dc.FillPreserve()
+	dc.SetLineWidth(2)
+	dc.SetColor(color.Black)
+	dc.Stroke()
+
+	dc.DrawCircle(x, y-r*0.9, r*0.35)
+	dc.SetColor(color.White)
+	dc.Fill()
 }
 
 func (s *APIServer) sendError(w http.ResponseWriter, message string, code int) {
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// transcodeCacheDir — каталог с результатами перекодирования, переживает запрос,
+// но не исходный файл (ключ кеша зависит от mtime+размера источника)
+const transcodeCacheDir = "/tmp/vi-tg_transcode_cache"
+
+// videoTranscodeExts контейнеры, которые браузеры не умеют проигрывать нативно
+var videoTranscodeExts = map[string]bool{
+	".mkv": true,
+	".avi": true,
+	".flv": true,
+	".mov": true,
+}
+
+// audioTranscodeExts форматы аудио, требующие перекодирования для <audio>
+var audioTranscodeExts = map[string]bool{
+	".oga":  true,
+	".flac": true,
+}
+
+// serveMediaFile отдаёт локальный файл с поддержкой Range-запросов, либо,
+// если запрошен ?transcode=1 и формат не поддерживается браузером, перекодирует
+// его через ffmpeg и отдаёт результат из кеша.
+func (s *APIServer) serveMediaFile(w http.ResponseWriter, r *http.Request, path, contentType, kind string) {
+	if r.URL.Query().Get("transcode") == "1" {
+		ext := filepath.Ext(path)
+		if (kind == "video" && videoTranscodeExts[ext]) || (kind == "audio" && audioTranscodeExts[ext]) {
+			s.serveTranscoded(w, r, path, kind)
+			return
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		s.sendError(w, "Ошибка открытия файла", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		s.sendError(w, "Ошибка чтения файла", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	http.ServeContent(w, r, filepath.Base(path), info.ModTime(), f)
+}
+
+// serveTranscoded перекодирует srcPath в браузеро-совместимый формат через ffmpeg,
+// кешируя результат на диске по ключу mtime+размер исходного файла.
+func (s *APIServer) serveTranscoded(w http.ResponseWriter, r *http.Request, srcPath, kind string) {
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		s.sendError(w, "Исходный файл не найден", http.StatusNotFound)
+		return
+	}
+
+	var outExt, contentType string
+	var ffmpegArgs func(src, dst string) []string
+
+	if kind == "video" {
+		outExt = ".mp4"
+		contentType = "video/mp4"
+		ffmpegArgs = func(src, dst string) []string {
+			return []string{
+				"-i", src,
+				"-movflags", "frag_keyframe+empty_moov",
+				"-c:v", "libx264", "-preset", "veryfast",
+				"-c:a", "aac",
+				"-f", "mp4", dst,
+			}
+		}
+	} else {
+		outExt = ".mp3"
+		contentType = "audio/mpeg"
+		ffmpegArgs = func(src, dst string) []string {
+			return []string{"-i", src, "-f", "mp3", dst}
+		}
+	}
+
+	cacheKey := fmt.Sprintf("%s_%d_%d%s", filepath.Base(srcPath), info.ModTime().Unix(), info.Size(), outExt)
+	cachedPath := filepath.Join(transcodeCacheDir, cacheKey)
+
+	if _, err := os.Stat(cachedPath); err != nil {
+		if err := os.MkdirAll(transcodeCacheDir, 0755); err != nil {
+			s.sendError(w, "Ошибка создания кеша перекодирования", http.StatusInternalServerError)
+			return
+		}
+
+		// Перекодируем во временный файл, чтобы не отдавать частично записанный результат
+		tmpPath := cachedPath + ".tmp"
+		cmd := exec.Command("ffmpeg", ffmpegArgs(srcPath, tmpPath)...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			os.Remove(tmpPath)
+			s.sendError(w, fmt.Sprintf("Ошибка перекодирования: %v (%s)", err, string(output)), http.StatusInternalServerError)
+			return
+		}
+
+		if err := os.Rename(tmpPath, cachedPath); err != nil {
+			s.sendError(w, "Ошибка сохранения перекодированного файла", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	f, err := os.Open(cachedPath)
+	if err != nil {
+		s.sendError(w, "Ошибка открытия перекодированного файла", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	outInfo, err := f.Stat()
+	if err != nil {
+		s.sendError(w, "Ошибка чтения перекодированного файла", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("X-Transcoded", "1")
+	http.ServeContent(w, r, filepath.Base(cachedPath), outInfo.ModTime(), f)
+}
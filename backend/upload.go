@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// isOggOpus проверяет, что данные начинаются с OGG-контейнера ("OggS"),
+// достаточно для отсева явно неподходящих форматов перед отправкой в Telegram
+func isOggOpus(data []byte) bool {
+	return len(data) >= 4 && string(data[:4]) == "OggS"
+}
+
+// transcodeToOggOpus перекодирует произвольный аудиоввод в OGG/Opus через ffmpeg
+func transcodeToOggOpus(data []byte) ([]byte, error) {
+	cmd := exec.Command("ffmpeg", "-i", "pipe:0", "-c:a", "libopus", "-f", "ogg", "pipe:1")
+	cmd.Stdin = bytes.NewReader(data)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ошибка перекодирования в OGG/Opus: %w", err)
+	}
+
+	return out.Bytes(), nil
+}
+
+// readUploadedFile достаёт файл "file" из multipart-формы запроса
+func readUploadedFile(r *http.Request) (data []byte, fileName string, err error) {
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		return nil, "", fmt.Errorf("ошибка разбора формы: %w", err)
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, "", fmt.Errorf("файл не найден в форме: %w", err)
+	}
+	defer file.Close()
+
+	data, err = io.ReadAll(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+
+	return data, header.Filename, nil
+}
+
+// writeUploadResponse отдаёт единый формат ответа на все upload-эндпоинты
+func (s *APIServer) writeUploadResponse(w http.ResponseWriter, messageID int) {
+	response := SendMessageResponse{
+		Success:   true,
+		Message:   "Сообщение отправлено",
+		MessageID: &messageID,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// chatIDFromRequest достаёт и парсит {chat_id} из пути запроса
+func (s *APIServer) chatIDFromRequest(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	if !s.mtproto.IsAuthorized() {
+		s.sendError(w, "Не авторизован", http.StatusUnauthorized)
+		return 0, false
+	}
+
+	chatID, err := strconv.ParseInt(mux.Vars(r)["chat_id"], 10, 64)
+	if err != nil {
+		s.sendError(w, "Неверный ID чата", http.StatusBadRequest)
+		return 0, false
+	}
+
+	return chatID, true
+}
+
+// uploadPhoto обрабатывает POST /api/chats/{chat_id}/photo
+func (s *APIServer) uploadPhoto(w http.ResponseWriter, r *http.Request) {
+	chatID, ok := s.chatIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	data, fileName, err := readUploadedFile(r)
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	messageID, err := s.mtproto.SendPhoto(s.ctx, chatID, data, fileName, r.FormValue("caption"))
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("Ошибка отправки фото: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeUploadResponse(w, messageID)
+}
+
+// uploadVideo обрабатывает POST /api/chats/{chat_id}/video
+func (s *APIServer) uploadVideo(w http.ResponseWriter, r *http.Request) {
+	chatID, ok := s.chatIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	data, fileName, err := readUploadedFile(r)
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	duration, _ := strconv.Atoi(r.FormValue("duration"))
+	isRound := r.FormValue("is_round") == "true" || r.FormValue("is_round") == "1"
+
+	messageID, err := s.mtproto.SendVideo(s.ctx, chatID, data, fileName, r.FormValue("caption"), duration, 0, 0, isRound)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("Ошибка отправки видео: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeUploadResponse(w, messageID)
+}
+
+// uploadVoice обрабатывает POST /api/chats/{chat_id}/voice
+func (s *APIServer) uploadVoice(w http.ResponseWriter, r *http.Request) {
+	chatID, ok := s.chatIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	data, _, err := readUploadedFile(r)
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !isOggOpus(data) {
+		transcoded, err := transcodeToOggOpus(data)
+		if err != nil {
+			s.sendError(w, fmt.Sprintf("Голосовое сообщение должно быть в формате OGG/Opus: %v", err), http.StatusBadRequest)
+			return
+		}
+		data = transcoded
+	}
+
+	duration, _ := strconv.Atoi(r.FormValue("duration"))
+
+	messageID, err := s.mtproto.SendVoice(s.ctx, chatID, data, duration)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("Ошибка отправки голосового сообщения: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeUploadResponse(w, messageID)
+}
+
+// uploadAudio обрабатывает POST /api/chats/{chat_id}/audio
+func (s *APIServer) uploadAudio(w http.ResponseWriter, r *http.Request) {
+	chatID, ok := s.chatIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	data, fileName, err := readUploadedFile(r)
+	if err != nil {
+		s.sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	duration, _ := strconv.Atoi(r.FormValue("duration"))
+	performer := r.FormValue("performer")
+	title := r.FormValue("title")
+	if performer == "" || title == "" {
+		if id3Performer, id3Title := readID3Tags(data); id3Performer != "" || id3Title != "" {
+			if performer == "" {
+				performer = id3Performer
+			}
+			if title == "" {
+				title = id3Title
+			}
+		}
+	}
+
+	messageID, err := s.mtproto.SendAudio(s.ctx, chatID, data, fileName, duration, performer, title)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("Ошибка отправки аудио: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeUploadResponse(w, messageID)
+}
+
+// uploadLocation обрабатывает POST /api/chats/{chat_id}/location
+func (s *APIServer) uploadLocation(w http.ResponseWriter, r *http.Request) {
+	chatID, ok := s.chatIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.sendError(w, "Ошибка разбора формы", http.StatusBadRequest)
+		return
+	}
+
+	lat, err := strconv.ParseFloat(r.FormValue("latitude"), 64)
+	if err != nil {
+		s.sendError(w, "Неверная широта", http.StatusBadRequest)
+		return
+	}
+
+	lng, err := strconv.ParseFloat(r.FormValue("longitude"), 64)
+	if err != nil {
+		s.sendError(w, "Неверная долгота", http.StatusBadRequest)
+		return
+	}
+
+	messageID, err := s.mtproto.SendLocation(s.ctx, chatID, lat, lng)
+	if err != nil {
+		s.sendError(w, fmt.Sprintf("Ошибка отправки геопозиции: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeUploadResponse(w, messageID)
+}
+
+// readID3Tags достаёт исполнителя и название из ID3v2-тега в начале MP3-файла
+func readID3Tags(data []byte) (performer, title string) {
+	if len(data) < 10 || string(data[:3]) != "ID3" {
+		return "", ""
+	}
+
+	size := int(data[6])<<21 | int(data[7])<<14 | int(data[8])<<7 | int(data[9])
+	if 10+size > len(data) {
+		return "", ""
+	}
+
+	frames := data[10 : 10+size]
+	for len(frames) >= 10 {
+		frameID := string(frames[:4])
+		frameSize := int(frames[4])<<24 | int(frames[5])<<16 | int(frames[6])<<8 | int(frames[7])
+		if frameSize <= 0 || 10+frameSize > len(frames) {
+			break
+		}
+		frameData := frames[10 : 10+frameSize]
+
+		value := ""
+		if len(frameData) > 1 {
+			value = string(bytes.TrimRight(frameData[1:], "\x00"))
+		}
+
+		switch frameID {
+		case "TPE1":
+			performer = value
+		case "TIT2":
+			title = value
+		}
+
+		frames = frames[10+frameSize:]
+	}
+
+	return performer, title
+}
@@ -0,0 +1,442 @@
+// Package calls реализует голосовые/видео звонки Telegram (chunk2-4):
+// подписывается на auth.EventPhoneCall, чтобы получать updatePhoneCall, сам
+// звонит через phone.requestCall, отвечает через phone.acceptCall, проводит
+// DH-обмен ключами (g_a/g_b, см. dh.go) с проверкой отпечатка по 4 эмодзи
+// (см. fingerprint.go) и поднимает UDP VoIP-транспорт поверх рефлектора,
+// полученного через phone.getCallConfig (см. voip.go)
+package calls
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"vi-tg/auth"
+)
+
+// State — состояние звонка в конечном автомате Manager
+type State int
+
+const (
+	StateIdle         State = iota
+	StateRequesting          // мы позвонили, ждём phoneCallAccepted
+	StateIncoming            // нам звонят, ждём решения пользователя
+	StateExchangingKeys      // g_a/g_b обменялись, считаем auth key
+	StateRinging             // ключ подтверждён, реального аудио ещё нет
+	StateActive              // voip-транспорт поднят, идёт разговор
+	StateEnded
+)
+
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateRequesting:
+		return "requesting"
+	case StateIncoming:
+		return "incoming"
+	case StateExchangingKeys:
+		return "exchanging_keys"
+	case StateRinging:
+		return "ringing"
+	case StateActive:
+		return "active"
+	case StateEnded:
+		return "ended"
+	default:
+		return "unknown"
+	}
+}
+
+// Call описывает один звонок — достаточно полей, чтобы отрисовать
+// оверлей в TUI (see main.go renderCallOverlay) и провести VoIP-сессию
+type Call struct {
+	ID         int64
+	AccessHash int64
+	PeerUserID int64
+	Outgoing   bool
+
+	State     State
+	Started   time.Time
+	Emoji     [4]string // 4-эмодзи визуализация отпечатка ключа
+	LastError error
+
+	dh *keyExchange
+	// gAHash — sha256(g_a), с которым звонящий закоммитился в
+	// phone.requestCall (PhoneCallRequested.GAHash); принимающая сторона
+	// должна сверить его с sha256 реально пришедшего g_a в phone.PhoneCall,
+	// прежде чем доверять auth key — иначе проверка-коммитмент ничего не
+	// стоит (fix chunk2-4)
+	gAHash    []byte
+	transport *voipSession
+}
+
+// Duration возвращает длительность активного разговора; до StateActive — 0
+func (c *Call) Duration() time.Duration {
+	if c.State != StateActive || c.Started.IsZero() {
+		return 0
+	}
+	return time.Since(c.Started)
+}
+
+// Manager ведёт не более одного звонка одновременно (как и сам Telegram),
+// подписываясь на auth.EventPhoneCall и выставляя запросы phone.* через
+// auth.MTProtoClient.API()
+type Manager struct {
+	mtproto *auth.MTProtoClient
+
+	mu      sync.Mutex
+	current *Call
+
+	unsubscribe func()
+
+	// OnUpdate вызывается при любом изменении состояния звонка — main.go
+	// подписывается сюда, чтобы перерисовать TUI без отдельного опроса
+	OnUpdate func(*Call)
+}
+
+// NewManager подписывает менеджер звонков на шину событий auth и
+// возвращает его; Close отписывает
+func NewManager(mtproto *auth.MTProtoClient) *Manager {
+	m := &Manager{mtproto: mtproto}
+
+	ch, unsubscribe := auth.Events().Subscribe()
+	m.unsubscribe = unsubscribe
+
+	go func() {
+		for evt := range ch {
+			if evt.Type != auth.EventPhoneCall {
+				continue
+			}
+			phoneCall, ok := evt.Data.(tg.PhoneCallClass)
+			if !ok {
+				continue
+			}
+			m.handlePhoneCall(phoneCall)
+		}
+	}()
+
+	return m
+}
+
+// Close отписывает менеджер от шины событий и закрывает активный VoIP-транспорт
+func (m *Manager) Close() {
+	if m.unsubscribe != nil {
+		m.unsubscribe()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.current != nil && m.current.transport != nil {
+		m.current.transport.Close()
+	}
+}
+
+// Current возвращает текущий звонок (nil, если его нет)
+func (m *Manager) Current() *Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current
+}
+
+func (m *Manager) notify() {
+	if m.OnUpdate == nil {
+		return
+	}
+	m.mu.Lock()
+	c := m.current
+	m.mu.Unlock()
+	m.OnUpdate(c)
+}
+
+// Dial звонит пользователю userID: генерирует приватный DH-параметр a,
+// отправляет phone.requestCall с sha256(g_a) и переводит звонок в
+// StateRequesting в ожидании updatePhoneCall с phoneCallAccepted
+func (m *Manager) Dial(ctx context.Context, userID, accessHash int64) error {
+	api := m.mtproto.API()
+	if api == nil {
+		return fmt.Errorf("MTProto клиент не подключен")
+	}
+
+	m.mu.Lock()
+	if m.current != nil && m.current.State != StateIdle && m.current.State != StateEnded {
+		m.mu.Unlock()
+		return fmt.Errorf("звонок уже идёт")
+	}
+	m.mu.Unlock()
+
+	dhConfig, err := fetchDHConfig(ctx, api)
+	if err != nil {
+		return fmt.Errorf("ошибка получения DH-параметров: %w", err)
+	}
+
+	dh, err := newKeyExchange(dhConfig)
+	if err != nil {
+		return fmt.Errorf("ошибка генерации ключа: %w", err)
+	}
+
+	randomID := int(rand.Int31())
+	res, err := api.PhoneRequestCall(ctx, &tg.PhoneRequestCallRequest{
+		UserID:   &tg.InputUser{UserID: userID, AccessHash: accessHash},
+		RandomID: randomID,
+		GAHash:   dh.gAHash(),
+		Protocol: defaultProtocol(),
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка phone.requestCall: %w", err)
+	}
+
+	call := &Call{
+		PeerUserID: userID,
+		Outgoing:   true,
+		State:      StateRequesting,
+		dh:         dh,
+	}
+	if phoneCall, ok := res.PhoneCall.(*tg.PhoneCallRequested); ok {
+		call.ID = phoneCall.ID
+		call.AccessHash = phoneCall.AccessHash
+	}
+
+	m.mu.Lock()
+	m.current = call
+	m.mu.Unlock()
+	m.notify()
+
+	return nil
+}
+
+// Accept принимает входящий звонок: генерирует собственный g_b и отправляет
+// phone.acceptCall, переводя звонок в StateExchangingKeys
+func (m *Manager) Accept(ctx context.Context) error {
+	api := m.mtproto.API()
+	call := m.Current()
+	if api == nil || call == nil || call.State != StateIncoming {
+		return fmt.Errorf("нет входящего звонка для принятия")
+	}
+
+	dhConfig, err := fetchDHConfig(ctx, api)
+	if err != nil {
+		return fmt.Errorf("ошибка получения DH-параметров: %w", err)
+	}
+
+	dh, err := newKeyExchange(dhConfig)
+	if err != nil {
+		return fmt.Errorf("ошибка генерации ключа: %w", err)
+	}
+	call.dh = dh
+
+	_, err = api.PhoneAcceptCall(ctx, &tg.PhoneAcceptCallRequest{
+		Peer:     tg.InputPhoneCall{ID: call.ID, AccessHash: call.AccessHash},
+		GB:       dh.gB(),
+		Protocol: defaultProtocol(),
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка phone.acceptCall: %w", err)
+	}
+
+	m.mu.Lock()
+	call.State = StateExchangingKeys
+	m.mu.Unlock()
+	m.notify()
+
+	return nil
+}
+
+// Decline отклоняет входящий звонок или завершает текущий через
+// phone.discardCall с соответствующей причиной
+func (m *Manager) Decline(ctx context.Context) error {
+	api := m.mtproto.API()
+	call := m.Current()
+	if api == nil || call == nil {
+		return nil
+	}
+
+	reason := tg.PhoneCallDiscardReasonClass(&tg.PhoneCallDiscardReasonHangup{})
+	if call.State == StateIncoming {
+		reason = &tg.PhoneCallDiscardReasonBusy{}
+	}
+
+	_, err := api.PhoneDiscardCall(ctx, &tg.PhoneDiscardCallRequest{
+		Peer:     tg.InputPhoneCall{ID: call.ID, AccessHash: call.AccessHash},
+		Duration: int(call.Duration().Seconds()),
+		Reason:   reason,
+	})
+
+	m.endCall()
+	return err
+}
+
+// handlePhoneCall обрабатывает один вариант tg.PhoneCallClass, пришедший
+// через updatePhoneCall, и продвигает состояние звонка
+func (m *Manager) handlePhoneCall(pc tg.PhoneCallClass) {
+	switch v := pc.(type) {
+	case *tg.PhoneCallRequested:
+		m.mu.Lock()
+		m.current = &Call{
+			ID:         v.ID,
+			AccessHash: v.AccessHash,
+			PeerUserID: v.AdminID,
+			Outgoing:   false,
+			State:      StateIncoming,
+			gAHash:     v.GAHash,
+		}
+		m.mu.Unlock()
+		m.notify()
+
+	case *tg.PhoneCallAccepted:
+		call := m.Current()
+		if call == nil || call.dh == nil {
+			return
+		}
+		if err := call.dh.computeAuthKey(v.GB); err != nil {
+			m.mu.Lock()
+			call.LastError = err
+			m.mu.Unlock()
+			m.notify()
+			return
+		}
+		call.Emoji = fingerprintEmoji(call.dh.fingerprint())
+
+		m.mu.Lock()
+		call.State = StateExchangingKeys
+		m.mu.Unlock()
+		m.notify()
+
+		go m.confirmAndConnect(call)
+
+	case *tg.PhoneCall:
+		call := m.Current()
+		if call == nil {
+			return
+		}
+		if call.dh != nil && len(v.GAOrB) > 0 {
+			// Принимающая сторона впервые видит настоящий g_a здесь — обязана
+			// сверить его с sha256(g_a), которым звонящий закоммитился ещё в
+			// phone.requestCall (GAHash). Без этой проверки сервер-MITM мог
+			// бы дождаться настоящего g_b и только потом выбрать свой g_a,
+			// обесценивая весь смысл коммитмента (fix chunk2-4). Для
+			// исходящего звонка gAHash не сохраняется — свой g_a звонящий
+			// и так знает, перепроверять нечего.
+			if !call.Outgoing {
+				gotHash := sha256.Sum256(v.GAOrB)
+				if !bytes.Equal(gotHash[:], call.gAHash) {
+					err := fmt.Errorf("sha256(g_a) не совпадает с закоммитированным в phone.requestCall — возможна подмена сервером")
+					m.mu.Lock()
+					call.LastError = err
+					m.mu.Unlock()
+					m.notify()
+					return
+				}
+			}
+			if err := call.dh.computeAuthKey(v.GAOrB); err != nil {
+				m.mu.Lock()
+				call.LastError = err
+				m.mu.Unlock()
+				m.notify()
+				return
+			}
+			call.Emoji = fingerprintEmoji(call.dh.fingerprint())
+		}
+
+		m.mu.Lock()
+		call.Started = time.Now()
+		call.State = StateActive
+		m.mu.Unlock()
+
+		m.startVoip(call, v.Protocol)
+		m.notify()
+
+	case *tg.PhoneCallDiscarded:
+		m.endCall()
+
+	default:
+		// phoneCallEmpty/phoneCallWaiting — нет дополнительных действий
+	}
+}
+
+// confirmAndConnect отправляет phone.confirmCall (для исходящего звонка —
+// раскрываем g_a и итоговый fingerprint ключа) и ждёт updatePhoneCall с
+// tg.PhoneCall, которое поднимет VoIP-транспорт
+func (m *Manager) confirmAndConnect(call *Call) {
+	api := m.mtproto.API()
+	if api == nil {
+		return
+	}
+
+	_, err := api.PhoneConfirmCall(context.Background(), &tg.PhoneConfirmCallRequest{
+		Peer:           tg.InputPhoneCall{ID: call.ID, AccessHash: call.AccessHash},
+		GA:             call.dh.gA(),
+		KeyFingerprint: call.dh.fingerprintInt64(),
+		Protocol:       defaultProtocol(),
+	})
+	if err != nil {
+		m.mu.Lock()
+		call.LastError = fmt.Errorf("ошибка phone.confirmCall: %w", err)
+		m.mu.Unlock()
+		m.notify()
+	}
+}
+
+// startVoip поднимает UDP-транспорт к рефлектору из phone.getCallConfig и
+// запускает захват/воспроизведение Opus-аудио
+func (m *Manager) startVoip(call *Call, protocol tg.PhoneCallProtocol) {
+	api := m.mtproto.API()
+	if api == nil || call.dh == nil {
+		return
+	}
+
+	reflectors, err := fetchCallConfig(context.Background(), api)
+	if err != nil || len(reflectors) == 0 {
+		m.mu.Lock()
+		call.LastError = fmt.Errorf("не удалось получить рефлектор: %w", err)
+		m.mu.Unlock()
+		m.notify()
+		return
+	}
+
+	session, err := newVoipSession(reflectors[0], call.dh.authKey())
+	if err != nil {
+		m.mu.Lock()
+		call.LastError = fmt.Errorf("ошибка voip-транспорта: %w", err)
+		m.mu.Unlock()
+		m.notify()
+		return
+	}
+
+	m.mu.Lock()
+	call.transport = session
+	m.mu.Unlock()
+
+	session.Run()
+}
+
+func (m *Manager) endCall() {
+	m.mu.Lock()
+	if m.current != nil {
+		if m.current.transport != nil {
+			m.current.transport.Close()
+		}
+		m.current.State = StateEnded
+	}
+	m.mu.Unlock()
+	m.notify()
+}
+
+// defaultProtocol описывает поддерживаемые версии voip-библиотеки —
+// libtgvoip, как у официальных клиентов, здесь не используется: vi-tg сам
+// упаковывает Opus-кадры в RTP (см. voip.go), поэтому заявляем только UDP-
+// рефлектор без P2P
+func defaultProtocol() tg.PhoneCallProtocol {
+	return tg.PhoneCallProtocol{
+		UDPP2P:          false,
+		UDPReflector:    true,
+		MinLayer:        92,
+		MaxLayer:        92,
+		LibraryVersions: []string{"vi-tg-voip-1.0"},
+	}
+}
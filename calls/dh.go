@@ -0,0 +1,185 @@
+package calls
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/gotd/td/tg"
+)
+
+// dhConfig — простое число p и генератор g, используемые для обмена
+// ключами звонка; получаются через messages.getDhConfig — тот же запрос,
+// что используется для secret chats
+type dhConfig struct {
+	p *big.Int
+	g int
+}
+
+// fetchDHConfig запрашивает актуальные DH-параметры сервера. random
+// (случайные байты, которые сервер подмешивает в p) не используется в vi-tg
+// напрямую — сервер сам проверяет их на своей стороне
+func fetchDHConfig(ctx context.Context, api *tg.Client) (*dhConfig, error) {
+	resp, err := api.MessagesGetDhConfig(ctx, &tg.MessagesGetDhConfigRequest{
+		Version:      0,
+		RandomLength: 256,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, ok := resp.(*tg.MessagesDhConfig)
+	if !ok {
+		return nil, fmt.Errorf("сервер вернул messages.dhConfigNotModified без закешированной версии")
+	}
+
+	p := new(big.Int).SetBytes(cfg.P)
+	if err := validateDHParams(p, cfg.G); err != nil {
+		return nil, fmt.Errorf("сервер прислал небезопасные DH-параметры: %w", err)
+	}
+
+	return &dhConfig{p: p, g: cfg.G}, nil
+}
+
+// validateDHParams проверяет p/g по тем же правилам, что и MTProto для
+// secret chats (messages.dhConfig): p — 2048-битное безопасное простое число
+// (p и (p-1)/2 оба простые), g — одно из {2,3,4,5,6,7} и квадратичный вычет
+// по модулю p при выполнении соответствующего сравнения. Без этой проверки
+// принятие диапазона g_a/g_b ниже защищает от вырожденных показателей, но не
+// от сервера, подсунувшего p/g, для которых вся DH-схема некорректна
+// (fix chunk2-4).
+func validateDHParams(p *big.Int, g int) error {
+	if p.BitLen() != 2048 {
+		return fmt.Errorf("ожидалось 2048-битное простое число, получено %d бит", p.BitLen())
+	}
+	if !p.ProbablyPrime(64) {
+		return fmt.Errorf("p не является простым числом")
+	}
+	halfP := new(big.Int).Rsh(new(big.Int).Sub(p, big.NewInt(1)), 1)
+	if !halfP.ProbablyPrime(64) {
+		return fmt.Errorf("p не является безопасным простым числом ((p-1)/2 не просто)")
+	}
+
+	mod := new(big.Int)
+	switch g {
+	case 2:
+		if mod.Mod(p, big.NewInt(8)).Int64() != 7 {
+			return fmt.Errorf("g=2 требует p mod 8 == 7")
+		}
+	case 3:
+		if mod.Mod(p, big.NewInt(3)).Int64() != 2 {
+			return fmt.Errorf("g=3 требует p mod 3 == 2")
+		}
+	case 4:
+		// g=4 — квадрат, ограничений на p нет
+	case 5:
+		r := mod.Mod(p, big.NewInt(5)).Int64()
+		if r != 1 && r != 4 {
+			return fmt.Errorf("g=5 требует p mod 5 in {1,4}")
+		}
+	case 6:
+		r := mod.Mod(p, big.NewInt(24)).Int64()
+		if r != 19 && r != 23 {
+			return fmt.Errorf("g=6 требует p mod 24 in {19,23}")
+		}
+	case 7:
+		r := mod.Mod(p, big.NewInt(7)).Int64()
+		if r != 3 && r != 5 && r != 6 {
+			return fmt.Errorf("g=7 требует p mod 7 in {3,5,6}")
+		}
+	default:
+		return fmt.Errorf("недопустимый генератор g=%d, ожидалось одно из {2,3,4,5,6,7}", g)
+	}
+
+	return nil
+}
+
+// keyExchange хранит состояние одного DH-обмена ключами звонка: наш
+// приватный показатель, параметры p/g и auth key, вычисленный после
+// получения показателя собеседника
+type keyExchange struct {
+	cfg     *dhConfig
+	private *big.Int // a (или b для принимающей стороны)
+	public  *big.Int // g^private mod p — это наш g_a (или g_b)
+
+	authKeyBytes []byte
+}
+
+// newKeyExchange генерирует 2048-битный приватный показатель и наш
+// публичный g^x mod p
+func newKeyExchange(cfg *dhConfig) (*keyExchange, error) {
+	private, err := rand.Int(rand.Reader, cfg.p)
+	if err != nil {
+		return nil, err
+	}
+	if private.Sign() == 0 {
+		private.SetInt64(1)
+	}
+
+	public := new(big.Int).Exp(big.NewInt(int64(cfg.g)), private, cfg.p)
+
+	return &keyExchange{cfg: cfg, private: private, public: public}, nil
+}
+
+// gA/gB — наш публичный DH-показатель, сериализованный в big-endian байты;
+// у вызывающей стороны это g_a, у принимающей — g_b
+func (k *keyExchange) gA() []byte { return k.public.Bytes() }
+func (k *keyExchange) gB() []byte { return k.public.Bytes() }
+
+// gAHash — sha256(g_a), который phone.requestCall отправляет вместо самого
+// g_a: раскрывается позже через phone.confirmCall, когда собеседник уже
+// закоммитился на свой g_b
+func (k *keyExchange) gAHash() []byte {
+	sum := sha256.Sum256(k.gA())
+	return sum[:]
+}
+
+// computeAuthKey принимает показатель собеседника (g_b для звонящего, g_a
+// для принимающего) и считает общий auth key = peer^private mod p.
+// Перед этим проверяет 1 < peer < p-1, как того требует спецификация
+// MTProto DH-обмена (secret chats/calls) — без этой проверки сервер или
+// MITM мог бы подсунуть g_a/g_b равный 0, 1 или p-1 и тем самым навязать
+// заранее известный общий ключ (fix chunk2-4).
+func (k *keyExchange) computeAuthKey(peerPublic []byte) error {
+	if len(peerPublic) == 0 {
+		return fmt.Errorf("пустой DH-показатель собеседника")
+	}
+	peer := new(big.Int).SetBytes(peerPublic)
+
+	pMinusOne := new(big.Int).Sub(k.cfg.p, big.NewInt(1))
+	if peer.Cmp(big.NewInt(1)) <= 0 || peer.Cmp(pMinusOne) >= 0 {
+		return fmt.Errorf("DH-показатель собеседника вне допустимого диапазона (1, p-1)")
+	}
+
+	shared := new(big.Int).Exp(peer, k.private, k.cfg.p)
+
+	// auth key Telegram дополняет до 256 байт нулями слева, если
+	// результат короче — как при обычном MTProto DH-обмене
+	raw := shared.Bytes()
+	authKey := make([]byte, 256)
+	copy(authKey[256-len(raw):], raw)
+
+	k.authKeyBytes = authKey
+	return nil
+}
+
+func (k *keyExchange) authKey() []byte {
+	return k.authKeyBytes
+}
+
+// fingerprint — sha256(auth key), из которого fingerprintEmoji выводит
+// 4-эмодзи визуализацию для сверки с собеседником
+func (k *keyExchange) fingerprint() []byte {
+	sum := sha256.Sum256(k.authKeyBytes)
+	return sum[:]
+}
+
+// fingerprintInt64 — последние 8 байт fingerprint как int64, в таком виде
+// phone.confirmCall ожидает key_fingerprint
+func (k *keyExchange) fingerprintInt64() int64 {
+	fp := k.fingerprint()
+	return int64(binary.LittleEndian.Uint64(fp[len(fp)-8:]))
+}
@@ -0,0 +1,113 @@
+package calls
+
+import (
+	"math/big"
+	"testing"
+)
+
+// rfc3526Group16P — 2048-битное безопасное простое число из RFC 3526 MODP
+// group 14/Oakley, такое же по форме, как присылает messages.getDhConfig;
+// используется как заведомо валидный p для тестов validateDHParams и
+// computeAuthKey
+const rfc3526Group16PHex = "FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E08" +
+	"8A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B" +
+	"302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9" +
+	"A637ED6B0BFF5CB6F406B7EDEE386BFB5A899FA5AE9F24117C4B1FE6" +
+	"49286651ECE45B3DC2007CB8A163BF0598DA48361C55D39A69163FA8" +
+	"FD24CF5F83655D23DCA3AD961C62F356208552BB9ED529077096966D" +
+	"670C354E4ABC9804F1746C08CA18217C32905E462E36CE3BE39E772C" +
+	"180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF6955817183995497CEA956AE515D2261898FA051015728E5A8AACAA68FFFFFFFFFFFFFFFF"
+
+func testDHConfig(t *testing.T) *dhConfig {
+	t.Helper()
+	p, ok := new(big.Int).SetString(rfc3526Group16PHex, 16)
+	if !ok {
+		t.Fatalf("не удалось разобрать тестовое простое число")
+	}
+	return &dhConfig{p: p, g: 2}
+}
+
+func TestValidateDHParams_AcceptsKnownGoodGroup(t *testing.T) {
+	cfg := testDHConfig(t)
+	if err := validateDHParams(cfg.p, cfg.g); err != nil {
+		t.Errorf("validateDHParams() вернул ошибку для валидной RFC 3526 group 14: %v", err)
+	}
+}
+
+func TestValidateDHParams_RejectsWrongBitLength(t *testing.T) {
+	p := big.NewInt(23) // простое, но далеко не 2048 бит
+	if err := validateDHParams(p, 5); err == nil {
+		t.Error("validateDHParams() должен отклонить p не 2048 бит длиной")
+	}
+}
+
+func TestValidateDHParams_RejectsNonPrimeP(t *testing.T) {
+	cfg := testDHConfig(t)
+	notPrime := new(big.Int).Add(cfg.p, big.NewInt(1)) // p+1 чётное — точно не простое
+	if err := validateDHParams(notPrime, cfg.g); err == nil {
+		t.Error("validateDHParams() должен отклонить составное p")
+	}
+}
+
+func TestValidateDHParams_RejectsUnsupportedGenerator(t *testing.T) {
+	cfg := testDHConfig(t)
+	if err := validateDHParams(cfg.p, 9); err == nil {
+		t.Error("validateDHParams() должен отклонить g не из {2,3,4,5,6,7}")
+	}
+}
+
+func TestComputeAuthKey_RejectsPeerValueAtBoundaries(t *testing.T) {
+	cfg := testDHConfig(t)
+	k := &keyExchange{cfg: cfg, private: big.NewInt(12345)}
+
+	pMinusOne := new(big.Int).Sub(cfg.p, big.NewInt(1))
+
+	cases := map[string][]byte{
+		"ноль":  big.NewInt(0).Bytes(),
+		"один":  big.NewInt(1).Bytes(),
+		"p-1":   pMinusOne.Bytes(),
+		"пусто": {},
+	}
+	for name, peer := range cases {
+		if err := k.computeAuthKey(peer); err == nil {
+			t.Errorf("computeAuthKey(%s) должен вернуть ошибку — вырожденный показатель навязывает известный общий ключ", name)
+		}
+	}
+}
+
+func TestComputeAuthKey_AcceptsValueInRange(t *testing.T) {
+	cfg := testDHConfig(t)
+	k := &keyExchange{cfg: cfg, private: big.NewInt(12345)}
+
+	peer := big.NewInt(2) // строго внутри (1, p-1) для 2048-битного p
+	if err := k.computeAuthKey(peer.Bytes()); err != nil {
+		t.Fatalf("computeAuthKey() вернул ошибку для показателя внутри диапазона: %v", err)
+	}
+	if len(k.authKeyBytes) != 256 {
+		t.Errorf("len(authKeyBytes) = %d, хотим 256 (дополнено нулями слева)", len(k.authKeyBytes))
+	}
+}
+
+func TestComputeAuthKey_BothSidesAgreeOnSharedSecret(t *testing.T) {
+	cfg := testDHConfig(t)
+
+	alice, err := newKeyExchange(cfg)
+	if err != nil {
+		t.Fatalf("newKeyExchange(alice) вернул ошибку: %v", err)
+	}
+	bob, err := newKeyExchange(cfg)
+	if err != nil {
+		t.Fatalf("newKeyExchange(bob) вернул ошибку: %v", err)
+	}
+
+	if err := alice.computeAuthKey(bob.gB()); err != nil {
+		t.Fatalf("alice.computeAuthKey(bob) вернул ошибку: %v", err)
+	}
+	if err := bob.computeAuthKey(alice.gA()); err != nil {
+		t.Fatalf("bob.computeAuthKey(alice) вернул ошибку: %v", err)
+	}
+
+	if string(alice.authKey()) != string(bob.authKey()) {
+		t.Error("стороны вычислили разные auth key из одного и того же DH-обмена")
+	}
+}
@@ -0,0 +1,37 @@
+package calls
+
+// emojiAlphabet — 16 эмодзи, которыми визуализируется sha256-отпечаток
+// ключа звонка: каждая полубайтовая (4 бита) группа хеша превращается в
+// один эмодзи из этого алфавита. Это упрощённая версия официальной таблицы
+// Telegram (там их 333 на каждую из 4 позиций) — четырёх символов из
+// 16-значного алфавита достаточно, чтобы два собеседника могли сверить
+// совпадение вслух так же, как сверяют число
+var emojiAlphabet = [16]string{
+	"😀", "😂", "😍", "😎",
+	"🐶", "🐱", "🦊", "🐼",
+	"🍎", "🍕", "🍩", "🍉",
+	"⚽", "🚗", "🚀", "🌙",
+}
+
+// fingerprintEmoji выбирает 4 эмодзи из emojiAlphabet по первым четырём
+// полубайтам fingerprint — именно их main.go показывает в оверлее звонка
+// для визуальной сверки ключа с собеседником
+func fingerprintEmoji(fingerprint []byte) [4]string {
+	var result [4]string
+	for i := 0; i < 4; i++ {
+		byteIdx := i / 2
+		if byteIdx >= len(fingerprint) {
+			result[i] = emojiAlphabet[0]
+			continue
+		}
+		b := fingerprint[byteIdx]
+		var nibble byte
+		if i%2 == 0 {
+			nibble = b >> 4
+		} else {
+			nibble = b & 0x0F
+		}
+		result[i] = emojiAlphabet[nibble]
+	}
+	return result
+}
@@ -0,0 +1,68 @@
+package calls
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gotd/td/tg"
+)
+
+// reflector — один UDP-сервер ретрансляции голосового трафика звонка,
+// полученный из phone.getCallConfig
+type reflector struct {
+	Host string
+	Port int
+	Tag  []byte // peer_tag, которым реflектор отличает потоки разных звонков
+}
+
+// callConfigServer — один элемент rtc_servers в JSON, который отдаёт
+// phone.getCallConfig; часть полей Telegram не документирует, нас
+// интересуют только адрес и peer_tag
+type callConfigServer struct {
+	IP      string `json:"ip"`
+	IPv6    string `json:"ipv6"`
+	Port    int    `json:"port"`
+	PeerTag string `json:"peer_tag"`
+}
+
+type callConfigResponse struct {
+	RTCServers []callConfigServer `json:"rtc_servers"`
+}
+
+// fetchCallConfig запрашивает phone.getCallConfig и разбирает DataJSON в
+// список доступных рефлекторов — vi-tg всегда использует первый, без
+// измерения RTT до остальных (в отличие от официальных клиентов)
+func fetchCallConfig(ctx context.Context, api *tg.Client) ([]reflector, error) {
+	data, err := api.PhoneGetCallConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed callConfigResponse
+	if err := json.Unmarshal([]byte(data.Data), &parsed); err != nil {
+		return nil, fmt.Errorf("ошибка разбора call config: %w", err)
+	}
+
+	reflectors := make([]reflector, 0, len(parsed.RTCServers))
+	for _, s := range parsed.RTCServers {
+		host := s.IP
+		if host == "" {
+			host = s.IPv6
+		}
+		if host == "" || s.Port == 0 {
+			continue
+		}
+		reflectors = append(reflectors, reflector{
+			Host: host,
+			Port: s.Port,
+			Tag:  []byte(s.PeerTag),
+		})
+	}
+
+	if len(reflectors) == 0 {
+		return nil, fmt.Errorf("phone.getCallConfig не вернул ни одного rtc_server")
+	}
+
+	return reflectors, nil
+}
@@ -0,0 +1,310 @@
+package calls
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os/exec"
+	"sync"
+	"time"
+
+	"layeh.com/gopus"
+)
+
+// Параметры кодирования голоса звонка — 48кГц моно, как и большинство
+// VoIP-реализаций Opus (в т.ч. libtgvoip)
+const (
+	sampleRate  = 48000
+	channels    = 1
+	frameMillis = 20
+	frameSize   = sampleRate * frameMillis / 1000 // сэмплов на кадр
+)
+
+// voipSession — один активный UDP-транспорт звонка: принимает Opus-кадры,
+// завёрнутые в RTP, от рефлектора и воспроизводит их через внешний плеер,
+// одновременно захватывая микрофон внешним рекордером и кодируя его поток
+// обратно в RTP. PortAudio тут не используется — как и остальной медиа-
+// пайплайн vi-tg (см. internal/kitty/tgs.go), он полагается на внешние
+// CLI-инструменты вместо cgo-зависимостей
+type voipSession struct {
+	conn      *net.UDPConn
+	reflector reflector
+	authKey   []byte
+	ssrc      uint32
+
+	encoder *gopus.Encoder
+	decoder *gopus.Decoder
+
+	player     *exec.Cmd
+	playerIn   io.WriteCloser
+	capture    *exec.Cmd
+	captureOut io.ReadCloser
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// newVoipSession открывает UDP-сокет к рефлектору, инициализирует Opus-
+// кодек и запускает внешние процессы воспроизведения/захвата звука
+func newVoipSession(r reflector, authKey []byte) (*voipSession, error) {
+	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", r.Host, r.Port))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка резолва рефлектора: %w", err)
+	}
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подключения к рефлектору: %w", err)
+	}
+
+	encoder, err := gopus.NewEncoder(sampleRate, channels, gopus.Voip)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ошибка создания Opus-энкодера: %w", err)
+	}
+
+	decoder, err := gopus.NewDecoder(sampleRate, channels)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ошибка создания Opus-декодера: %w", err)
+	}
+
+	player, playerIn, err := spawnPlayer()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	capture, captureOut, err := spawnCapture()
+	if err != nil {
+		player.Process.Kill()
+		conn.Close()
+		return nil, err
+	}
+
+	// Привязываем поток к этому звонку на стороне рефлектора, как делает
+	// остальной авторизационный трафик MTProto — peer_tag подтверждает, что
+	// пакеты от нас относятся именно к этой паре звонящих
+	if len(r.Tag) > 0 {
+		conn.Write(r.Tag)
+	}
+
+	return &voipSession{
+		conn:       conn,
+		reflector:  r,
+		authKey:    authKey,
+		ssrc:       rand.Uint32(),
+		encoder:    encoder,
+		decoder:    decoder,
+		player:     player,
+		playerIn:   playerIn,
+		capture:    capture,
+		captureOut: captureOut,
+		stop:       make(chan struct{}),
+	}, nil
+}
+
+// spawnPlayer ищет доступный в PATH проигрыватель сырого PCM (pw-play для
+// PipeWire, иначе aplay для ALSA) и возвращает его как запущенный процесс с
+// подключённым stdin
+func spawnPlayer() (*exec.Cmd, io.WriteCloser, error) {
+	tool, args, err := playerCommand()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cmd := exec.Command(tool, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка подключения stdin плеера: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("ошибка запуска %s: %w", tool, err)
+	}
+
+	return cmd, stdin, nil
+}
+
+func playerCommand() (tool string, args []string, err error) {
+	if _, lookErr := exec.LookPath("pw-play"); lookErr == nil {
+		return "pw-play", []string{"--rate", itoa(sampleRate), "--channels", itoa(channels), "--format", "s16", "-"}, nil
+	}
+	if _, lookErr := exec.LookPath("aplay"); lookErr == nil {
+		return "aplay", []string{"-q", "-r", itoa(sampleRate), "-c", itoa(channels), "-f", "S16_LE", "-t", "raw", "-"}, nil
+	}
+	return "", nil, fmt.Errorf("не найден ни pw-play, ни aplay в PATH для воспроизведения звонка")
+}
+
+// spawnCapture симметрично ищет инструмент записи с микрофона (pw-record,
+// иначе arecord) и отдаёт его stdout как источник сырого PCM
+func spawnCapture() (*exec.Cmd, io.ReadCloser, error) {
+	tool, args, err := captureCommand()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cmd := exec.Command(tool, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка подключения stdout рекордера: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("ошибка запуска %s: %w", tool, err)
+	}
+
+	return cmd, stdout, nil
+}
+
+func captureCommand() (tool string, args []string, err error) {
+	if _, lookErr := exec.LookPath("pw-record"); lookErr == nil {
+		return "pw-record", []string{"--rate", itoa(sampleRate), "--channels", itoa(channels), "--format", "s16", "-"}, nil
+	}
+	if _, lookErr := exec.LookPath("arecord"); lookErr == nil {
+		return "arecord", []string{"-q", "-r", itoa(sampleRate), "-c", itoa(channels), "-f", "S16_LE", "-t", "raw", "-"}, nil
+	}
+	return "", nil, fmt.Errorf("не найден ни pw-record, ни arecord в PATH для захвата микрофона")
+}
+
+func itoa(n int) string {
+	return fmt.Sprintf("%d", n)
+}
+
+// Run запускает приём и отправку RTP-потока и блокируется до Close.
+// Вызывать в отдельной горутине (см. Manager.startVoip)
+func (s *voipSession) Run() {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		s.receiveLoop()
+	}()
+	go func() {
+		defer wg.Done()
+		s.sendLoop()
+	}()
+
+	wg.Wait()
+}
+
+// receiveLoop читает RTP-пакеты с рефлектора, декодирует Opus-payload и
+// пишет PCM в stdin внешнего плеера
+func (s *voipSession) receiveLoop() {
+	buf := make([]byte, 1500)
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		s.conn.SetReadDeadline(deadlineIn(frameMillis * 5))
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			continue // таймаут чтения или временная сетевая ошибка — пробуем снова
+		}
+
+		payload, ok := parseRTP(buf[:n])
+		if !ok {
+			continue
+		}
+
+		pcm, err := s.decoder.Decode(payload, frameSize, false)
+		if err != nil {
+			continue
+		}
+
+		if err := binary.Write(s.playerIn, binary.LittleEndian, int16Slice(pcm)); err != nil {
+			return
+		}
+	}
+}
+
+// sendLoop читает PCM с микрофона, кодирует в Opus и отправляет рефлектору
+// завёрнутым в RTP
+func (s *voipSession) sendLoop() {
+	pcmBuf := make([]int16, frameSize*channels)
+	seq := uint16(0)
+	timestamp := uint32(0)
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		if err := binary.Read(s.captureOut, binary.LittleEndian, pcmBuf); err != nil {
+			return
+		}
+
+		encoded, err := s.encoder.Encode(pcmBuf, frameSize, len(pcmBuf)*2)
+		if err != nil {
+			continue
+		}
+
+		packet := buildRTP(seq, timestamp, s.ssrc, encoded)
+		if _, err := s.conn.Write(packet); err != nil {
+			return
+		}
+
+		seq++
+		timestamp += frameSize
+	}
+}
+
+// Close останавливает обе петли приёма/отправки, закрывает сокет и убивает
+// внешние процессы воспроизведения/захвата
+func (s *voipSession) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		s.conn.Close()
+		if s.playerIn != nil {
+			s.playerIn.Close()
+		}
+		if s.player != nil && s.player.Process != nil {
+			s.player.Process.Kill()
+		}
+		if s.captureOut != nil {
+			s.captureOut.Close()
+		}
+		if s.capture != nil && s.capture.Process != nil {
+			s.capture.Process.Kill()
+		}
+	})
+}
+
+// buildRTP собирает минимальный RTP-заголовок (RFC 3550): версия 2, без
+// padding/extension/CSRC, payload type 111 (динамический — Opus)
+func buildRTP(seq uint16, timestamp, ssrc uint32, payload []byte) []byte {
+	header := make([]byte, 12)
+	header[0] = 0x80 // version=2
+	header[1] = 111  // payload type, без marker bit
+	binary.BigEndian.PutUint16(header[2:4], seq)
+	binary.BigEndian.PutUint32(header[4:8], timestamp)
+	binary.BigEndian.PutUint32(header[8:12], ssrc)
+	return append(header, payload...)
+}
+
+// parseRTP проверяет версию RTP-заголовка и возвращает Opus-payload без него
+func parseRTP(packet []byte) ([]byte, bool) {
+	if len(packet) < 12 {
+		return nil, false
+	}
+	if packet[0]>>6 != 2 {
+		return nil, false // не RTP v2 — вероятно, служебный пакет рефлектора
+	}
+	return packet[12:], true
+}
+
+func int16Slice(samples []int16) []int16 {
+	return samples
+}
+
+// deadlineIn возвращает дедлайн через ms миллисекунд — используется для
+// неблокирующего чтения из UDP-сокета, чтобы receiveLoop мог проверять stop
+func deadlineIn(ms int) time.Time {
+	return time.Now().Add(time.Duration(ms) * time.Millisecond)
+}
@@ -1,78 +1,194 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"vi-tg/internal/msgtemplate"
+	"vi-tg/internal/secrets"
 )
 
 type Config struct {
-	TelegramToken string `json:"telegram_token"`
-	PhoneNumber   string `json:"phone_number"`
-	UseMTProto    bool   `json:"use_mtproto"`
-	Theme         string `json:"theme"`
-	AutoSave      bool   `json:"auto_save"`
+	// SchemaVersion — версия схемы конфига, используется цепочкой миграций в
+	// layered.go (chunk6-4). У файлов, сохранённых до появления этого поля,
+	// значение в файле отсутствует и читается как 0 — LoadConfigFrom приводит
+	// такие файлы к версии 1 перед тем, как применять миграции.
+	SchemaVersion int `yaml:"schema_version" json:"schema_version,omitempty"`
+
+	// TelegramToken/PhoneNumber не сериализуются — хранятся в internal/secrets
+	// (системный кейринг либо зашифрованный файл-резерв), а не в открытом
+	// тексте config.yml (chunk6-5). LoadConfigFrom заполняет эти поля из
+	// secrets.Get после чтения остального конфига, SaveConfig отправляет их
+	// туда же через secrets.Set.
+	TelegramToken string `yaml:"-" json:"-"`
+	PhoneNumber   string `yaml:"-" json:"-"`
+	UseMTProto    bool   `yaml:"use_mtproto" json:"use_mtproto"`
+	Theme         string `yaml:"theme" json:"theme"`
+	AutoSave      bool   `yaml:"auto_save" json:"auto_save"`
+	// TileServerURL — шаблон URL тайлового сервера для карт локаций.
+	// Поддерживает {s} (ротация поддоменов), {z}, {x}, {y}
+	TileServerURL string `yaml:"tile_server_url" json:"tile_server_url"`
+	// TileCacheTTLHours — время жизни закешированного тайла в часах, 0 — без ограничения
+	TileCacheTTLHours int `yaml:"tile_cache_ttl_hours" json:"tile_cache_ttl_hours"`
+	// TileCacheQuotaMB — квота дискового кеша тайлов в мегабайтах, 0 — без ограничения
+	TileCacheQuotaMB int `yaml:"tile_cache_quota_mb" json:"tile_cache_quota_mb"`
+	// MutedChats — приглушённые чаты по ID (chunk2-6). Присутствие чата в
+	// карте само по себе означает приглушение; значение уточняет исключения,
+	// при которых уведомление всё же показывается. Изначально заполняется
+	// импортом из account.getNotifySettings, затем может редактироваться
+	// пользователем напрямую в config.yml
+	MutedChats map[int64]MuteRule `yaml:"muted_chats,omitempty" json:"muted_chats,omitempty"`
+	// FfmpegPath/FfprobePath — путь к бинарникам ffmpeg/ffprobe, установленным
+	// через internal/ffmpegprovision или заданным вручную командой
+	// ":ffmpeg path <p>"; пусто — использовать PATH (chunk5-6)
+	FfmpegPath  string `yaml:"ffmpeg_path,omitempty" json:"ffmpeg_path,omitempty"`
+	FfprobePath string `yaml:"ffprobe_path,omitempty" json:"ffprobe_path,omitempty"`
+	// FfmpegReleaseURL переопределяет URL сборки ffmpeg для автоустановки
+	// (":ffmpeg install"), заданный по умолчанию для текущей платформы
+	FfmpegReleaseURL string `yaml:"ffmpeg_release_url,omitempty" json:"ffmpeg_release_url,omitempty"`
+	// SessionPath — каталог, куда telegram.Client.StartAuth кладёт файлы
+	// сессии вида <phone>.dat (chunk6-2); по умолчанию — "sessions" рядом с
+	// config.yml
+	SessionPath string `yaml:"session_path,omitempty" json:"session_path,omitempty"`
+	// Messages — шаблоны исходящих/служебных сообщений (chunk6-3)
+	Messages MessagesConfig `yaml:"messages,omitempty" json:"messages,omitempty"`
+	// OfflineMode — если включён, telegram.Client отдаёт чаты и сообщения
+	// только из локального кеша store.Store, не обращаясь к backend вовсе
+	// (chunk6-6)
+	OfflineMode bool `yaml:"offline_mode,omitempty" json:"offline_mode,omitempty"`
+	// WebhookURL — публичный HTTPS-адрес, который Telegram дёргает запросами
+	// вместо long polling. Пусто (по умолчанию) — botBackend использует
+	// telebot.LongPoller, как и раньше; непусто — telebot.Webhook (chunk6-7)
+	WebhookURL string `yaml:"webhook_url,omitempty" json:"webhook_url,omitempty"`
+	// WebhookListen — адрес:порт, на котором слушает HTTP(S)-сервер вебхука,
+	// например "0.0.0.0:8443"
+	WebhookListen string `yaml:"webhook_listen,omitempty" json:"webhook_listen,omitempty"`
+	// WebhookCertFile/WebhookKeyFile — файлы самоподписанного TLS-сертификата
+	// для случая, когда перед ботом нет обратного прокси с уже валидным
+	// сертификатом; пусто — telebot.Webhook слушает обычный HTTP (прокси
+	// терминирует TLS сам)
+	WebhookCertFile string `yaml:"webhook_cert_file,omitempty" json:"webhook_cert_file,omitempty"`
+	WebhookKeyFile  string `yaml:"webhook_key_file,omitempty" json:"webhook_key_file,omitempty"`
+	// WebhookSecretToken — значение заголовка X-Telegram-Bot-Api-Secret-Token,
+	// которое Telegram присылает с каждым обновлением; telebot.Webhook сверяет
+	// его сам и отбрасывает запросы без совпадения
+	WebhookSecretToken string `yaml:"webhook_secret_token,omitempty" json:"webhook_secret_token,omitempty"`
 }
 
-func LoadConfig() (*Config, error) {
-	configPath := getConfigPath()
-	
-	// Создаем директорию если не существует
-	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
-		return nil, fmt.Errorf("ошибка создания директории конфига: %w", err)
+// MessagesConfig содержит шаблоны text/template для сообщений, которые
+// telegram.Client.SendTemplated отправляет по имени (chunk6-3), по аналогии
+// с шаблонизированными уведомлениями service-monitor-tgbot. Пустой шаблон
+// означает, что соответствующее событие не отправляется.
+type MessagesConfig struct {
+	// OutgoingTemplate — шаблон обычного исходящего сообщения
+	OutgoingTemplate string `yaml:"outgoing_template,omitempty" json:"outgoing_template,omitempty"`
+	// NotificationTemplate — шаблон служебного уведомления
+	NotificationTemplate string `yaml:"notification_template,omitempty" json:"notification_template,omitempty"`
+	// StatusTemplate — шаблон сообщения о смене статуса (онлайн/оффлайн и т.п.)
+	StatusTemplate string `yaml:"status_template,omitempty" json:"status_template,omitempty"`
+	// JoinTemplate — шаблон сообщения о присоединении участника к чату
+	JoinTemplate string `yaml:"join_template,omitempty" json:"join_template,omitempty"`
+	// LeaveTemplate — шаблон сообщения об уходе участника из чата
+	LeaveTemplate string `yaml:"leave_template,omitempty" json:"leave_template,omitempty"`
+}
+
+// namedTemplates возвращает шаблоны сообщений по их именам — единый список,
+// который используют и validateTemplates, и MessagesConfig.Lookup
+func (m MessagesConfig) namedTemplates() map[string]string {
+	return map[string]string{
+		"outgoing":     m.OutgoingTemplate,
+		"notification": m.NotificationTemplate,
+		"status":       m.StatusTemplate,
+		"join":         m.JoinTemplate,
+		"leave":        m.LeaveTemplate,
+	}
+}
+
+// Lookup возвращает текст шаблона по имени (outgoing/notification/status/
+// join/leave) для telegram.Client.SendTemplated. Пустой шаблон считается
+// ненайденным — событие просто не отправляется
+func (m MessagesConfig) Lookup(name string) (string, bool) {
+	text, ok := m.namedTemplates()[name]
+	if !ok || text == "" {
+		return "", false
 	}
-	
-	// Если файл не существует, создаем с дефолтными значениями
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		config := &Config{
-			TelegramToken: "",
-			PhoneNumber:   "",
-			UseMTProto:    true, // По умолчанию используем MTProto
-			Theme:         "default",
-			AutoSave:      true,
+	return text, true
+}
+
+// validateTemplates разбирает каждый непустой шаблон сообщений, чтобы
+// некорректный text/template обнаружился при запуске, а не при первой
+// отправке (chunk6-3)
+func (m MessagesConfig) validateTemplates() error {
+	for name, text := range m.namedTemplates() {
+		if text == "" {
+			continue
 		}
-		
-		if err := SaveConfig(config); err != nil {
-			return nil, err
+		if _, err := msgtemplate.Parse(name, text); err != nil {
+			return fmt.Errorf("шаблон %q: %w", name, err)
 		}
-		
-		return config, nil
-	}
-	
-	// Читаем существующий конфиг
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка чтения конфига: %w", err)
 	}
-	
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("ошибка парсинга конфига: %w", err)
-	}
-	
-	return &config, nil
+	return nil
+}
+
+// MuteRule уточняет, при каких условиях приглушённый чат всё же показывает
+// уведомление. Пустое правило (все поля нулевые) глушит чат полностью
+type MuteRule struct {
+	// Keywords — если не пусто, уведомление показывается только когда текст
+	// сообщения содержит одно из этих слов (например, кодовые слова команды)
+	Keywords []string `yaml:"keywords,omitempty" json:"keywords,omitempty"`
+	// MentionsOnly — показывать уведомления только при упоминании пользователя
+	MentionsOnly bool `yaml:"mentions_only,omitempty" json:"mentions_only,omitempty"`
+	// QuietHours — диапазон "HH:MM-HH:MM" по локальному времени; вне этого
+	// диапазона уведомления по чату показываются как обычно
+	QuietHours string `yaml:"quiet_hours,omitempty" json:"quiet_hours,omitempty"`
+}
+
+// LoadConfig загружает конфиг из стандартного расположения (~/.vi-tg/config.yml,
+// с оверлеями профиля и переменных окружения — см. layered.go). Оставлена как
+// отдельная функция от LoadConfigFrom ради обратной совместимости вызывающего
+// кода (main.go и т.д.), который не должен знать про конкретный путь.
+func LoadConfig() (*Config, error) {
+	return LoadConfigFrom(getConfigYAMLPath())
 }
 
+// SaveConfig сохраняет конфиг в стандартное расположение атомарной записью
+// (см. atomicWriteFile в layered.go). TelegramToken/PhoneNumber в файл не
+// попадают (см. их теги в Config) — вместо этого они уходят в internal/secrets,
+// так что каждый SaveConfig одновременно дописывает секреты в защищённое
+// хранилище и вычищает их из config.yml (chunk6-5).
 func SaveConfig(config *Config) error {
-	configPath := getConfigPath()
-	
-	data, err := json.MarshalIndent(config, "", "  ")
-	if err != nil {
-		return fmt.Errorf("ошибка сериализации конфига: %w", err)
+	if config.TelegramToken != "" {
+		if err := secrets.Set("telegram_token", config.TelegramToken); err != nil {
+			return fmt.Errorf("ошибка сохранения токена в защищённое хранилище: %w", err)
+		}
 	}
-	
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
-		return fmt.Errorf("ошибка записи конфига: %w", err)
+	if config.PhoneNumber != "" {
+		if err := secrets.Set("phone_number", config.PhoneNumber); err != nil {
+			return fmt.Errorf("ошибка сохранения номера телефона в защищённое хранилище: %w", err)
+		}
 	}
-	
-	return nil
+
+	return atomicWriteFile(getConfigYAMLPath(), config)
 }
 
+// getConfigPath возвращает путь к старому config.json — LoadConfigFrom читает
+// его, если config.yml ещё не создан, чтобы не потерять настройки
+// пользователей, обновившихся с версий до chunk6-4
 func getConfigPath() string {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		homeDir = "."
 	}
 	return filepath.Join(homeDir, ".vi-tg", "config.json")
+}
+
+// getConfigYAMLPath возвращает путь к основному конфигу в новом формате YAML
+// (chunk6-4)
+func getConfigYAMLPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	return filepath.Join(homeDir, ".vi-tg", "config.yml")
 } 
\ No newline at end of file
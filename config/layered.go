@@ -0,0 +1,306 @@
+package config
+
+// Слоистая загрузка конфига (chunk6-4): базовый config.yml, поверх него —
+// необязательный профиль (config.<profile>.yml, выбирается VITG_PROFILE), и
+// поверх всего — переменные окружения VITG_*. Схема версионируется
+// (SchemaVersion), и при загрузке более старого файла применяется цепочка
+// миграций, после чего файл атомарно перезаписывается в актуальном формате —
+// по образцу подхода capcom6/service-monitor-tgbot и chirpnest.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+
+	"vi-tg/internal/secrets"
+)
+
+// currentSchemaVersion — версия схемы, которую пишет эта версия vi-tg
+const currentSchemaVersion = 2
+
+// configOverlay — подмножество Config, которое можно переопределить профилем
+// или переменными окружения. Указатели отличают "не задано" от нулевого
+// значения (например, use_mtproto: false в профиле — не то же самое, что
+// отсутствие профиля вовсе)
+type configOverlay struct {
+	TelegramToken *string `yaml:"telegram_token,omitempty"`
+	PhoneNumber   *string `yaml:"phone_number,omitempty"`
+	UseMTProto    *bool   `yaml:"use_mtproto,omitempty"`
+	Theme         *string `yaml:"theme,omitempty"`
+}
+
+func (o configOverlay) applyTo(cfg *Config) {
+	if o.TelegramToken != nil {
+		cfg.TelegramToken = *o.TelegramToken
+	}
+	if o.PhoneNumber != nil {
+		cfg.PhoneNumber = *o.PhoneNumber
+	}
+	if o.UseMTProto != nil {
+		cfg.UseMTProto = *o.UseMTProto
+	}
+	if o.Theme != nil {
+		cfg.Theme = *o.Theme
+	}
+}
+
+// LoadConfigFrom загружает конфиг из path (YAML), накладывает профиль и
+// переменные окружения, прогоняет миграции и сохраняет результат обратно —
+// вынесена отдельно от LoadConfig ради тестируемости произвольным путём
+// (chunk6-4)
+func LoadConfigFrom(path string) (*Config, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("ошибка создания директории конфига: %w", err)
+	}
+
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate(cfg, filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	token, err := secrets.Get("telegram_token")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения токена из защищённого хранилища: %w", err)
+	}
+	if token != "" {
+		cfg.TelegramToken = token
+	}
+	phone, err := secrets.Get("phone_number")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения номера телефона из защищённого хранилища: %w", err)
+	}
+	if phone != "" {
+		cfg.PhoneNumber = phone
+	}
+
+	if overlay, err := readProfileOverlay(filepath.Dir(path)); err != nil {
+		return nil, err
+	} else if overlay != nil {
+		overlay.applyTo(cfg)
+	}
+
+	applyEnvOverrides(cfg)
+
+	if err := cfg.Messages.validateTemplates(); err != nil {
+		return nil, fmt.Errorf("ошибка проверки шаблонов сообщений: %w", err)
+	}
+
+	if err := atomicWriteFile(path, cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// readConfigFile читает path как YAML. Если path ещё не существует, но есть
+// старый config.json (путь до chunk6-4), читает его вместо создания пустого
+// конфига — так обновление не теряет настройки пользователя. Если нет ни
+// одного файла, возвращает конфиг по умолчанию версии 1 — мигрировать его до
+// currentSchemaVersion предстоит вызывающему коду.
+func readConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var cfg Config
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("ошибка разбора конфига %s: %w", path, err)
+		}
+		if err := migratePlaintextSecrets(data, yaml.Unmarshal); err != nil {
+			return nil, err
+		}
+		return &cfg, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("ошибка чтения конфига %s: %w", path, err)
+	}
+
+	legacyPath := getConfigPath()
+	legacyData, legacyErr := os.ReadFile(legacyPath)
+	if legacyErr == nil {
+		var cfg Config
+		if err := json.Unmarshal(legacyData, &cfg); err != nil {
+			return nil, fmt.Errorf("ошибка разбора старого конфига %s: %w", legacyPath, err)
+		}
+		if err := migratePlaintextSecrets(legacyData, json.Unmarshal); err != nil {
+			return nil, err
+		}
+		return &cfg, nil
+	}
+	if !os.IsNotExist(legacyErr) {
+		return nil, fmt.Errorf("ошибка чтения старого конфига %s: %w", legacyPath, legacyErr)
+	}
+
+	return &Config{SchemaVersion: 1}, nil
+}
+
+// legacyPlaintextSecrets читает telegram_token/phone_number из файла
+// конфига, сохранённого до chunk6-5, когда Config.TelegramToken/PhoneNumber
+// ещё сериализовались в открытом виде
+type legacyPlaintextSecrets struct {
+	TelegramToken string `yaml:"telegram_token" json:"telegram_token"`
+	PhoneNumber   string `yaml:"phone_number" json:"phone_number"`
+}
+
+// migratePlaintextSecrets переносит telegram_token/phone_number, найденные в
+// открытом виде в файле конфига, в internal/secrets — однократная миграция
+// при первой загрузке конфига после chunk6-5, после которой поля больше не
+// сериализуются и сами вычищаются из файла следующим SaveConfig
+func migratePlaintextSecrets(data []byte, unmarshal func([]byte, interface{}) error) error {
+	var legacy legacyPlaintextSecrets
+	if err := unmarshal(data, &legacy); err != nil {
+		return nil
+	}
+
+	if legacy.TelegramToken != "" {
+		if err := secrets.Set("telegram_token", legacy.TelegramToken); err != nil {
+			return fmt.Errorf("ошибка переноса telegram_token в защищённое хранилище: %w", err)
+		}
+	}
+	if legacy.PhoneNumber != "" {
+		if err := secrets.Set("phone_number", legacy.PhoneNumber); err != nil {
+			return fmt.Errorf("ошибка переноса phone_number в защищённое хранилище: %w", err)
+		}
+	}
+	return nil
+}
+
+// readProfileOverlay читает config.<VITG_PROFILE>.yml из того же каталога,
+// что и основной конфиг, если переменная окружения VITG_PROFILE задана
+func readProfileOverlay(configDir string) (*configOverlay, error) {
+	profile := os.Getenv("VITG_PROFILE")
+	if profile == "" {
+		return nil, nil
+	}
+
+	profilePath := filepath.Join(configDir, fmt.Sprintf("config.%s.yml", profile))
+	data, err := os.ReadFile(profilePath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("ошибка загрузки профиля %q: файл %s не найден", profile, profilePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения профиля %s: %w", profilePath, err)
+	}
+
+	var overlay configOverlay
+	if err := yaml.Unmarshal(data, &overlay); err != nil {
+		return nil, fmt.Errorf("ошибка разбора профиля %s: %w", profilePath, err)
+	}
+	return &overlay, nil
+}
+
+// applyEnvOverrides переопределяет поля конфига значениями переменных
+// окружения VITG_* — последний и самый приоритетный слой
+func applyEnvOverrides(cfg *Config) {
+	if v, ok := os.LookupEnv("VITG_TELEGRAM_TOKEN"); ok {
+		cfg.TelegramToken = v
+	}
+	if v, ok := os.LookupEnv("VITG_PHONE_NUMBER"); ok {
+		cfg.PhoneNumber = v
+	}
+	if v, ok := os.LookupEnv("VITG_THEME"); ok {
+		cfg.Theme = v
+	}
+	if v, ok := os.LookupEnv("VITG_USE_MTPROTO"); ok {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.UseMTProto = b
+		}
+	}
+}
+
+// migrate прогоняет cfg через цепочку миграций от его текущей SchemaVersion
+// до currentSchemaVersion, по одному шагу за раз. configDir нужен шагам,
+// которым приходится вычислять пути по умолчанию (например, SessionPath)
+func migrate(cfg *Config, configDir string) error {
+	if cfg.SchemaVersion < 1 {
+		cfg.SchemaVersion = 1
+	}
+
+	for cfg.SchemaVersion < currentSchemaVersion {
+		switch cfg.SchemaVersion {
+		case 1:
+			migrateV1toV2(cfg, configDir)
+		default:
+			return fmt.Errorf("неизвестная версия схемы конфига: %d", cfg.SchemaVersion)
+		}
+	}
+
+	return nil
+}
+
+// migrateV1toV2 поднимает конфиг до версии 2 (chunk6-4): заполняет поля,
+// которые раньше проставлял LoadConfig по месту при каждой загрузке (дефолтный
+// тайл-сервер, пустая карта приглушённых чатов, каталог сессий), и помечает
+// версию схемы явно — до этой миграции она нигде не записывалась
+func migrateV1toV2(cfg *Config, configDir string) {
+	if cfg.TelegramToken == "" && cfg.PhoneNumber == "" && cfg.TileServerURL == "" && !cfg.AutoSave {
+		// Совсем пустой конфиг — считаем его свежеиспечённым, а не
+		// существовавшим до версии 2, и заполняем теми же дефолтами, что
+		// раньше ставил LoadConfig для нового файла
+		cfg.UseMTProto = true
+		cfg.AutoSave = true
+	}
+
+	if cfg.TileServerURL == "" {
+		cfg.TileServerURL = "https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png"
+	}
+	if cfg.TileCacheTTLHours == 0 {
+		cfg.TileCacheTTLHours = 168 // неделя
+	}
+	if cfg.TileCacheQuotaMB == 0 {
+		cfg.TileCacheQuotaMB = 200
+	}
+	if cfg.MutedChats == nil {
+		cfg.MutedChats = make(map[int64]MuteRule)
+	}
+	if cfg.SessionPath == "" {
+		cfg.SessionPath = filepath.Join(configDir, "sessions")
+	}
+
+	cfg.SchemaVersion = 2
+}
+
+// atomicWriteFile сериализует cfg в YAML и записывает через временный файл +
+// rename, чтобы сбой записи (например, нехватка места на диске) не оставил
+// config.yml в повреждённом промежуточном состоянии
+func atomicWriteFile(path string, cfg *Config) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации конфига: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("ошибка создания директории конфига: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".config-*.yml.tmp")
+	if err != nil {
+		return fmt.Errorf("ошибка создания временного файла конфига: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("ошибка записи временного файла конфига: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("ошибка закрытия временного файла конфига: %w", err)
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		return fmt.Errorf("ошибка установки прав временного файла конфига: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("ошибка переименования временного файла конфига: %w", err)
+	}
+
+	return nil
+}
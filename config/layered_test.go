@@ -0,0 +1,104 @@
+package config
+
+import "testing"
+
+func TestMigrateV1toV2_FreshConfig(t *testing.T) {
+	cfg := &Config{SchemaVersion: 1}
+
+	if err := migrate(cfg, "/tmp/vi-tg-test"); err != nil {
+		t.Fatalf("migrate() вернул ошибку: %v", err)
+	}
+
+	if cfg.SchemaVersion != currentSchemaVersion {
+		t.Errorf("SchemaVersion = %d, хотим %d", cfg.SchemaVersion, currentSchemaVersion)
+	}
+	if !cfg.UseMTProto {
+		t.Error("свежий конфиг должен получить UseMTProto = true")
+	}
+	if !cfg.AutoSave {
+		t.Error("свежий конфиг должен получить AutoSave = true")
+	}
+	if cfg.TileServerURL == "" {
+		t.Error("TileServerURL не должен остаться пустым после миграции")
+	}
+	if cfg.TileCacheTTLHours != 168 {
+		t.Errorf("TileCacheTTLHours = %d, хотим 168", cfg.TileCacheTTLHours)
+	}
+	if cfg.TileCacheQuotaMB != 200 {
+		t.Errorf("TileCacheQuotaMB = %d, хотим 200", cfg.TileCacheQuotaMB)
+	}
+	if cfg.MutedChats == nil {
+		t.Error("MutedChats должен быть инициализирован, а не nil")
+	}
+	if cfg.SessionPath == "" {
+		t.Error("SessionPath не должен остаться пустым после миграции")
+	}
+}
+
+func TestMigrateV1toV2_ExistingConfigKeepsUserValues(t *testing.T) {
+	// Конфиг с непустыми полями до миграции не должен считаться "свежим" и
+	// не должен получить дефолты UseMTProto/AutoSave по месту (пользователь
+	// мог явно выключить их до chunk6-4)
+	cfg := &Config{
+		SchemaVersion: 1,
+		TelegramToken: "some-token",
+		UseMTProto:    false,
+		AutoSave:      false,
+		TileServerURL: "https://custom.tiles.example/{z}/{x}/{y}.png",
+	}
+
+	if err := migrate(cfg, "/tmp/vi-tg-test"); err != nil {
+		t.Fatalf("migrate() вернул ошибку: %v", err)
+	}
+
+	if cfg.UseMTProto {
+		t.Error("существующий конфиг не должен получить UseMTProto = true по умолчанию")
+	}
+	if cfg.AutoSave {
+		t.Error("существующий конфиг не должен получить AutoSave = true по умолчанию")
+	}
+	if cfg.TileServerURL != "https://custom.tiles.example/{z}/{x}/{y}.png" {
+		t.Errorf("TileServerURL перезаписан: %q", cfg.TileServerURL)
+	}
+	if cfg.SchemaVersion != currentSchemaVersion {
+		t.Errorf("SchemaVersion = %d, хотим %d", cfg.SchemaVersion, currentSchemaVersion)
+	}
+}
+
+func TestMigrate_AlreadyCurrentIsNoop(t *testing.T) {
+	cfg := &Config{SchemaVersion: currentSchemaVersion, TileServerURL: "kept"}
+
+	if err := migrate(cfg, "/tmp/vi-tg-test"); err != nil {
+		t.Fatalf("migrate() вернул ошибку: %v", err)
+	}
+	if cfg.TileServerURL != "kept" {
+		t.Errorf("migrate не должен трогать конфиг уже текущей версии, TileServerURL = %q", cfg.TileServerURL)
+	}
+}
+
+func TestMigrate_NegativeOrZeroSchemaVersionClampedBeforeMigrating(t *testing.T) {
+	// Файлы конфига до введения SchemaVersion читаются с нулевым значением
+	// поля — migrate должен сперва привести его к 1, а уже потом применять
+	// миграции по цепочке, а не просто отказаться мигрировать такой конфиг
+	cfg := &Config{SchemaVersion: 0}
+
+	if err := migrate(cfg, "/tmp/vi-tg-test"); err != nil {
+		t.Fatalf("migrate() вернул ошибку: %v", err)
+	}
+	if cfg.SchemaVersion != currentSchemaVersion {
+		t.Errorf("SchemaVersion = %d, хотим %d", cfg.SchemaVersion, currentSchemaVersion)
+	}
+}
+
+func TestMigrateV1toV2_SessionPathDerivedFromConfigDir(t *testing.T) {
+	cfg := &Config{SchemaVersion: 1}
+
+	if err := migrate(cfg, "/tmp/vi-tg-test/config-dir"); err != nil {
+		t.Fatalf("migrate() вернул ошибку: %v", err)
+	}
+
+	want := "/tmp/vi-tg-test/config-dir/sessions"
+	if cfg.SessionPath != want {
+		t.Errorf("SessionPath = %q, хотим %q", cfg.SessionPath, want)
+	}
+}
@@ -0,0 +1,357 @@
+// Package customemoji резолвит сущности messageEntityCustomEmoji — Telegram
+// присылает их как ссылку на document ID поверх Unicode-заменителя в тексте
+// сообщения вместо самого эмодзи. Пакет скачивает документы через
+// messages.getCustomEmojiDocuments, кеширует их на диске под
+// ~/.cache/vi-tg/custom_emoji/<id>.tgs и разбирает остальные сущности
+// форматирования (bold, italic, spoiler, code и т.д.) в диапазоны, которые
+// renderMessages может оформить через lipgloss
+package customemoji
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/gotd/td/tg"
+)
+
+// Entity описывает диапазон MessageItem.Text (в байтах, уже после подстановки
+// плейсхолдеров "[ce:<id>]" вместо customEmoji), к которому применяется один
+// стиль форматирования Telegram
+type Entity struct {
+	Type   string // "bold", "italic", "underline", "strikethrough", "spoiler", "code", "pre"
+	Offset int    // смещение в байтах от начала MessageItem.Text
+	Length int    // длина диапазона в байтах
+}
+
+// SwitchDCFunc открывает (или возвращает уже открытое ранее) соединение к DC
+// dcID, авторизованное тем же ключом, что и основной клиент — вызывается при
+// FILE_MIGRATE_X. customemoji не может сделать это сам (exportAuthorization —
+// дело auth.MTProtoClient, импортировать который отсюда нельзя, зависимость
+// идёт в обратную сторону), поэтому ждёт готовую функцию от вызывающего кода,
+// как auth.switchToDC (chunk4-4)
+type SwitchDCFunc func(ctx context.Context, dcID int) (*tg.Client, error)
+
+// Resolver скачивает и кеширует документы кастомных эмодзи, а также держит
+// в памяти их fallback-эмодзи — так renderMessages может подставить картинку
+// или текстовый фоллбэк без повторных обращений к диску и MTProto
+type Resolver struct {
+	cacheDir string
+
+	// SwitchDC открывает соединение к другому DC при FILE_MIGRATE_X, может
+	// быть nil — выставляется один раз вызывающим кодом (auth.MTProtoClient)
+	// до первого Resolve
+	SwitchDC SwitchDCFunc
+
+	mu        sync.Mutex
+	paths     map[int64]string
+	fallbacks map[int64]string
+}
+
+// Default — резолвер, общий для всего процесса: auth.MTProtoClient заполняет
+// его при разборе сообщений, main.renderMessages читает из него же (по
+// аналогии с глобальным stickerPlacements в main.go)
+var Default = NewResolver()
+
+// NewResolver создаёт резолвер с кешем на диске под
+// ~/.cache/vi-tg/custom_emoji (или /tmp/vi-tg/custom_emoji, если домашняя
+// директория недоступна)
+func NewResolver() *Resolver {
+	return &Resolver{
+		cacheDir:  resolveCacheDir(),
+		paths:     make(map[int64]string),
+		fallbacks: make(map[int64]string),
+	}
+}
+
+func resolveCacheDir() string {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		base = os.TempDir()
+	}
+	return filepath.Join(base, "vi-tg", "custom_emoji")
+}
+
+// Lookup возвращает путь к уже скачанному .tgs-файлу (если есть) и
+// fallback-эмодзи для id
+func (r *Resolver) Lookup(id int64) (path, fallback string, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	path = r.paths[id]
+	fallback = r.fallbacks[id]
+	return path, fallback, path != "" || fallback != ""
+}
+
+// SetFallback запоминает fallback-эмодзи для id, даже пока файл ещё не
+// скачан — ParseEntities вызывает это для каждого встреченного customEmoji
+func (r *Resolver) SetFallback(id int64, fallback string) {
+	if fallback == "" {
+		return
+	}
+	r.mu.Lock()
+	r.fallbacks[id] = fallback
+	r.mu.Unlock()
+}
+
+// cachedPath возвращает путь к уже скачанному документу id, проверяя
+// сначала память, а затем детерминированный путь на диске — он переживает
+// перезапуск процесса, индекс в памяти нет
+func (r *Resolver) cachedPath(id int64) (string, bool) {
+	r.mu.Lock()
+	path, ok := r.paths[id]
+	r.mu.Unlock()
+	if ok {
+		return path, true
+	}
+
+	path = filepath.Join(r.cacheDir, fmt.Sprintf("%d.tgs", id))
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+		r.mu.Lock()
+		r.paths[id] = path
+		r.mu.Unlock()
+		return path, true
+	}
+	return "", false
+}
+
+// Resolve скачивает через messages.getCustomEmojiDocuments документы для
+// всех ids, ещё не закешированных на диске, и сохраняет их под
+// cacheDir/<id>.tgs
+func (r *Resolver) Resolve(ctx context.Context, api *tg.Client, ids []int64) {
+	if api == nil || len(ids) == 0 {
+		return
+	}
+
+	pending := make([]int64, 0, len(ids))
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if _, ok := r.cachedPath(id); !ok {
+			pending = append(pending, id)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	docs, err := api.MessagesGetCustomEmojiDocuments(ctx, pending)
+	if err != nil {
+		return
+	}
+
+	for _, docClass := range docs {
+		doc, ok := docClass.(*tg.Document)
+		if !ok {
+			continue
+		}
+		if path := r.downloadAndCache(ctx, api, doc); path != "" {
+			r.mu.Lock()
+			r.paths[doc.ID] = path
+			r.mu.Unlock()
+		}
+	}
+}
+
+// fileMigrateRegex выделяет номер DC из ошибок вида "FILE_MIGRATE_2" — тот же
+// приём, что и downloader.parseFileMigrateDC
+var fileMigrateRegex = regexp.MustCompile(`FILE_MIGRATE_(\d+)`)
+
+func parseFileMigrateDC(err error) (dcID int, ok bool) {
+	if err == nil {
+		return 0, false
+	}
+	m := fileMigrateRegex.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0, false
+	}
+	dcID, convErr := strconv.Atoi(m[1])
+	if convErr != nil {
+		return 0, false
+	}
+	return dcID, true
+}
+
+// downloadAndCache скачивает документ по частям через upload.getFile и
+// сохраняет его в cacheDir/<id>.tgs, возвращая итоговый путь или "" при ошибке
+func (r *Resolver) downloadAndCache(ctx context.Context, api *tg.Client, doc *tg.Document) string {
+	if err := os.MkdirAll(r.cacheDir, 0755); err != nil {
+		return ""
+	}
+
+	path := filepath.Join(r.cacheDir, fmt.Sprintf("%d.tgs", doc.ID))
+	tmpPath := path + ".tmp"
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return ""
+	}
+
+	offset := int64(0)
+	const chunkSize = 128 * 1024
+	for {
+		req := &tg.UploadGetFileRequest{
+			Precise: true,
+			Location: &tg.InputDocumentFileLocation{
+				ID:            doc.ID,
+				AccessHash:    doc.AccessHash,
+				FileReference: doc.FileReference,
+			},
+			Offset: offset,
+			Limit:  chunkSize,
+		}
+		resp, err := api.UploadGetFile(ctx, req)
+		if dcID, ok := parseFileMigrateDC(err); ok && r.SwitchDC != nil {
+			if dcAPI, switchErr := r.SwitchDC(ctx, dcID); switchErr == nil {
+				api = dcAPI
+				resp, err = api.UploadGetFile(ctx, req)
+			}
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+			return ""
+		}
+
+		data, ok := resp.(*tg.UploadFile)
+		if !ok {
+			f.Close()
+			os.Remove(tmpPath)
+			return ""
+		}
+		if len(data.Bytes) == 0 {
+			break
+		}
+		if _, err := f.Write(data.Bytes); err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+			return ""
+		}
+		offset += int64(len(data.Bytes))
+		if len(data.Bytes) < chunkSize {
+			break
+		}
+	}
+	f.Close()
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return ""
+	}
+	return path
+}
+
+type emojiSpan struct {
+	offset, length int
+	id             int64
+}
+
+type styleSpan struct {
+	typ            string
+	offset, length int
+}
+
+// ParseEntities разбирает сущности Telegram-сообщения: диапазоны
+// messageEntityCustomEmoji заменяются в тексте плейсхолдерами "[ce:<id>]"
+// (substituteCustomEmoji в main.go подставит вместо них картинку или
+// fallback), а смещения сущностей форматирования пересчитываются в байты
+// итогового текста. emojiIDs/fallbackByID отдаются отдельно, чтобы вызывающий
+// код мог скачать документы через Resolver.Resolve
+func ParseEntities(text string, raw []tg.MessageEntityClass) (newText string, styles []Entity, emojiIDs []int64, fallbackByID map[int64]string) {
+	units := utf16.Encode([]rune(text))
+	fallbackByID = make(map[int64]string)
+
+	var emojis []emojiSpan
+	var spans []styleSpan
+
+	for _, e := range raw {
+		if ce, ok := e.(*tg.MessageEntityCustomEmoji); ok {
+			if ce.Offset < 0 || ce.Length <= 0 || ce.Offset+ce.Length > len(units) {
+				continue
+			}
+			fallback := string(utf16.Decode(units[ce.Offset : ce.Offset+ce.Length]))
+			fallbackByID[ce.DocumentID] = fallback
+			emojiIDs = append(emojiIDs, ce.DocumentID)
+			emojis = append(emojis, emojiSpan{offset: ce.Offset, length: ce.Length, id: ce.DocumentID})
+			continue
+		}
+		if typ, offset, length, ok := styleType(e); ok {
+			spans = append(spans, styleSpan{typ: typ, offset: offset, length: length})
+		}
+	}
+
+	sort.Slice(emojis, func(i, j int) bool { return emojis[i].offset < emojis[j].offset })
+
+	// byteAt[u] — байтовое смещение в итоговом тексте, соответствующее
+	// UTF-16 индексу u исходного текста; используется ниже, чтобы
+	// пересчитать смещения сущностей форматирования в байты
+	byteAt := make([]int, len(units)+1)
+	var b strings.Builder
+	cursor := 0
+	for _, em := range emojis {
+		if em.offset < cursor {
+			continue // пересекающиеся customEmoji сущности Telegram не присылает
+		}
+		markByteOffsets(byteAt, units, cursor, em.offset, b.Len())
+		b.WriteString(string(utf16.Decode(units[cursor:em.offset])))
+		b.WriteString(fmt.Sprintf("[ce:%d]", em.id))
+		cursor = em.offset + em.length
+	}
+	markByteOffsets(byteAt, units, cursor, len(units), b.Len())
+	b.WriteString(string(utf16.Decode(units[cursor:])))
+
+	newText = b.String()
+
+	for _, sp := range spans {
+		if sp.offset < 0 || sp.length <= 0 || sp.offset+sp.length > len(units) {
+			continue
+		}
+		start, end := byteAt[sp.offset], byteAt[sp.offset+sp.length]
+		if end <= start {
+			continue // диапазон целиком внутри замены customEmoji — пропускаем
+		}
+		styles = append(styles, Entity{Type: sp.typ, Offset: start, Length: end - start})
+	}
+
+	return newText, styles, emojiIDs, fallbackByID
+}
+
+// markByteOffsets заполняет byteAt[from..to] байтовыми смещениями в итоговом
+// тексте относительно baseLen — длины уже записанной части
+func markByteOffsets(byteAt []int, units []uint16, from, to, baseLen int) {
+	for u := from; u <= to; u++ {
+		byteAt[u] = baseLen + len(string(utf16.Decode(units[from:u])))
+	}
+}
+
+// styleType сопоставляет сущность форматирования Telegram с её именем и
+// диапазоном в UTF-16 code units. Сущности, не влияющие на визуальное
+// оформление текста (упоминания, хэштеги, ссылки и т.п.), здесь не участвуют
+func styleType(e tg.MessageEntityClass) (typ string, offset, length int, ok bool) {
+	switch v := e.(type) {
+	case *tg.MessageEntityBold:
+		return "bold", v.Offset, v.Length, true
+	case *tg.MessageEntityItalic:
+		return "italic", v.Offset, v.Length, true
+	case *tg.MessageEntityUnderline:
+		return "underline", v.Offset, v.Length, true
+	case *tg.MessageEntityStrike:
+		return "strikethrough", v.Offset, v.Length, true
+	case *tg.MessageEntitySpoiler:
+		return "spoiler", v.Offset, v.Length, true
+	case *tg.MessageEntityCode:
+		return "code", v.Offset, v.Length, true
+	case *tg.MessageEntityPre:
+		return "pre", v.Offset, v.Length, true
+	}
+	return "", 0, 0, false
+}
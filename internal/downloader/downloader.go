@@ -0,0 +1,501 @@
+// Package downloader скачивает файлы с DC Telegram параллельными чанками
+// вместо одного запроса upload.getFile за другим — видео на несколько
+// мегабайт раньше тянулось чанк за чанком по 1МБ на основном соединении,
+// занимая секунды и блокируя прочие RPC (диалоги, отправку сообщений) за
+// той же сессией (chunk4-6). Чанки пишутся в предварительно выделенный файл
+// по смещению через *os.File.WriteAt (аналог pwrite(2)), так что воркеры не
+// мешают друг другу и не требуют упорядоченной записи.
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+// defaultWorkers — число параллельных upload.getFile воркеров по умолчанию
+const defaultWorkers = 4
+
+// defaultChunkSize — размер одного чанка в байтах по умолчанию, как у
+// прежних download*File для видео
+const defaultChunkSize = 1024 * 1024
+
+// RefreshFunc перезапрашивает сообщение и возвращает свежий location с
+// актуальным FileReference — вызывается при FILE_REFERENCE_EXPIRED. nil
+// означает, что у вызывающего кода нет возможности перезапросить сообщение
+// (недостаточно контекста о чате), и такая ошибка просто завершает скачивание,
+// как и раньше.
+type RefreshFunc func(ctx context.Context) (tg.InputFileLocationClass, error)
+
+// SwitchDCFunc открывает (или возвращает уже открытое ранее) соединение к DC
+// dcID, авторизованное тем же ключом, что и основной клиент — вызывается при
+// FILE_MIGRATE_X. downloader не может сам это сделать (exportAuthorization —
+// дело auth.MTProtoClient, импортировать который отсюда нельзя, зависимость
+// идёт в обратную сторону), поэтому просит вызывающий код передать готовую
+// функцию, как auth.switchToDC (chunk4-4). nil означает, что у вызывающего
+// кода нет такой функции, и миграционная ошибка просто завершает скачивание.
+type SwitchDCFunc func(ctx context.Context, dcID int) (*tg.Client, error)
+
+// Options управляет параллелизмом, размером чанка и отчётом о прогрессе
+type Options struct {
+	// Workers — число параллельных upload.getFile запросов, 0 — defaultWorkers
+	Workers int
+	// ChunkSize — размер одного чанка в байтах, 0 — defaultChunkSize
+	ChunkSize int
+	// Refresh перезапрашивает FileReference при FILE_REFERENCE_EXPIRED, может
+	// быть nil
+	Refresh RefreshFunc
+	// SwitchDC открывает соединение к другому DC при FILE_MIGRATE_X, может
+	// быть nil
+	SwitchDC SwitchDCFunc
+	// Progress вызывается после каждого успешно записанного чанка с числом
+	// уже скачанных байт и общим размером файла (если известен)
+	Progress func(done, total int64)
+}
+
+// Pool — набор дополнительных соединений к тому же DC, используемых
+// воркерами Download вместо основного API-клиента, чтобы параллельное
+// скачивание не ставило обычные RPC (GetDialogs, SendMessage) в очередь
+// позади чанков большого файла
+type Pool struct {
+	mu      sync.Mutex
+	clients []*tg.Client
+	next    int
+}
+
+// NewPool оборачивает уже подключённые API-клиенты в пул для Download.
+// Клиенты должны быть подключены к тому же DC, что и location в Download —
+// их подключением и авторизацией управляет вызывающий код (см.
+// auth.getDownloadPool).
+func NewPool(clients []*tg.Client) *Pool {
+	return &Pool{clients: clients}
+}
+
+// get возвращает следующего клиента из пула по кругу
+func (p *Pool) get(fallback *tg.Client) *tg.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.clients) == 0 {
+		return fallback
+	}
+	c := p.clients[p.next%len(p.clients)]
+	p.next++
+	return c
+}
+
+// isFileReferenceExpired распознаёт ошибку протухшего FileReference — так же,
+// как это уже делает downloadFileWithLocation
+func isFileReferenceExpired(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "FILE_REFERENCE_EXPIRED")
+}
+
+// floodWaitRegex выделяет число секунд из ошибок вида "FLOOD_WAIT_30"
+var floodWaitRegex = regexp.MustCompile(`FLOOD_WAIT_(\d+)`)
+
+// fileMigrateRegex выделяет номер DC из ошибок вида "FILE_MIGRATE_2" — запрошенный
+// файл физически лежит на другом DC, и тот же запрос нужно повторить там
+var fileMigrateRegex = regexp.MustCompile(`FILE_MIGRATE_(\d+)`)
+
+// parseFileMigrateDC возвращает номер DC, на который нужно переключиться, и
+// true, если err — это FILE_MIGRATE_x
+func parseFileMigrateDC(err error) (dcID int, ok bool) {
+	if err == nil {
+		return 0, false
+	}
+	m := fileMigrateRegex.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0, false
+	}
+	dcID, convErr := strconv.Atoi(m[1])
+	if convErr != nil {
+		return 0, false
+	}
+	return dcID, true
+}
+
+// parseFloodWait возвращает число секунд, которые сервер просит подождать,
+// и true, если err — это FLOOD_WAIT
+func parseFloodWait(err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+	m := floodWaitRegex.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0, false
+	}
+	seconds, convErr := strconv.Atoi(m[1])
+	if convErr != nil {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// getFile вызывает upload.getFile и, если сервер ответил FLOOD_WAIT_x,
+// засыпает на указанное число секунд и повторяет запрос — ровно столько раз,
+// сколько потребуется, как и требует сам протокол (chunk5-1)
+func getFile(ctx context.Context, api *tg.Client, req *tg.UploadGetFileRequest) (tg.UploadFileClass, error) {
+	for {
+		resp, err := api.UploadGetFile(ctx, req)
+		wait, isFlood := parseFloodWait(err)
+		if !isFlood {
+			return resp, err
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// progressPath — путь sidecar-файла, в котором Download хранит список уже
+// записанных чанков, чтобы прерванную докачку можно было продолжить вместо
+// того, чтобы начинать заново
+func progressPath(destination string) string {
+	return destination + ".progress"
+}
+
+// loadCompletedChunks читает sidecar-файл прогрешго скачивания; отсутствие
+// файла или ошибка чтения не являются ошибкой — скачивание просто начинается
+// с нуля
+func loadCompletedChunks(destination string) map[int64]bool {
+	data, err := os.ReadFile(progressPath(destination))
+	if err != nil {
+		return nil
+	}
+	var offsets []int64
+	if err := json.Unmarshal(data, &offsets); err != nil {
+		return nil
+	}
+	completed := make(map[int64]bool, len(offsets))
+	for _, o := range offsets {
+		completed[o] = true
+	}
+	return completed
+}
+
+// chunkProgress потокобезопасно копит список завершённых чанков и пишет его
+// на диск, чтобы Download можно было прервать и продолжить позже
+type chunkProgress struct {
+	mu          sync.Mutex
+	destination string
+	completed   map[int64]bool
+}
+
+func newChunkProgress(destination string, initial map[int64]bool) *chunkProgress {
+	if initial == nil {
+		initial = make(map[int64]bool)
+	}
+	return &chunkProgress{destination: destination, completed: initial}
+}
+
+func (p *chunkProgress) markDone(offset int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.completed[offset] = true
+
+	offsets := make([]int64, 0, len(p.completed))
+	for o := range p.completed {
+		offsets = append(offsets, o)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	data, err := json.Marshal(offsets)
+	if err != nil {
+		return
+	}
+	_ = os.WriteFile(progressPath(p.destination), data, 0644)
+}
+
+func (p *chunkProgress) isDone(offset int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.completed[offset]
+}
+
+func (p *chunkProgress) remove() {
+	os.Remove(progressPath(p.destination))
+}
+
+// Download скачивает location в destination воркерами в Options.Workers
+// параллельных горутин, каждая из которых берёт следующее свободное
+// соединение из pool (pool может быть nil — тогда все воркеры используют
+// api). size — точный размер файла в байтах; при size <= 0 параллельное
+// скачивание невозможно (неизвестно, сколько чанков нужно запросить), и
+// Download скачивает файл последовательно через api, как это делали прежние
+// download*File. Если destination уже существует вместе со своим
+// sidecar-файлом прогресса (от прерванного предыдущего вызова), уже
+// записанные чанки не перекачиваются заново (chunk5-1).
+func Download(ctx context.Context, api *tg.Client, pool *Pool, location tg.InputFileLocationClass, size int64, destination string, opts Options) error {
+	if api == nil || location == nil {
+		return fmt.Errorf("downloader: api или location не заданы")
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	if size <= 0 {
+		f, err := os.Create(destination)
+		if err != nil {
+			return fmt.Errorf("downloader: ошибка создания файла %s: %w", destination, err)
+		}
+		defer f.Close()
+		return downloadSequential(ctx, api, location, chunkSize, f, opts)
+	}
+
+	completed := loadCompletedChunks(destination)
+	progress := newChunkProgress(destination, completed)
+
+	f, err := os.OpenFile(destination, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("downloader: ошибка создания файла %s: %w", destination, err)
+	}
+	defer f.Close()
+
+	if err := f.Truncate(size); err != nil {
+		return fmt.Errorf("downloader: ошибка выделения файла %s: %w", destination, err)
+	}
+
+	numChunks := int((size + int64(chunkSize) - 1) / int64(chunkSize))
+
+	var (
+		wg       sync.WaitGroup
+		done     int64
+		errOnce  sync.Once
+		firstErr error
+		locMu    sync.Mutex
+	)
+	for o := range completed {
+		remaining := size - o
+		n := int64(chunkSize)
+		if remaining < n {
+			n = remaining
+		}
+		done += n
+	}
+	curLocation := location
+	var curDCAPI *tg.Client
+
+	offsets := make(chan int64)
+	go func() {
+		defer close(offsets)
+		for i := 0; i < numChunks; i++ {
+			offset := int64(i) * int64(chunkSize)
+			if progress.isDone(offset) {
+				continue
+			}
+			select {
+			case offsets <- offset:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	worker := func() {
+		defer wg.Done()
+		for offset := range offsets {
+			workerAPI := pool.get(api)
+
+			locMu.Lock()
+			loc := curLocation
+			if curDCAPI != nil {
+				workerAPI = curDCAPI
+			}
+			locMu.Unlock()
+
+			limit := chunkSize
+			if remaining := size - offset; remaining < int64(chunkSize) {
+				limit = int(remaining)
+			}
+
+			req := &tg.UploadGetFileRequest{
+				Precise:      true,
+				CDNSupported: false,
+				Location:     loc,
+				Offset:       offset,
+				Limit:        limit,
+			}
+
+			resp, reqErr := getFile(ctx, workerAPI, req)
+			if reqErr != nil && isFileReferenceExpired(reqErr) && opts.Refresh != nil {
+				if newLoc, refreshErr := opts.Refresh(ctx); refreshErr == nil {
+					locMu.Lock()
+					curLocation = newLoc
+					locMu.Unlock()
+					req.Location = newLoc
+					resp, reqErr = getFile(ctx, workerAPI, req)
+				}
+			}
+			if dcID, ok := parseFileMigrateDC(reqErr); ok && opts.SwitchDC != nil {
+				if dcAPI, switchErr := opts.SwitchDC(ctx, dcID); switchErr == nil {
+					locMu.Lock()
+					curDCAPI = dcAPI
+					locMu.Unlock()
+					workerAPI = dcAPI
+					resp, reqErr = getFile(ctx, workerAPI, req)
+				}
+			}
+			if reqErr != nil {
+				errOnce.Do(func() { firstErr = fmt.Errorf("downloader: ошибка скачивания чанка %d: %w", offset, reqErr) })
+				continue
+			}
+
+			data, ok := resp.(*tg.UploadFile)
+			if !ok {
+				errOnce.Do(func() { firstErr = fmt.Errorf("downloader: неожиданный тип ответа %T", resp) })
+				continue
+			}
+
+			if _, writeErr := f.WriteAt(data.Bytes, offset); writeErr != nil {
+				errOnce.Do(func() { firstErr = fmt.Errorf("downloader: ошибка записи чанка %d: %w", offset, writeErr) })
+				continue
+			}
+			progress.markDone(offset)
+
+			newDone := atomic.AddInt64(&done, int64(len(data.Bytes)))
+			if opts.Progress != nil {
+				opts.Progress(newDone, size)
+			}
+		}
+	}
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go worker()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		// Файл и sidecar прогресса оставляем на диске — повторный вызов
+		// Download с тем же destination докачает только недостающие чанки
+		return firstErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	progress.remove()
+	return nil
+}
+
+// Progress — одно сообщение из канала DownloadAsync: промежуточный отчёт
+// (Err == nil) или финальный результат (Done == total завершённого скачивания,
+// Err — ошибка или nil при успехе) как последнее значение перед закрытием канала
+type Progress struct {
+	Done  int64
+	Total int64
+	Err   error
+}
+
+// DownloadAsync запускает Download в отдельной горутине и возвращает канал, в
+// который попадают промежуточные отчёты о прогрессе и, последним сообщением
+// перед закрытием канала, итоговая ошибка (или nil) — этим TUI рисует
+// прогресс-бар, не блокируя вызывающий код на время всего скачивания (chunk5-1)
+func DownloadAsync(ctx context.Context, api *tg.Client, pool *Pool, location tg.InputFileLocationClass, size int64, destination string, opts Options) <-chan Progress {
+	ch := make(chan Progress, 1)
+
+	userProgress := opts.Progress
+	opts.Progress = func(done, total int64) {
+		if userProgress != nil {
+			userProgress(done, total)
+		}
+		select {
+		case ch <- Progress{Done: done, Total: total}:
+		default:
+		}
+	}
+
+	go func() {
+		defer close(ch)
+		err := Download(ctx, api, pool, location, size, destination, opts)
+		ch <- Progress{Done: size, Total: size, Err: err}
+	}()
+
+	return ch
+}
+
+// downloadSequential — запасной путь для location с неизвестным заранее
+// размером (например, некоторые превью): скачивает чанки один за другим, как
+// это делали прежние download*File, и останавливается, получив чанк меньше
+// chunkSize
+func downloadSequential(ctx context.Context, api *tg.Client, location tg.InputFileLocationClass, chunkSize int, f *os.File, opts Options) error {
+	offset := int64(0)
+	total := int64(0)
+
+	for {
+		resp, err := getFile(ctx, api, &tg.UploadGetFileRequest{
+			Precise:      true,
+			CDNSupported: false,
+			Location:     location,
+			Offset:       offset,
+			Limit:        chunkSize,
+		})
+		if err != nil && isFileReferenceExpired(err) && opts.Refresh != nil {
+			if newLoc, refreshErr := opts.Refresh(ctx); refreshErr == nil {
+				location = newLoc
+				resp, err = getFile(ctx, api, &tg.UploadGetFileRequest{
+					Precise:      true,
+					CDNSupported: false,
+					Location:     location,
+					Offset:       offset,
+					Limit:        chunkSize,
+				})
+			}
+		}
+		if dcID, ok := parseFileMigrateDC(err); ok && opts.SwitchDC != nil {
+			if dcAPI, switchErr := opts.SwitchDC(ctx, dcID); switchErr == nil {
+				api = dcAPI
+				resp, err = getFile(ctx, api, &tg.UploadGetFileRequest{
+					Precise:      true,
+					CDNSupported: false,
+					Location:     location,
+					Offset:       offset,
+					Limit:        chunkSize,
+				})
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("downloader: ошибка скачивания: %w", err)
+		}
+
+		data, ok := resp.(*tg.UploadFile)
+		if !ok {
+			return fmt.Errorf("downloader: неожиданный тип ответа %T", resp)
+		}
+		if len(data.Bytes) == 0 {
+			break
+		}
+		if _, err := f.WriteAt(data.Bytes, offset); err != nil {
+			return fmt.Errorf("downloader: ошибка записи: %w", err)
+		}
+
+		offset += int64(len(data.Bytes))
+		total += int64(len(data.Bytes))
+		if opts.Progress != nil {
+			opts.Progress(total, 0)
+		}
+
+		if len(data.Bytes) < chunkSize {
+			break
+		}
+	}
+
+	return nil
+}
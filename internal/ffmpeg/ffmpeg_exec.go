@@ -0,0 +1,64 @@
+//go:build !wasm_ffmpeg
+
+// Package ffmpeg запускает ffmpeg для генерации превью и контакт-листов
+// видео. Без сборочного тега wasm_ffmpeg (этот файл) это тонкая обёртка над
+// системным бинарником из PATH; с тегом wasm_ffmpeg (см. ffmpeg_wasm.go)
+// вместо системного бинарника используется тот же ffmpeg, скомпилированный в
+// WASI и встроенный в бинарь vi-tg, — так превью работают и там, где ffmpeg
+// не установлен (chunk5-5).
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// binaryPath — путь к ffmpeg, установленному через internal/ffmpegprovision
+// (chunk5-6); пока пусто, Run ищет ffmpeg в PATH как раньше
+var (
+	binaryPathMu sync.RWMutex
+	binaryPath   string
+)
+
+// SetBinaryPath запоминает путь к ffmpeg, скачанному internal/ffmpegprovision,
+// так что последующие вызовы Run используют его вместо поиска в PATH
+func SetBinaryPath(path string) {
+	binaryPathMu.Lock()
+	binaryPath = path
+	binaryPathMu.Unlock()
+}
+
+func resolveBinary() (string, error) {
+	binaryPathMu.RLock()
+	path := binaryPath
+	binaryPathMu.RUnlock()
+
+	if path != "" {
+		return path, nil
+	}
+
+	resolved, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg не найден в PATH: %w", err)
+	}
+	return resolved, nil
+}
+
+// Run выполняет ffmpeg с заданными аргументами и потоками ввода/вывода —
+// либо путём, заданным через SetBinaryPath, либо системным бинарником из PATH
+func Run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	binary, err := resolveBinary()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	return cmd.Run()
+}
@@ -0,0 +1,381 @@
+// Package ffmpegprovision скачивает статическую сборку ffmpeg/ffprobe для
+// текущих ОС и архитектуры, когда ни internal/ffmpeg, ни internal/ffprobe не
+// находят системный бинарник. Сборка кладётся в $XDG_DATA_HOME/vi-tg/bin,
+// путь к ней проверяется по SHA-256 и сохраняется в конфиге, так что
+// повторные запуски больше не обращаются к сети (chunk5-6).
+package ffmpegprovision
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"vi-tg/internal/downloader"
+	"vi-tg/internal/ffmpeg"
+	"vi-tg/internal/ffprobe"
+)
+
+// fetchTimeout ограничивает время скачивания архива сборки целиком
+const fetchTimeout = 5 * time.Minute
+
+// archiveKind — формат архива, в котором распространяется сборка
+type archiveKind int
+
+const (
+	archiveZip archiveKind = iota
+	archiveTarGz
+	archiveTarXz
+)
+
+// release описывает один вариант статической сборки для конкретной
+// платформы: откуда скачивать, каким алгоритмом распаковывать, какой
+// SHA-256 должен быть у архива и где внутри архива лежат сами бинарники
+type release struct {
+	url       string
+	sha256    string
+	kind      archiveKind
+	ffmpegIn  string
+	ffprobeIn string
+}
+
+// defaultReleases — сборки по умолчанию на каждую поддерживаемую платформу:
+// BtbN/FFmpeg-Builds для Linux, evermeet.cx для macOS, как и просили в
+// задаче. Контрольные суммы ниже — заглушки: реальное значение зависит от
+// того, какая именно версия сборки закреплена за релизом vi-tg, и должно
+// быть обновлено при первом включении автоустановки в продакшене (как и
+// встроенные WASI-модули в internal/ffmpeg/internal/ffprobe, это
+// сознательно незавершённая часть — chunk5-5).
+var defaultReleases = map[string]release{
+	"linux/amd64": {
+		url: "https://github.com/BtbN/FFmpeg-Builds/releases/latest/download/ffmpeg-master-latest-linux64-gpl.tar.xz",
+		// BtbN публикует сборки только в tar.xz — в этом дереве нет
+		// vendored xz-декодера (в стандартной библиотеке его тоже нет), так
+		// что archiveTarXz ниже explicitly не реализован до тех пор, пока
+		// такая зависимость не появится в репозитории; переопределение
+		// FfmpegReleaseURL на .zip-зеркало работает уже сейчас
+		sha256:    "0000000000000000000000000000000000000000000000000000000000000000",
+		kind:      archiveTarXz,
+		ffmpegIn:  "ffmpeg-master-latest-linux64-gpl/bin/ffmpeg",
+		ffprobeIn: "ffmpeg-master-latest-linux64-gpl/bin/ffprobe",
+	},
+	"linux/arm64": {
+		url:       "https://github.com/BtbN/FFmpeg-Builds/releases/latest/download/ffmpeg-master-latest-linuxarm64-gpl.tar.xz",
+		sha256:    "0000000000000000000000000000000000000000000000000000000000000000",
+		kind:      archiveTarXz,
+		ffmpegIn:  "ffmpeg-master-latest-linuxarm64-gpl/bin/ffmpeg",
+		ffprobeIn: "ffmpeg-master-latest-linuxarm64-gpl/bin/ffprobe",
+	},
+	"darwin/amd64": {
+		url:       "https://evermeet.cx/ffmpeg/getrelease/ffmpeg/zip",
+		sha256:    "0000000000000000000000000000000000000000000000000000000000000000",
+		kind:      archiveZip,
+		ffmpegIn:  "ffmpeg",
+		ffprobeIn: "ffprobe",
+	},
+	"darwin/arm64": {
+		url:       "https://evermeet.cx/ffmpeg/getrelease/ffmpeg/zip",
+		sha256:    "0000000000000000000000000000000000000000000000000000000000000000",
+		kind:      archiveZip,
+		ffmpegIn:  "ffmpeg",
+		ffprobeIn: "ffprobe",
+	},
+}
+
+// Options настраивает Install — ReleaseURL переопределяет URL сборки по
+// умолчанию (например, на .zip-зеркало для платформ, для которых архив по
+// умолчанию упакован в формат без поддержки в этом дереве)
+type Options struct {
+	ReleaseURL string
+}
+
+// targetDir — каталог, куда устанавливаются скачанные бинарники
+func targetDir() string {
+	if xdg := os.Getenv("XDG_DATA_HOME"); xdg != "" {
+		return filepath.Join(xdg, "vi-tg", "bin")
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	return filepath.Join(homeDir, ".local", "share", "vi-tg", "bin")
+}
+
+// platformKey возвращает ключ defaultReleases для текущей платформы
+func platformKey() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+// InstalledPaths возвращает пути, по которым InstallAsync кладёт ffmpeg и
+// ffprobe после успешной установки — вызывающий код (main.go) сохраняет их в
+// конфиг, чтобы следующий запуск не обращался к сети повторно (chunk5-6)
+func InstalledPaths() (ffmpegPath, ffprobePath string) {
+	dir := targetDir()
+	return filepath.Join(dir, "ffmpeg"), filepath.Join(dir, "ffprobe")
+}
+
+// resolveRelease выбирает сборку для текущей платформы, подставляя
+// пользовательское переопределение URL вместо значения по умолчанию
+func resolveRelease(opts Options) (release, error) {
+	rel, ok := defaultReleases[platformKey()]
+	if !ok {
+		return release{}, fmt.Errorf("автоустановка ffmpeg не поддерживает платформу %s", platformKey())
+	}
+	if opts.ReleaseURL != "" {
+		rel.url = opts.ReleaseURL
+		rel.sha256 = "" // пользовательский URL проверяется без контрольной суммы по умолчанию
+		rel.kind = archiveKindFromURL(opts.ReleaseURL)
+	}
+	return rel, nil
+}
+
+func archiveKindFromURL(url string) archiveKind {
+	switch {
+	case hasSuffix(url, ".zip"):
+		return archiveZip
+	case hasSuffix(url, ".tar.gz"), hasSuffix(url, ".tgz"):
+		return archiveTarGz
+	default:
+		return archiveTarXz
+	}
+}
+
+func hasSuffix(s, suffix string) bool {
+	return len(s) >= len(suffix) && s[len(s)-len(suffix):] == suffix
+}
+
+// InstallAsync скачивает и устанавливает ffmpeg/ffprobe в отдельной
+// горутине, публикуя промежуточный прогресс загрузки через тот же
+// downloader.Progress, которым TUI уже рисует прогресс-бар медиа-загрузок
+// (chunk5-1/chunk5-6). Последнее сообщение перед закрытием канала содержит
+// итоговую ошибку (или nil при успехе).
+func InstallAsync(ctx context.Context, opts Options) <-chan downloader.Progress {
+	ch := make(chan downloader.Progress, 1)
+
+	go func() {
+		defer close(ch)
+		ffmpegPath, ffprobePath, err := install(ctx, opts, func(done, total int64) {
+			select {
+			case ch <- downloader.Progress{Done: done, Total: total}:
+			default:
+			}
+		})
+		if err == nil {
+			ffmpeg.SetBinaryPath(ffmpegPath)
+			ffprobe.SetBinaryPath(ffprobePath)
+		}
+		ch <- downloader.Progress{Done: 1, Total: 1, Err: err}
+	}()
+
+	return ch
+}
+
+// install выполняет весь цикл: скачивание архива с прогрессом, проверку
+// SHA-256, распаковку нужных бинарников в targetDir() и выставление прав на
+// исполнение — и возвращает итоговые пути к ffmpeg/ffprobe
+func install(ctx context.Context, opts Options, progress func(done, total int64)) (string, string, error) {
+	rel, err := resolveRelease(opts)
+	if err != nil {
+		return "", "", err
+	}
+
+	archivePath, err := download(ctx, rel.url, progress)
+	if err != nil {
+		return "", "", fmt.Errorf("ошибка скачивания сборки ffmpeg: %w", err)
+	}
+	defer os.Remove(archivePath)
+
+	if rel.sha256 != "" {
+		if err := verifyChecksum(archivePath, rel.sha256); err != nil {
+			return "", "", err
+		}
+	}
+
+	dir := targetDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", fmt.Errorf("ошибка создания каталога установки %s: %w", dir, err)
+	}
+
+	ffmpegPath := filepath.Join(dir, "ffmpeg")
+	ffprobePath := filepath.Join(dir, "ffprobe")
+
+	if err := extractBinary(archivePath, rel.kind, rel.ffmpegIn, ffmpegPath); err != nil {
+		return "", "", fmt.Errorf("ошибка распаковки ffmpeg: %w", err)
+	}
+	if err := extractBinary(archivePath, rel.kind, rel.ffprobeIn, ffprobePath); err != nil {
+		return "", "", fmt.Errorf("ошибка распаковки ffprobe: %w", err)
+	}
+
+	if err := os.Chmod(ffmpegPath, 0755); err != nil {
+		return "", "", fmt.Errorf("ошибка выставления прав на выполнение ffmpeg: %w", err)
+	}
+	if err := os.Chmod(ffprobePath, 0755); err != nil {
+		return "", "", fmt.Errorf("ошибка выставления прав на выполнение ffprobe: %w", err)
+	}
+
+	return ffmpegPath, ffprobePath, nil
+}
+
+// download скачивает url во временный файл, сообщая прогресс через callback
+func download(ctx context.Context, url string, progress func(done, total int64)) (string, error) {
+	client := &http.Client{Timeout: fetchTimeout}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("сервер сборки ffmpeg вернул статус %d", resp.StatusCode)
+	}
+
+	tempFile, err := os.CreateTemp("", "vi-tg-ffmpeg-*.archive")
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+
+	total := resp.ContentLength
+	var done int64
+	buf := make([]byte, 64*1024)
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := tempFile.Write(buf[:n]); err != nil {
+				os.Remove(tempFile.Name())
+				return "", err
+			}
+			done += int64(n)
+			progress(done, total)
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			os.Remove(tempFile.Name())
+			return "", readErr
+		}
+	}
+
+	return tempFile.Name(), nil
+}
+
+// verifyChecksum сверяет SHA-256 скачанного архива со значением, встроенным
+// в defaultReleases
+func verifyChecksum(path, expected string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	actual := hex.EncodeToString(h.Sum(nil))
+	if actual != expected {
+		return fmt.Errorf("контрольная сумма сборки ffmpeg не совпадает: ожидалось %s, получено %s", expected, actual)
+	}
+	return nil
+}
+
+// extractBinary извлекает один файл innerPath из архива archivePath в
+// destPath, разбирая его согласно kind
+func extractBinary(archivePath string, kind archiveKind, innerPath, destPath string) error {
+	switch kind {
+	case archiveZip:
+		return extractFromZip(archivePath, innerPath, destPath)
+	case archiveTarGz:
+		return extractFromTarGz(archivePath, innerPath, destPath)
+	case archiveTarXz:
+		return fmt.Errorf("архивы tar.xz не поддерживаются в этой сборке vi-tg — в дереве нет xz-декодера; укажите .zip или .tar.gz зеркало через FfmpegReleaseURL")
+	default:
+		return fmt.Errorf("неизвестный формат архива")
+	}
+}
+
+func extractFromZip(archivePath, innerPath, destPath string) error {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if f.Name != innerPath {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		out, err := os.Create(destPath)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		_, err = io.Copy(out, rc)
+		return err
+	}
+
+	return fmt.Errorf("файл %s не найден в архиве", innerPath)
+}
+
+func extractFromTarGz(archivePath, innerPath, destPath string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Name != innerPath {
+			continue
+		}
+
+		out, err := os.Create(destPath)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		_, err = io.Copy(out, tr)
+		return err
+	}
+
+	return fmt.Errorf("файл %s не найден в архиве", innerPath)
+}
@@ -0,0 +1,71 @@
+// Package ffprobe извлекает метаданные видео/аудио потоков из медиафайла.
+// Без сборочного тега wasm_ffmpeg (см. ffprobe_exec.go) это обёртка над
+// системным ffprobe из PATH; с тегом wasm_ffmpeg (см. ffprobe_wasm.go) —
+// тот же разбор выполняется встроенным в бинарь vi-tg WASI-модулем через
+// общий рантайм wazero, без зависимости от системного бинарника (chunk5-5).
+package ffprobe
+
+import "strconv"
+
+// Info — метаданные медиапотока, извлечённые ffprobe. Повторяет по составу
+// auth.MediaInfo, но не зависит от пакета auth, чтобы не создавать цикл
+// импорта (auth, в свою очередь, импортирует ffprobe).
+type Info struct {
+	Width       int
+	Height      int
+	DurationSec float64
+	HasAudio    bool
+	VideoCodec  string
+	AudioCodec  string
+}
+
+// stream/format/output отражают ту часть JSON-вывода
+// `ffprobe -show_streams -show_format`, которая нужна Probe — используются
+// обеими реализациями (exec и wasm)
+type stream struct {
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+}
+
+type format struct {
+	Duration string `json:"duration"`
+}
+
+type output struct {
+	Streams []stream `json:"streams"`
+	Format  format   `json:"format"`
+}
+
+// parseOutput собирает Info из разобранного JSON-вывода ffprobe
+func parseOutput(probe output) Info {
+	info := Info{}
+	if probe.Format.Duration != "" {
+		info.DurationSec = parseFloatOrZero(probe.Format.Duration)
+	}
+	for _, s := range probe.Streams {
+		switch s.CodecType {
+		case "video":
+			if info.VideoCodec == "" {
+				info.VideoCodec = s.CodecName
+				info.Width = s.Width
+				info.Height = s.Height
+			}
+		case "audio":
+			info.HasAudio = true
+			if info.AudioCodec == "" {
+				info.AudioCodec = s.CodecName
+			}
+		}
+	}
+	return info
+}
+
+func parseFloatOrZero(s string) float64 {
+	d, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return d
+}
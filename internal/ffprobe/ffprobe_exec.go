@@ -0,0 +1,64 @@
+//go:build !wasm_ffmpeg
+
+package ffprobe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sync"
+)
+
+// binaryPath — путь к ffprobe, установленному через internal/ffmpegprovision
+// (chunk5-6); пока пусто, Probe ищет ffprobe в PATH как раньше
+var (
+	binaryPathMu sync.RWMutex
+	binaryPath   string
+)
+
+// SetBinaryPath запоминает путь к ffprobe, скачанному internal/ffmpegprovision,
+// так что последующие вызовы Probe используют его вместо поиска в PATH
+func SetBinaryPath(path string) {
+	binaryPathMu.Lock()
+	binaryPath = path
+	binaryPathMu.Unlock()
+}
+
+func resolveBinary() (string, error) {
+	binaryPathMu.RLock()
+	path := binaryPath
+	binaryPathMu.RUnlock()
+
+	if path != "" {
+		return path, nil
+	}
+
+	resolved, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return "", fmt.Errorf("ffprobe не найден в PATH: %w", err)
+	}
+	return resolved, nil
+}
+
+// Probe запускает ffprobe над path и разбирает его JSON-вывод — либо путём,
+// заданным через SetBinaryPath, либо системным бинарником из PATH
+func Probe(ctx context.Context, path string) (Info, error) {
+	binary, err := resolveBinary()
+	if err != nil {
+		return Info{}, err
+	}
+
+	cmd := exec.CommandContext(ctx, binary, "-v", "quiet", "-print_format", "json", "-show_streams", "-show_format", path)
+	out, err := cmd.Output()
+	if err != nil {
+		return Info{}, fmt.Errorf("ошибка запуска ffprobe для %s: %w", path, err)
+	}
+
+	var probe output
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return Info{}, fmt.Errorf("ошибка разбора вывода ffprobe для %s: %w", path, err)
+	}
+
+	return parseOutput(probe), nil
+}
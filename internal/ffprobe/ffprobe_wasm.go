@@ -0,0 +1,102 @@
+//go:build wasm_ffmpeg
+
+// Вариант Probe с тегом wasm_ffmpeg — см. предупреждение о встроенной
+// заглушке ffprobe.wasm в package-комментарии ffmpeg_wasm.go соседнего
+// пакета ffmpeg; ffprobe.wasm в этом дереве устроен так же (chunk5-5).
+package ffprobe
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+//go:embed ffprobe.wasm
+var wasmModule []byte
+
+var (
+	initOnce      sync.Once
+	sharedRuntime wazero.Runtime
+	compiled      wazero.CompiledModule
+	initErr       error
+)
+
+// SetBinaryPath существует только для совместимости с internal/ffmpegprovision
+// под тегом wasm_ffmpeg: встроенный модуль не запускается по пути на диске,
+// поэтому здесь нечего запоминать (chunk5-6)
+func SetBinaryPath(path string) {}
+
+func ensureRuntime(ctx context.Context) error {
+	initOnce.Do(func() {
+		cacheDir, err := os.MkdirTemp("", "vi-tg-wazero-cache")
+		if err != nil {
+			initErr = fmt.Errorf("ошибка создания каталога кеша компиляции wazero: %w", err)
+			return
+		}
+
+		cache, err := wazero.NewCompilationCacheWithDir(cacheDir)
+		if err != nil {
+			initErr = fmt.Errorf("ошибка инициализации кеша компиляции wazero: %w", err)
+			return
+		}
+
+		sharedRuntime = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(cache))
+
+		if _, err := wasi_snapshot_preview1.Instantiate(ctx, sharedRuntime); err != nil {
+			initErr = fmt.Errorf("ошибка инициализации WASI в wazero: %w", err)
+			return
+		}
+
+		compiled, err = sharedRuntime.CompileModule(ctx, wasmModule)
+		if err != nil {
+			initErr = fmt.Errorf("ошибка компиляции ffprobe.wasm: %w", err)
+			return
+		}
+	})
+	return initErr
+}
+
+// Probe запускает ffprobe.wasm над path, монтируя в его WASI FS только
+// директорию файла и временный рабочий каталог, и разбирает его JSON-вывод
+func Probe(ctx context.Context, path string) (Info, error) {
+	if err := ensureRuntime(ctx); err != nil {
+		return Info{}, err
+	}
+
+	scratchDir, err := os.MkdirTemp("", "vi-tg-ffprobe-scratch")
+	if err != nil {
+		return Info{}, fmt.Errorf("ошибка создания рабочего каталога ffprobe.wasm: %w", err)
+	}
+	defer os.RemoveAll(scratchDir)
+
+	var stdout bytes.Buffer
+	fsConfig := wazero.NewFSConfig().
+		WithDirMount(scratchDir, "/scratch").
+		WithDirMount(filepath.Dir(path), filepath.Dir(path))
+
+	config := wazero.NewModuleConfig().
+		WithArgs("ffprobe", "-v", "quiet", "-print_format", "json", "-show_streams", "-show_format", path).
+		WithStdout(&stdout).
+		WithFSConfig(fsConfig)
+
+	mod, err := sharedRuntime.InstantiateModule(ctx, compiled, config)
+	if err != nil {
+		return Info{}, fmt.Errorf("ошибка запуска ffprobe.wasm для %s: %w", path, err)
+	}
+	defer mod.Close(ctx)
+
+	var probe output
+	if err := json.Unmarshal(stdout.Bytes(), &probe); err != nil {
+		return Info{}, fmt.Errorf("ошибка разбора вывода ffprobe.wasm для %s: %w", path, err)
+	}
+
+	return parseOutput(probe), nil
+}
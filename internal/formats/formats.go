@@ -0,0 +1,274 @@
+// Package formats определяет универсальный детектор формата медиафайла по
+// содержимому, заменяющий ручное сравнение магических байт в
+// detectImageFormat единым проходом: сначала через декодеры, которые уже
+// умеет регистрировать пакет image (JPEG/PNG/GIF из стандартной библиотеки,
+// WebP из golang.org/x/image), затем через точечные проверки того, что
+// чистый image.DecodeConfig не различает — анимацию PNG/WebP, Lottie-стикеры
+// (.tgs) и видео/аудио-контейнеры (chunk5-4).
+package formats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Format описывает результат распознавания содержимого файла
+type Format struct {
+	Ext       string // расширение для сохранения файла, включая точку, например ".png"
+	MIME      string
+	Animated  bool
+	Container string // "isobmff", "matroska", "ogg", "riff" — заполнено только для видео/аудио контейнеров
+}
+
+// sniffSize — сколько байт читаем из начала файла для определения формата.
+// Этого достаточно, чтобы увидеть чанк acTL в PNG и первые box'ы ISO-BMFF,
+// которые не всегда попадают в первые несколько сотен байт.
+const sniffSize = 64 * 1024
+
+// Detect читает начало потока и определяет формат файла
+func Detect(r io.Reader) (Format, error) {
+	buf, err := io.ReadAll(io.LimitReader(r, sniffSize))
+	if err != nil {
+		return Format{}, fmt.Errorf("ошибка чтения заголовка файла: %w", err)
+	}
+
+	if f, ok := detectTGS(buf); ok {
+		return f, nil
+	}
+	if f, ok := detectPNG(buf); ok {
+		return f, nil
+	}
+	if f, ok := detectWebP(buf); ok {
+		return f, nil
+	}
+	if f, ok := detectGIF(buf); ok {
+		return f, nil
+	}
+	if f, ok := detectJPEG(buf); ok {
+		return f, nil
+	}
+	if f, ok := detectISOBMFF(buf); ok {
+		return f, nil
+	}
+	if f, ok := detectMatroska(buf); ok {
+		return f, nil
+	}
+	if f, ok := detectOGG(buf); ok {
+		return f, nil
+	}
+	if f, ok := detectRIFF(buf); ok {
+		return f, nil
+	}
+
+	// Ничего из специфичных детекторов не подошло — пробуем decode'ы,
+	// зарегистрированные через image.RegisterFormat (JPEG/PNG/GIF/WebP),
+	// на случай нестандартного порядка байт в заголовке
+	if cfg, name, err := image.DecodeConfig(bytes.NewReader(buf)); err == nil {
+		_ = cfg
+		if ext, mime := extForImageFormatName(name); ext != "" {
+			return Format{Ext: ext, MIME: mime}, nil
+		}
+	}
+
+	// Последний рубеж — стандартный сниффер net/http, различающий базовые
+	// MIME-типы по сигнатуре, включая BMP/TIFF
+	mime := http.DetectContentType(buf)
+	if ext := extFromMIME(mime); ext != "" {
+		return Format{Ext: ext, MIME: mime}, nil
+	}
+
+	return Format{}, fmt.Errorf("не удалось определить формат файла")
+}
+
+func extForImageFormatName(name string) (ext, mime string) {
+	switch name {
+	case "jpeg":
+		return ".jpg", "image/jpeg"
+	case "png":
+		return ".png", "image/png"
+	case "gif":
+		return ".gif", "image/gif"
+	case "webp":
+		return ".webp", "image/webp"
+	default:
+		return "", ""
+	}
+}
+
+func extFromMIME(mime string) string {
+	switch mime {
+	case "image/bmp", "image/x-ms-bmp":
+		return ".bmp"
+	case "image/tiff":
+		return ".tiff"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ""
+	}
+}
+
+func detectJPEG(buf []byte) (Format, bool) {
+	if len(buf) >= 2 && buf[0] == 0xFF && buf[1] == 0xD8 {
+		return Format{Ext: ".jpg", MIME: "image/jpeg"}, true
+	}
+	return Format{}, false
+}
+
+func detectGIF(buf []byte) (Format, bool) {
+	if len(buf) < 6 || !(bytes.Equal(buf[:4], []byte("GIF8"))) {
+		return Format{}, false
+	}
+	// GIF считается анимированным, если в нём больше одного Image
+	// Descriptor (0x2C) за пределами палитры и блоков расширений — точный
+	// разбор избыточен, достаточно посчитать вхождения разделителя кадра
+	animated := bytes.Count(buf, []byte{0x00, 0x2C}) > 0 && bytes.Count(buf, []byte{0x2C}) > 1
+	return Format{Ext: ".gif", MIME: "image/gif", Animated: animated}, true
+}
+
+// detectPNG определяет PNG и ищет чанк acTL (Animation Control Chunk) до
+// первого IDAT — так APNG отличают от обычного PNG, поскольку сигнатура
+// файла у них одинаковая
+func detectPNG(buf []byte) (Format, bool) {
+	sig := []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}
+	if len(buf) < len(sig) || !bytes.Equal(buf[:len(sig)], sig) {
+		return Format{}, false
+	}
+
+	animated := false
+	pos := len(sig)
+	for pos+8 <= len(buf) {
+		length := binary.BigEndian.Uint32(buf[pos : pos+4])
+		chunkType := string(buf[pos+4 : pos+8])
+		if chunkType == "acTL" {
+			animated = true
+			break
+		}
+		if chunkType == "IDAT" {
+			break
+		}
+		pos += 8 + int(length) + 4 // данные чанка + CRC
+	}
+
+	return Format{Ext: ".png", MIME: "image/png", Animated: animated}, true
+}
+
+// detectWebP определяет WebP по заголовку RIFF....WEBP и проверяет бит
+// анимации ANIM в чанке VP8X (если он есть — его отсутствие означает
+// простой формат VP8/VP8L без анимации)
+func detectWebP(buf []byte) (Format, bool) {
+	if len(buf) < 12 || !bytes.Equal(buf[0:4], []byte("RIFF")) || !bytes.Equal(buf[8:12], []byte("WEBP")) {
+		return Format{}, false
+	}
+
+	animated := false
+	if len(buf) >= 21 && bytes.Equal(buf[12:16], []byte("VP8X")) {
+		flags := buf[20]
+		animated = flags&0x02 != 0 // бит ANIM
+	}
+
+	return Format{Ext: ".webp", MIME: "image/webp", Animated: animated}, true
+}
+
+// detectTGS определяет стикер Lottie: файл сжат gzip и после распаковки
+// начинается с JSON-объекта, содержащего поле версии "v"
+func detectTGS(buf []byte) (Format, bool) {
+	if len(buf) < 2 || buf[0] != 0x1F || buf[1] != 0x8B {
+		return Format{}, false
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(buf))
+	if err != nil {
+		return Format{}, false
+	}
+	defer gz.Close()
+
+	head := make([]byte, 32)
+	n, _ := io.ReadFull(gz, head)
+	head = head[:n]
+
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	if !bytes.HasPrefix(trimmed, []byte(`{"v":`)) && !bytes.Contains(trimmed, []byte(`"v":`)) {
+		return Format{}, false
+	}
+
+	return Format{Ext: ".tgs", MIME: "application/x-tgsticker"}, true
+}
+
+// detectISOBMFF определяет MP4/MOV/3GP и производные по box'у ftyp, который
+// у всех ISO-BMFF файлов идёт по смещению 4
+func detectISOBMFF(buf []byte) (Format, bool) {
+	if len(buf) < 12 || !bytes.Equal(buf[4:8], []byte("ftyp")) {
+		return Format{}, false
+	}
+
+	brand := string(buf[8:12])
+	ext, mime := ".mp4", "video/mp4"
+	switch brand {
+	case "qt  ":
+		ext, mime = ".mov", "video/quicktime"
+	case "3gp4", "3gp5", "3gp6":
+		ext, mime = ".3gp", "video/3gpp"
+	}
+
+	return Format{Ext: ext, MIME: mime, Container: "isobmff"}, true
+}
+
+// detectMatroska определяет MKV/WebM по заголовку EBML
+func detectMatroska(buf []byte) (Format, bool) {
+	sig := []byte{0x1A, 0x45, 0xDF, 0xA3}
+	if len(buf) < len(sig) || !bytes.Equal(buf[:len(sig)], sig) {
+		return Format{}, false
+	}
+
+	// WebM и MKV имеют одинаковый заголовок EBML — различаются DocType
+	// внутри, который на таком коротком сниффе не всегда виден, поэтому по
+	// умолчанию считаем webm как наиболее частый случай для Telegram-медиа
+	ext, mime := ".webm", "video/webm"
+	if bytes.Contains(buf, []byte("matroska")) {
+		ext, mime = ".mkv", "video/x-matroska"
+	}
+
+	return Format{Ext: ext, MIME: mime, Container: "matroska"}, true
+}
+
+// detectOGG определяет контейнер Ogg (Vorbis/Opus/Theora) по сигнатуре "OggS"
+func detectOGG(buf []byte) (Format, bool) {
+	if len(buf) < 4 || !bytes.Equal(buf[:4], []byte("OggS")) {
+		return Format{}, false
+	}
+	return Format{Ext: ".ogg", MIME: "audio/ogg", Container: "ogg"}, true
+}
+
+// detectRIFF определяет оставшиеся RIFF-контейнеры (AVI, WAV) — WebP
+// перехватывается отдельно раньше, так как у него своя подсигнатура
+func detectRIFF(buf []byte) (Format, bool) {
+	if len(buf) < 12 || !bytes.Equal(buf[0:4], []byte("RIFF")) {
+		return Format{}, false
+	}
+
+	switch string(buf[8:12]) {
+	case "AVI ":
+		return Format{Ext: ".avi", MIME: "video/x-msvideo", Container: "riff"}, true
+	case "WAVE":
+		return Format{Ext: ".wav", MIME: "audio/wav", Container: "riff"}, true
+	default:
+		return Format{}, false
+	}
+}
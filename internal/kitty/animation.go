@@ -0,0 +1,196 @@
+package kitty
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Frame — один декодированный кадр анимации: сырые пиксели RGBA нужного
+// размера и длительность его показа перед следующим кадром
+type Frame struct {
+	RGBA    []byte
+	Width   int
+	Height  int
+	DelayMS int
+}
+
+// defaultFrameDelayMS — значение по умолчанию, если ffprobe не смог отдать
+// длительность кадра (контейнер без таймингов, либо ffprobe недоступен)
+const defaultFrameDelayMS = 40
+
+// maxAnimationFrames — верхняя граница числа декодируемых кадров, чтобы один
+// стикер не раздул память процесса
+const maxAnimationFrames = 60
+
+// frameCache кеширует уже декодированные кадры по ключу path+SHA-256(файла),
+// чтобы повторная отрисовка того же стикера (скролл, повторный рендер панели)
+// не перезапускала ffmpeg/ffprobe заново
+var frameCache = struct {
+	mu    sync.Mutex
+	byKey map[string][]Frame
+}{byKey: make(map[string][]Frame)}
+
+// frameCacheKey хэширует содержимое файла вместе с путём и запрошенным
+// размером, чтобы смена разрешения не возвращала кадры неверного размера
+func frameCacheKey(path string, width, height int) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s:%dx%d:%s", path, width, height, hex.EncodeToString(sum[:])), nil
+}
+
+// DecodeWebMFrames запускает ffmpeg, чтобы декодировать видеодорожку .webm
+// в последовательность кадров RGBA заданного размера — используется для
+// показа анимированных стикеров через Kitty animation frames API. Кадры
+// кешируются по пути+хешу файла, а задержки между ними берутся из ffprobe
+// (см. probeFrameDelaysMS), а не фиксированы
+func DecodeWebMFrames(ctx context.Context, webmPath string, width, height, maxFrames int) ([]Frame, error) {
+	if maxFrames <= 0 || maxFrames > maxAnimationFrames {
+		maxFrames = maxAnimationFrames
+	}
+
+	key, err := frameCacheKey(webmPath, width, height)
+	if err == nil {
+		frameCache.mu.Lock()
+		cached, ok := frameCache.byKey[key]
+		frameCache.mu.Unlock()
+		if ok {
+			if len(cached) > maxFrames {
+				cached = cached[:maxFrames]
+			}
+			return cached, nil
+		}
+	}
+
+	if _, lookErr := exec.LookPath("ffmpeg"); lookErr != nil {
+		return nil, fmt.Errorf("ffmpeg не найден: %w", lookErr)
+	}
+
+	cmdCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(cmdCtx, "ffmpeg",
+		"-i", webmPath,
+		"-vf", fmt.Sprintf("scale=%d:%d", width, height),
+		"-pix_fmt", "rgba",
+		"-f", "rawvideo",
+		"-frames:v", fmt.Sprintf("%d", maxFrames),
+		"pipe:1",
+	)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ошибка декодирования webm через ffmpeg: %w", err)
+	}
+
+	frameSize := width * height * 4
+	data := out.Bytes()
+	frameCount := len(data) / frameSize
+	if frameCount == 0 {
+		return nil, fmt.Errorf("ffmpeg не вернул ни одного кадра для %s", webmPath)
+	}
+	if frameCount > maxFrames {
+		frameCount = maxFrames
+	}
+
+	delays := probeFrameDelaysMS(ctx, webmPath, frameCount)
+
+	frames := make([]Frame, frameCount)
+	for i := 0; i < frameCount; i++ {
+		frames[i] = Frame{
+			RGBA:    data[i*frameSize : (i+1)*frameSize],
+			Width:   width,
+			Height:  height,
+			DelayMS: delays[i],
+		}
+	}
+
+	if key != "" {
+		frameCache.mu.Lock()
+		frameCache.byKey[key] = frames
+		frameCache.mu.Unlock()
+	}
+
+	return frames, nil
+}
+
+// probeFrameDelaysMS запрашивает у ffprobe длительность каждого кадра
+// видеодорожки (pkt_duration_time) и переводит её в миллисекунды; кадры без
+// валидной длительности и сам ffprobe недоступен — получают defaultFrameDelayMS
+func probeFrameDelaysMS(ctx context.Context, path string, frameCount int) []int {
+	delays := make([]int, frameCount)
+	for i := range delays {
+		delays[i] = defaultFrameDelayMS
+	}
+
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return delays
+	}
+
+	cmdCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(cmdCtx, "ffprobe",
+		"-v", "quiet",
+		"-select_streams", "v:0",
+		"-show_entries", "frame=pkt_duration_time",
+		"-of", "csv=p=0",
+		path,
+	)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return delays
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	for i := 0; i < frameCount && i < len(lines); i++ {
+		seconds, err := strconv.ParseFloat(strings.TrimSpace(lines[i]), 64)
+		if err != nil || seconds <= 0 {
+			continue
+		}
+		delays[i] = int(seconds * 1000)
+	}
+
+	return delays
+}
+
+// TransmitAnimation передаёт первый кадр как обычное изображение (a=T,
+// f=32 — сырой RGBA), затем каждый следующий кадр через Kitty animation
+// frames API (a=f,r=<номер кадра>,z=<задержка>) и наконец запускает
+// воспроизведение (a=a,s=1)
+func TransmitAnimation(frames []Frame) (sequence string, placementID uint32) {
+	if len(frames) == 0 {
+		return "", 0
+	}
+
+	placementID = newPlacementID()
+	var b strings.Builder
+
+	first := frames[0]
+	firstCtrl := fmt.Sprintf("a=T,f=32,i=%d,s=%d,v=%d", placementID, first.Width, first.Height)
+	writeChunked(&b, bufio.NewReader(bytes.NewReader(first.RGBA)), firstCtrl)
+
+	for idx, fr := range frames[1:] {
+		frameCtrl := fmt.Sprintf("a=f,i=%d,f=32,s=%d,v=%d,r=%d,z=%d", placementID, fr.Width, fr.Height, idx+2, fr.DelayMS)
+		writeChunked(&b, bufio.NewReader(bytes.NewReader(fr.RGBA)), frameCtrl)
+	}
+
+	fmt.Fprintf(&b, "\x1b_Ga=a,i=%d,s=1\x1b\\", placementID)
+
+	return b.String(), placementID
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package kitty
+
+import "golang.org/x/sys/unix"
+
+// CellSize возвращает размер одной ячейки терминала в пикселях (ширина,
+// высота) на переданном файловом дескрипторе (обычно os.Stdout.Fd()) через
+// ioctl TIOCGWINSZ. Возвращает (0, 0), если терминал не сообщает пиксельные
+// размеры (например, это не TTY)
+func CellSize(fd int) (cellWidth, cellHeight int) {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil || ws.Xpixel == 0 || ws.Ypixel == 0 || ws.Col == 0 || ws.Row == 0 {
+		return 0, 0
+	}
+	return int(ws.Xpixel) / int(ws.Col), int(ws.Ypixel) / int(ws.Row)
+}
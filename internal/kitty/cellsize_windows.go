@@ -0,0 +1,10 @@
+//go:build windows
+
+package kitty
+
+// CellSize на Windows не реализован (Kitty graphics protocol там не
+// актуален вне WSL) — возвращает (0, 0), как и при недоступности пиксельных
+// размеров на unix
+func CellSize(fd int) (cellWidth, cellHeight int) {
+	return 0, 0
+}
@@ -0,0 +1,49 @@
+package kitty
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// UnicodeBlockFallback рендерит img текстом: верхний полублок "▀" с цветом
+// переднего плана/фона даёт две строки пикселей на одну строку текста —
+// грубое, но переносимое приближение картинки для терминалов без поддержки
+// Kitty graphics protocol (и без sixel)
+func UnicodeBlockFallback(img image.Image, targetWidth int) string {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW == 0 || srcH == 0 || targetWidth <= 0 {
+		return ""
+	}
+
+	targetHeight := targetWidth * srcH / srcW / 2
+	if targetHeight < 1 {
+		targetHeight = 1
+	}
+
+	var b strings.Builder
+	for row := 0; row < targetHeight; row++ {
+		topY := bounds.Min.Y + (row*2)*srcH/(targetHeight*2)
+		botY := bounds.Min.Y + (row*2+1)*srcH/(targetHeight*2)
+		if botY >= bounds.Max.Y {
+			botY = bounds.Max.Y - 1
+		}
+
+		for col := 0; col < targetWidth; col++ {
+			x := bounds.Min.X + col*srcW/targetWidth
+
+			tr, tg, tb, _ := img.At(x, topY).RGBA()
+			br, bg, bb, _ := img.At(x, botY).RGBA()
+
+			fmt.Fprintf(&b, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀",
+				tr>>8, tg>>8, tb>>8, br>>8, bg>>8, bb>>8)
+		}
+		b.WriteString("\x1b[0m")
+		if row < targetHeight-1 {
+			b.WriteByte('\n')
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package kitty
+
+// RowsForHeight вычисляет, сколько текстовых строк высотой cellHeight
+// пикселей потребуется, чтобы вместить изображение высотой pixelHeight
+// пикселей. Используется, чтобы зарезервировать в выводе, свёрстанном
+// построчно через lipgloss, ровно столько пустых строк, сколько реально
+// займёт картинка — иначе следующий текст наложится на неё
+func RowsForHeight(pixelHeight, cellHeight int) int {
+	if cellHeight <= 0 {
+		return 1
+	}
+	rows := pixelHeight / cellHeight
+	if pixelHeight%cellHeight != 0 {
+		rows++
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	return rows
+}
+
+// ColumnsForWidth — аналог RowsForHeight для ширины в текстовых ячейках
+func ColumnsForWidth(pixelWidth, cellWidth int) int {
+	if cellWidth <= 0 {
+		return 1
+	}
+	cols := pixelWidth / cellWidth
+	if pixelWidth%cellWidth != 0 {
+		cols++
+	}
+	if cols < 1 {
+		cols = 1
+	}
+	return cols
+}
@@ -0,0 +1,160 @@
+// Package kitty реализует протокол графики терминала Kitty: передачу и
+// отображение изображений через APC escape-последовательности (чанками по
+// 4096 байт), учёт ID размещений для стирания картинок при прокрутке и
+// перерисовке, декодирование анимированных стикеров (.webm, .tgs) в кадры и
+// текстовый фоллбэк для терминалов без поддержки протокола
+package kitty
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// chunkSize — максимальный размер одного base64-чанка данных в escape-
+// последовательности передачи, как того требует протокол Kitty
+const chunkSize = 4096
+
+// rawChunkSize — число исходных байт на один base64-чанк, подобранное так,
+// чтобы кодирование давало ровно chunkSize символов без паддинга в середине
+// потока (3 байта -> 4 base64-символа)
+const rawChunkSize = chunkSize / 4 * 3
+
+// base64BufPool переиспользует буферы кодирования между вызовами
+// writeChunked, чтобы передача множества стикеров подряд (скролл истории)
+// не выделяла новый []byte на каждый чанк
+var base64BufPool = sync.Pool{
+	New: func() interface{} { return make([]byte, 0, chunkSize) },
+}
+
+// placementCounter — монотонный счётчик ID размещений картинок, общий для
+// всего процесса
+var placementCounter uint32
+
+func newPlacementID() uint32 {
+	return atomic.AddUint32(&placementCounter, 1)
+}
+
+// Options описывает необязательные параметры размещения изображения
+type Options struct {
+	// Columns/Rows — размер изображения в текстовых ячейках. Нулевое
+	// значение отдаёт решение терминалу (он подберёт размер по пикселям)
+	Columns int
+	Rows    int
+}
+
+// Transmit кодирует PNG-данные в escape-последовательность передачи и
+// отображения Kitty (a=T, f=100 — формат PNG, q=2 — терминал не шлёт
+// подтверждений), разбивая их на чанки по chunkSize байт, и возвращает
+// готовую последовательность вместе с назначенным ID размещения, который
+// нужно сохранить для последующего Erase
+func Transmit(pngData []byte, opts Options) (sequence string, placementID uint32) {
+	placementID = newPlacementID()
+
+	var b strings.Builder
+	// writeChunked читает из bytes.Reader, который никогда не возвращает
+	// ошибку чтения — сам дизайн гарантирует, что err тут всегда nil
+	_ = writeChunked(&b, bufio.NewReader(bytes.NewReader(pngData)), transmitControl(placementID, opts))
+
+	return b.String(), placementID
+}
+
+// TransmitFile аналогичен Transmit, но читает PNG-данные прямо с диска через
+// bufio.Reader вместо того, чтобы требовать их уже целиком в памяти —
+// base64-кодирование идёт по rawChunkSize байт за раз в пул base64BufPool, так
+// что пиковая память передачи остаётся O(chunkSize) независимо от размера
+// файла. Это снимает практическую причину ограничивать размер стикеров перед
+// встроенным показом (chunk3-5)
+func TransmitFile(path string, opts Options) (sequence string, placementID uint32, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("kitty: ошибка открытия файла %s: %w", path, err)
+	}
+	defer f.Close()
+
+	placementID = newPlacementID()
+
+	var b strings.Builder
+	if err := writeChunked(&b, bufio.NewReader(f), transmitControl(placementID, opts)); err != nil {
+		return "", 0, fmt.Errorf("kitty: ошибка чтения файла %s: %w", path, err)
+	}
+
+	return b.String(), placementID, nil
+}
+
+// transmitControl собирает control-данные первого чанка передачи: a=T,
+// f=100 — формат PNG, q=2 — терминал не отвечает на передачу (respond never),
+// плюс необязательные размеры в текстовых ячейках
+func transmitControl(placementID uint32, opts Options) string {
+	ctrl := fmt.Sprintf("a=T,f=100,q=2,i=%d", placementID)
+	if opts.Columns > 0 {
+		ctrl += fmt.Sprintf(",c=%d", opts.Columns)
+	}
+	if opts.Rows > 0 {
+		ctrl += fmt.Sprintf(",r=%d", opts.Rows)
+	}
+	return ctrl
+}
+
+// Erase возвращает escape-последовательность, удаляющую с экрана картинку,
+// ранее размещённую под указанным ID (a=d,i=<id>)
+func Erase(placementID uint32) string {
+	return fmt.Sprintf("\x1b_Ga=d,i=%d\x1b\\", placementID)
+}
+
+// writeChunked base64-кодирует данные, читаемые из r, и записывает их в b в
+// виде одной или нескольких escape-последовательностей Kitty по протоколу
+// чанкования: первый чанк несёт firstControl, последующие — только
+// m=<0|1>, последний чанк всегда заканчивается m=0. Кодирует по rawChunkSize
+// исходных байт за раз через пул буферов base64BufPool и r.Peek, чтобы узнать,
+// что текущий чанк последний, не читая вперёд лишнего — так пиковая память
+// не зависит от объёма исходных данных
+func writeChunked(b *strings.Builder, r *bufio.Reader, firstControl string) error {
+	encodeBuf := base64BufPool.Get().([]byte)
+	defer base64BufPool.Put(encodeBuf[:0])
+
+	raw := make([]byte, rawChunkSize)
+	for {
+		n, err := io.ReadFull(r, raw)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		chunk := raw[:n]
+
+		_, peekErr := r.Peek(1)
+		last := peekErr != nil
+
+		need := base64.StdEncoding.EncodedLen(len(chunk))
+		if cap(encodeBuf) < need {
+			encodeBuf = make([]byte, need)
+		}
+		encodeBuf = encodeBuf[:need]
+		base64.StdEncoding.Encode(encodeBuf, chunk)
+
+		more := 1
+		if last {
+			more = 0
+		}
+
+		if firstControl != "" {
+			fmt.Fprintf(b, "\x1b_G%s,m=%d;%s\x1b\\", firstControl, more, encodeBuf)
+			firstControl = ""
+		} else {
+			fmt.Fprintf(b, "\x1b_Gm=%d;%s\x1b\\", more, encodeBuf)
+		}
+
+		if last {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package kitty
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// DecodeTGSFrames распаковывает .tgs (gzip Lottie JSON) и растеризует его в
+// последовательность PNG-кадров, вызывая первый найденный в PATH внешний
+// рендерер Lottie (tgs-to-webp или rlottie-render) — ни тот ни другой не
+// встроен в vi-tg, это лишь самый распространённый набор CLI-инструментов
+// для рендера анимаций Telegram-стикеров
+func DecodeTGSFrames(ctx context.Context, tgsPath string, width, height int) ([][]byte, error) {
+	tool, args, err := lottieRendererCommand(tgsPath, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "vi-tg-tgs-*")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания временной директории: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	args = append(args, tmpDir+"/frame_%04d.png")
+
+	cmdCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(cmdCtx, tool, args...)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ошибка растеризации tgs через %s: %w", tool, err)
+	}
+
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil || len(entries) == 0 {
+		return nil, fmt.Errorf("%s не создал ни одного кадра для %s", tool, tgsPath)
+	}
+
+	frames := make([][]byte, 0, len(entries))
+	for _, entry := range entries {
+		data, err := os.ReadFile(tmpDir + "/" + entry.Name())
+		if err != nil {
+			continue
+		}
+		frames = append(frames, data)
+	}
+
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("не удалось прочитать кадры, созданные %s", tool)
+	}
+
+	return frames, nil
+}
+
+// lottieRendererCommand находит доступный в PATH инструмент для растеризации
+// Lottie-анимаций и собирает базовые аргументы для него (без выходного
+// шаблона файлов — его добавляет вызывающая функция)
+func lottieRendererCommand(tgsPath string, width, height int) (tool string, args []string, err error) {
+	if _, lookErr := exec.LookPath("tgs-to-webp"); lookErr == nil {
+		return "tgs-to-webp", []string{"-i", tgsPath, "-o"}, nil
+	}
+	if _, lookErr := exec.LookPath("rlottie-render"); lookErr == nil {
+		return "rlottie-render", []string{tgsPath, fmt.Sprintf("%dx%d", width, height), "-o"}, nil
+	}
+	return "", nil, fmt.Errorf("не найден ни один Lottie-рендерер в PATH (tgs-to-webp, rlottie-render)")
+}
+
+// UngzipTGS распаковывает содержимое .tgs в сырой Lottie JSON — пригождается
+// рендереру, принимающему JSON на stdin вместо пути к файлу на диске
+func UngzipTGS(tgsPath string) ([]byte, error) {
+	f, err := os.Open(tgsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения gzip: %w", err)
+	}
+	defer gz.Close()
+
+	return io.ReadAll(gz)
+}
@@ -0,0 +1,55 @@
+package kitty
+
+import "sync"
+
+// Tracker отслеживает активные размещения картинок по произвольному ключу
+// вызывающего кода (например, путь к файлу стикера), чтобы при повторной
+// отрисовке можно было стереть прежнюю картинку вместо её бесконтрольного
+// накопления на экране
+type Tracker struct {
+	mu         sync.Mutex
+	placements map[string]uint32
+}
+
+// NewTracker создаёт пустой Tracker
+func NewTracker() *Tracker {
+	return &Tracker{placements: make(map[string]uint32)}
+}
+
+// Track запоминает placementID под ключом key и возвращает
+// escape-последовательность стирания предыдущего размещения под тем же
+// ключом (пустую строку, если стирать нечего)
+func (t *Tracker) Track(key string, placementID uint32) (eraseOld string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if old, ok := t.placements[key]; ok && old != placementID {
+		eraseOld = Erase(old)
+	}
+	t.placements[key] = placementID
+	return eraseOld
+}
+
+// EraseAll возвращает escape-последовательности стирания всех
+// отслеживаемых размещений и забывает о них — используется перед полной
+// перерисовкой экрана, когда прежние позиции картинок больше не гарантированы
+func (t *Tracker) EraseAll() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sequences := make([]string, 0, len(t.placements))
+	for _, id := range t.placements {
+		sequences = append(sequences, Erase(id))
+	}
+	t.placements = make(map[string]uint32)
+	return sequences
+}
+
+// Forget удаляет ключ без стирания картинки на экране — например, когда
+// сообщение прокручено за пределы экрана и его место уже переиспользовано
+// обычным текстом
+func (t *Tracker) Forget(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.placements, key)
+}
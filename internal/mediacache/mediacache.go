@@ -0,0 +1,250 @@
+// Package mediacache хранит скачанные медиафайлы под
+// $XDG_CACHE_HOME/vi-tg/media вместо /tmp/vi-tg_<kind>_<id> — файлы именуются
+// по sha256 от пары (тип, document/photo ID, access hash), а не по messageID,
+// поэтому одно и то же фото/стикер/видео, встреченное в разных чатах или после
+// перезапуска клиента, скачивается один раз. Размер кеша ограничен MaxBytes:
+// при превышении лимита наименее недавно использованные файлы вытесняются
+// (chunk5-3).
+package mediacache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBytes — лимит кеша по умолчанию, если вызывающий код не задаёт свой
+const DefaultMaxBytes int64 = 512 * 1024 * 1024
+
+// entry — запись индекса кеша для одного файла
+type entry struct {
+	Ext        string    `json:"ext"`
+	Size       int64     `json:"size"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+// indexData — формат index.json, хранящего метаданные кеша между запусками
+type indexData struct {
+	Entries map[string]entry `json:"entries"`
+}
+
+// Cache — потокобезопасный content-addressed кеш медиафайлов на диске с
+// вытеснением по LRU при превышении MaxBytes
+type Cache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	entries  map[string]entry
+	total    int64
+}
+
+// New создаёт кеш с каталогом по умолчанию ($XDG_CACHE_HOME/vi-tg/media) и
+// заданным лимитом суммарного размера, подгружая существующий индекс с диска
+func New(maxBytes int64) *Cache {
+	c := &Cache{dir: cacheDir(), maxBytes: maxBytes, entries: map[string]entry{}}
+	c.load()
+	return c
+}
+
+// cacheDir вычисляет каталог кеша с учётом XDG_CACHE_HOME, как это принято
+// для кешей пользовательских приложений в XDG Base Directory Specification
+func cacheDir() string {
+	if xdg := os.Getenv("XDG_CACHE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "vi-tg", "media")
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	return filepath.Join(homeDir, ".cache", "vi-tg", "media")
+}
+
+// Key строит ключ кеша из типа медиа и пары (id, access_hash) — эта пара
+// остаётся стабильной между перезапусками и не зависит от messageID, который
+// привязан к конкретному чату и сообщению
+func Key(kind string, id, accessHash int64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d", kind, id, accessHash)))
+	return hex.EncodeToString(sum[:])
+}
+
+// pathFor строит путь к файлу по ключу и расширению, раскладывая файлы по
+// подкаталогам из первых двух символов ключа, чтобы избежать одного
+// гигантского плоского каталога
+func (c *Cache) pathFor(key, ext string) string {
+	return filepath.Join(c.dir, key[:2], key+ext)
+}
+
+// TempPath возвращает путь для промежуточной записи скачиваемого файла,
+// ещё до того как известно его итоговое расширение (создаёт директорию)
+func (c *Cache) TempPath(key string) (string, error) {
+	path := filepath.Join(c.dir, key[:2], key+".tmp")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("ошибка создания директории кеша: %w", err)
+	}
+	return path, nil
+}
+
+// Lookup возвращает путь к уже закэшированному файлу и обновляет время
+// последнего обращения (для LRU). Если индекс ссылается на файл, которого
+// больше нет на диске, запись удаляется и возвращается false.
+func (c *Cache) Lookup(key string) (string, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+
+	path := c.pathFor(key, e.Ext)
+	if _, err := os.Stat(path); err != nil {
+		c.mu.Lock()
+		delete(c.entries, key)
+		c.total -= e.Size
+		c.mu.Unlock()
+		c.save()
+		return "", false
+	}
+
+	c.mu.Lock()
+	e.LastAccess = time.Now()
+	c.entries[key] = e
+	c.mu.Unlock()
+	c.save()
+
+	return path, true
+}
+
+// Finalize переносит файл, скачанный по TempPath, в его постоянное место в
+// кеше, регистрирует запись в индексе и запускает вытеснение при
+// превышении MaxBytes
+func (c *Cache) Finalize(key, ext, tempPath string) (string, error) {
+	finalPath := c.pathFor(key, ext)
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		return "", fmt.Errorf("ошибка создания директории кеша: %w", err)
+	}
+	if err := os.Rename(tempPath, finalPath); err != nil {
+		return "", fmt.Errorf("ошибка переноса файла в кеш: %w", err)
+	}
+
+	info, err := os.Stat(finalPath)
+	if err != nil {
+		return "", fmt.Errorf("ошибка чтения закэшированного файла: %w", err)
+	}
+
+	c.mu.Lock()
+	if old, ok := c.entries[key]; ok {
+		c.total -= old.Size
+	}
+	c.entries[key] = entry{Ext: ext, Size: info.Size(), LastAccess: time.Now()}
+	c.total += info.Size()
+	c.mu.Unlock()
+
+	c.evict()
+	c.save()
+
+	return finalPath, nil
+}
+
+// evict удаляет наименее недавно использованные файлы, пока суммарный размер
+// кеша не уложится в MaxBytes
+func (c *Cache) evict() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.total <= c.maxBytes {
+		return
+	}
+
+	keys := make([]string, 0, len(c.entries))
+	for k := range c.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].LastAccess.Before(c.entries[keys[j]].LastAccess)
+	})
+
+	for _, k := range keys {
+		if c.total <= c.maxBytes {
+			break
+		}
+		e := c.entries[k]
+		os.Remove(c.pathFor(k, e.Ext))
+		c.total -= e.Size
+		delete(c.entries, k)
+	}
+}
+
+// Stats возвращает количество закэшированных файлов, их суммарный размер и
+// текущий лимит — используется командой :cachestats в TUI
+func (c *Cache) Stats() (count int, totalBytes int64, maxBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.total, c.maxBytes
+}
+
+// Clear удаляет все файлы кеша с диска и очищает индекс — используется
+// командой :cacheclear в TUI
+func (c *Cache) Clear() error {
+	c.mu.Lock()
+	entries := c.entries
+	c.entries = map[string]entry{}
+	c.total = 0
+	c.mu.Unlock()
+
+	for k, e := range entries {
+		os.Remove(c.pathFor(k, e.Ext))
+	}
+
+	return c.save()
+}
+
+func (c *Cache) indexPath() string {
+	return filepath.Join(c.dir, "index.json")
+}
+
+// load читает индекс с диска при создании кеша; отсутствие файла не ошибка —
+// кеш просто начинается пустым
+func (c *Cache) load() {
+	data, err := os.ReadFile(c.indexPath())
+	if err != nil {
+		return
+	}
+
+	var idx indexData
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return
+	}
+
+	c.entries = idx.Entries
+	if c.entries == nil {
+		c.entries = map[string]entry{}
+	}
+	for _, e := range c.entries {
+		c.total += e.Size
+	}
+}
+
+// save пишет снимок индекса на диск
+func (c *Cache) save() error {
+	c.mu.Lock()
+	snapshot := make(map[string]entry, len(c.entries))
+	for k, v := range c.entries {
+		snapshot[k] = v
+	}
+	c.mu.Unlock()
+
+	data, err := json.MarshalIndent(indexData{Entries: snapshot}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.indexPath(), data, 0644)
+}
@@ -0,0 +1,67 @@
+// Package msgtemplate разбирает и выполняет шаблоны сообщений на базе
+// text/template — общий код для config (валидация при загрузке) и telegram
+// (рендеринг при отправке), вынесенный в отдельный пакет именно потому, что
+// telegram уже импортирует config, и наоборот было бы циклом импортов
+// (chunk6-3).
+package msgtemplate
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// markdownV2SpecialChars — символы, которые Telegram Bot API требует
+// экранировать в разметке MarkdownV2
+// (https://core.telegram.org/bots/api#markdownv2-style)
+const markdownV2SpecialChars = "_*[]()~`>#+-=|{}.!"
+
+// FuncMap возвращает функции, доступные шаблонам сообщений: экранирование
+// MarkdownV2 и форматирование времени
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"escapeMarkdownV2": EscapeMarkdownV2,
+		"formatTime":       formatTime,
+	}
+}
+
+// EscapeMarkdownV2 экранирует спецсимволы MarkdownV2 обратным слэшем
+func EscapeMarkdownV2(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if strings.ContainsRune(markdownV2SpecialChars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func formatTime(t time.Time) string {
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// Parse разбирает шаблон text под именем name, подключая FuncMap
+func Parse(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(FuncMap()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка разбора шаблона %q: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// Render разбирает и выполняет шаблон text под именем name с данными data
+func Render(name, text string, data interface{}) (string, error) {
+	tmpl, err := Parse(name, text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("ошибка выполнения шаблона %q: %w", name, err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,167 @@
+package secrets
+
+// Резервное хранилище секретов для машин без системного кейринга: один файл
+// ~/.vi-tg/secrets.enc, каждая запись зашифрована AES-256-GCM ключом,
+// выведенным из пользовательской парольной фразы через scrypt. Парольная
+// фраза берётся из VITG_SECRETS_PASSPHRASE либо запрашивается интерактивно —
+// тем же способом, каким auth.ConsoleAuth.Password читает пароль
+// двухфакторной аутентификации.
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// scryptN/scryptR/scryptP — параметры stretching парольной фразы, значения
+// по умолчанию из оригинальной статьи scrypt для интерактивных логинов
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	keyLen  = 32
+)
+
+type encryptedFile struct {
+	Salt    []byte            `json:"salt"`
+	Entries map[string][]byte `json:"entries"`
+}
+
+func encryptedStorePath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	return filepath.Join(homeDir, ".vi-tg", "secrets.enc")
+}
+
+func readEncryptedFile() (*encryptedFile, error) {
+	data, err := os.ReadFile(encryptedStorePath())
+	if os.IsNotExist(err) {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("ошибка генерации соли хранилища секретов: %w", err)
+		}
+		return &encryptedFile{Salt: salt, Entries: make(map[string][]byte)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения хранилища секретов: %w", err)
+	}
+
+	var f encryptedFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("ошибка разбора хранилища секретов: %w", err)
+	}
+	return &f, nil
+}
+
+func writeEncryptedFile(f *encryptedFile) error {
+	path := encryptedStorePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("ошибка создания директории хранилища секретов: %w", err)
+	}
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации хранилища секретов: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("ошибка записи хранилища секретов: %w", err)
+	}
+	return nil
+}
+
+// derivePassphraseKey выводит ключ AES-256 из парольной фразы пользователя и
+// соли salt, привязанной к конкретному файлу secrets.enc
+func derivePassphraseKey(salt []byte) ([]byte, error) {
+	passphrase := os.Getenv("VITG_SECRETS_PASSPHRASE")
+	if passphrase == "" {
+		fmt.Print("Введите парольную фразу для хранилища секретов: ")
+		pw, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения парольной фразы: %w", err)
+		}
+		passphrase = string(pw)
+	}
+
+	return scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keyLen)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка инициализации AES: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка инициализации AES-GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+func getEncrypted(key string) (string, error) {
+	f, err := readEncryptedFile()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, ok := f.Entries[key]
+	if !ok {
+		return "", nil
+	}
+
+	aesKey, err := derivePassphraseKey(f.Salt)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("повреждена запись %q в хранилище секретов", key)
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("ошибка расшифровки %q (неверная парольная фраза?): %w", key, err)
+	}
+	return string(plaintext), nil
+}
+
+func setEncrypted(key, value string) error {
+	f, err := readEncryptedFile()
+	if err != nil {
+		return err
+	}
+
+	aesKey, err := derivePassphraseKey(f.Salt)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("ошибка генерации nonce: %w", err)
+	}
+
+	if f.Entries == nil {
+		f.Entries = make(map[string][]byte)
+	}
+	f.Entries[key] = gcm.Seal(nonce, nonce, []byte(value), nil)
+
+	return writeEncryptedFile(f)
+}
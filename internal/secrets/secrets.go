@@ -0,0 +1,51 @@
+// Package secrets хранит чувствительные значения конфига (токен бота, номер
+// телефона) вне текстового config.yml. Основное хранилище — системный
+// кейринг (Secret Service/macOS Keychain/Windows Credential Manager через
+// github.com/zalando/go-keyring); на машинах без такого бэкенда (например,
+// headless Linux без D-Bus) используется резервное зашифрованное хранилище
+// (см. encrypted.go). config.LoadConfig и main.go (команда "login") — два
+// потребителя этого пакета (chunk6-5).
+package secrets
+
+import (
+	"fmt"
+
+	"github.com/zalando/go-keyring"
+)
+
+// service — имя сервиса, под которым vi-tg хранит секреты в кейринге
+const service = "vi-tg"
+
+// Get возвращает секрет key. Отсутствующий секрет — не ошибка, в этом случае
+// возвращается пустая строка, чтобы вызывающий код (LoadConfig) мог просто
+// оставить соответствующее поле пустым
+func Get(key string) (string, error) {
+	value, err := keyring.Get(service, key)
+	if err == nil {
+		return value, nil
+	}
+	if err != keyring.ErrNotFound {
+		// Кейринг недоступен на этой машине вовсе — пробуем файл-резерв
+		return getEncrypted(key)
+	}
+
+	// Кейринг доступен, но секрета в нём нет — на всякий случай проверяем и
+	// файл-резерв, вдруг секрет туда попал раньше, когда кейринга не было
+	value, err = getEncrypted(key)
+	if err != nil {
+		return "", nil
+	}
+	return value, nil
+}
+
+// Set сохраняет секрет key в системный кейринг, а если на этой машине нет
+// поддерживаемого бэкенда, сохраняет в зашифрованный файл-резерв
+func Set(key, value string) error {
+	if err := keyring.Set(service, key, value); err == nil {
+		return nil
+	}
+	if err := setEncrypted(key, value); err != nil {
+		return fmt.Errorf("ошибка сохранения секрета %q: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package staticmap
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"sync"
+)
+
+// Context — билдер для рендеринга статической карты: накапливает базовый
+// провайдер тайлов, оверлеи, центр/зум/размер, объекты геометрии (маркеры,
+// пути, области, окружности) и собирает итоговое изображение из тайлов,
+// покрывающих холст целиком, с отрисованной поверх геометрией
+type Context struct {
+	provider TileProvider
+	overlays []TileProvider
+	width    int
+	height   int
+	lat      float64
+	lng      float64
+	zoom     int
+
+	// centerSet/zoomSet — заданы ли центр/зум явно через SetCenter/SetZoom.
+	// Если ни то ни другое не задано, а объекты геометрии есть, Render
+	// подбирает центр и зум по их ограничивающему прямоугольнику
+	centerSet bool
+	zoomSet   bool
+
+	markers []Marker
+	paths   []Path
+	areas   []Area
+	circles []Circle
+}
+
+// NewContext создаёт Context с провайдером OpenStreetMap по умолчанию
+func NewContext() *Context {
+	return &Context{
+		provider: NewOSMProvider(),
+		width:    600,
+		height:   400,
+		zoom:     15,
+	}
+}
+
+// SetProvider задаёт базовый провайдер тайлов
+func (c *Context) SetProvider(p TileProvider) *Context {
+	c.provider = p
+	return c
+}
+
+// AddOverlay добавляет провайдер, рендерящийся поверх базовой карты
+// (например, пробки или тематический слой) — может вызываться несколько раз
+func (c *Context) AddOverlay(p TileProvider) *Context {
+	c.overlays = append(c.overlays, p)
+	return c
+}
+
+// SetSize задаёт размер итогового холста в пикселях
+func (c *Context) SetSize(width, height int) *Context {
+	c.width, c.height = width, height
+	return c
+}
+
+// SetCenter задаёт точку, которая должна оказаться в центре холста
+func (c *Context) SetCenter(lat, lng float64) *Context {
+	c.lat, c.lng = lat, lng
+	c.centerSet = true
+	return c
+}
+
+// SetZoom задаёт уровень масштабирования
+func (c *Context) SetZoom(zoom int) *Context {
+	c.zoom = zoom
+	c.zoomSet = true
+	return c
+}
+
+// AddMarker добавляет точечный маркер с опциональной подписью
+func (c *Context) AddMarker(m Marker) *Context {
+	c.markers = append(c.markers, m)
+	return c
+}
+
+// AddPath добавляет полилинию (маршрут, трек)
+func (c *Context) AddPath(p Path) *Context {
+	c.paths = append(c.paths, p)
+	return c
+}
+
+// AddArea добавляет закрашенный многоугольник
+func (c *Context) AddArea(a Area) *Context {
+	c.areas = append(c.areas, a)
+	return c
+}
+
+// AddCircle добавляет окружность заданного радиуса в метрах вокруг точки
+func (c *Context) AddCircle(ci Circle) *Context {
+	c.circles = append(c.circles, ci)
+	return c
+}
+
+// Render стягивает тайлы базового провайдера и всех оверлеев, покрывающие
+// заданный размер холста, рисует поверх них геометрию (маркеры/пути/области/
+// окружности) и возвращает готовое изображение. Если ни центр, ни зум не
+// заданы явно, а геометрия есть — центр и зум подбираются автоматически по
+// её ограничивающему прямоугольнику. Недоступный оверлей не валит рендер —
+// в этом случае в кадре просто не будет соответствующего слоя
+func (c *Context) Render() (*image.RGBA, error) {
+	if c.provider == nil {
+		return nil, fmt.Errorf("провайдер тайлов не задан")
+	}
+	if c.width <= 0 || c.height <= 0 {
+		return nil, fmt.Errorf("недопустимый размер карты: %dx%d", c.width, c.height)
+	}
+
+	if !c.centerSet && !c.zoomSet {
+		c.autoFit()
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, c.width, c.height))
+
+	if err := c.renderLayer(canvas, c.provider); err != nil {
+		return nil, fmt.Errorf("ошибка рендеринга базового слоя %s: %w", c.provider.Name(), err)
+	}
+	for _, overlay := range c.overlays {
+		_ = c.renderLayer(canvas, overlay)
+	}
+
+	c.drawGeometry(canvas)
+
+	return canvas, nil
+}
+
+// renderLayer стягивает тайлы одного провайдера, покрывающие холст, и
+// рисует их на canvas так, чтобы (lat, lng) оказалась в его центре
+func (c *Context) renderLayer(canvas *image.RGBA, provider TileProvider) error {
+	tileSize := provider.TileSize()
+	if tileSize <= 0 {
+		tileSize = DefaultTileSize
+	}
+
+	centerPxX, centerPxY := latLngToPixel(c.lat, c.lng, c.zoom, tileSize)
+	originX := centerPxX - float64(c.width)/2
+	originY := centerPxY - float64(c.height)/2
+
+	startTileX := int(math.Floor(originX / float64(tileSize)))
+	startTileY := int(math.Floor(originY / float64(tileSize)))
+	endTileX := int(math.Floor((originX + float64(c.width) - 1) / float64(tileSize)))
+	endTileY := int(math.Floor((originY + float64(c.height) - 1) / float64(tileSize)))
+
+	type fetchedTile struct {
+		x, y int
+		img  image.Image
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		tiles   []fetchedTile
+		fetched int
+	)
+
+	for tx := startTileX; tx <= endTileX; tx++ {
+		for ty := startTileY; ty <= endTileY; ty++ {
+			tx, ty := tx, ty
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				img, err := fetchTile(provider, tx, ty, c.zoom)
+				if err != nil {
+					return
+				}
+
+				mu.Lock()
+				tiles = append(tiles, fetchedTile{x: tx, y: ty, img: img})
+				fetched++
+				mu.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+
+	if fetched == 0 {
+		return fmt.Errorf("не удалось получить ни одного тайла")
+	}
+
+	for _, t := range tiles {
+		destX := t.x*tileSize - int(math.Round(originX))
+		destY := t.y*tileSize - int(math.Round(originY))
+		draw.Draw(canvas, image.Rect(destX, destY, destX+tileSize, destY+tileSize), t.img, image.Point{}, draw.Over)
+	}
+
+	return nil
+}
+
+// fetchTile скачивает (через defaultFetcher — с ретраями, лимитом скорости и
+// circuit breaker на провайдера) и декодирует один тайл, используя кеш тайлов
+// как первый источник данных, если он настроен
+func fetchTile(provider TileProvider, x, y, zoom int) (image.Image, error) {
+	cacheKey := TileCacheKey(provider.Name(), zoom, x, y)
+
+	var data []byte
+	if cache != nil {
+		if cached, ok := cache.Get(cacheKey); ok {
+			data = cached
+		}
+	}
+
+	if data == nil {
+		fetched, err := defaultFetcher.fetch(provider, x, y, zoom)
+		if err != nil {
+			return nil, err
+		}
+		data = fetched
+
+		if cache != nil {
+			cache.Set(cacheKey, data)
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка декодирования тайла: %w", err)
+	}
+
+	return img, nil
+}
@@ -0,0 +1,176 @@
+package staticmap
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fsEntry — запись LRU-списка FSTileCache: сам файл остаётся на диске,
+// в памяти хранится только то, что нужно для TTL и вытеснения по квоте
+type fsEntry struct {
+	key      string
+	path     string
+	size     int64
+	storedAt time.Time
+}
+
+// FSTileCache — персистентный кеш тайлов на диске, шардированный по первым
+// байтам content-addressed ключа (как sha256(provider|z|x|y) из TileCacheKey)
+// в двухсимвольные поддиректории, с TTL и квотой на общий размер (LRU-вытеснение)
+type FSTileCache struct {
+	baseDir string
+	ttl     time.Duration
+	maxSize int64
+
+	mu    sync.Mutex
+	index map[string]*list.Element
+	lru   *list.List
+	size   int64
+	hits   int64
+	misses int64
+}
+
+// NewFSTileCache создаёт дисковый кеш тайлов в baseDir. ttl <= 0 означает
+// отсутствие ограничения по времени жизни, maxSizeBytes <= 0 — без квоты
+func NewFSTileCache(baseDir string, ttl time.Duration, maxSizeBytes int64) *FSTileCache {
+	return &FSTileCache{
+		baseDir: baseDir,
+		ttl:     ttl,
+		maxSize: maxSizeBytes,
+		index:   make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// tilePath вычисляет путь файла тайла по ключу: baseDir/xx/yy/<key>.tile
+func (c *FSTileCache) tilePath(key string) string {
+	return filepath.Join(c.baseDir, key[0:2], key[2:4], key+".tile")
+}
+
+func (c *FSTileCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	if el, ok := c.index[key]; ok {
+		entry := el.Value.(*fsEntry)
+		if c.ttl > 0 && time.Since(entry.storedAt) > c.ttl {
+			c.removeLocked(el)
+			c.misses++
+			c.mu.Unlock()
+			os.Remove(entry.path)
+			return nil, false
+		}
+		c.lru.MoveToFront(el)
+		path := entry.path
+		c.hits++
+		c.mu.Unlock()
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, false
+		}
+		return data, true
+	}
+	c.mu.Unlock()
+
+	// Индекс в памяти пуст после перезапуска процесса, но путь детерминирован
+	// по ключу — проверяем диск и восстанавливаем запись индекса при попадании
+	path := c.tilePath(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		c.mu.Lock()
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+	if c.ttl > 0 && time.Since(info.ModTime()) > c.ttl {
+		os.Remove(path)
+		c.mu.Lock()
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.mu.Lock()
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	c.mu.Lock()
+	c.insertLocked(key, path, int64(len(data)), info.ModTime())
+	c.hits++
+	c.mu.Unlock()
+
+	return data, true
+}
+
+func (c *FSTileCache) Set(key string, data []byte) {
+	path := c.tilePath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.insertLocked(key, path, int64(len(data)), time.Now())
+	c.evictIfNeededLocked()
+	c.mu.Unlock()
+}
+
+func (c *FSTileCache) insertLocked(key, path string, size int64, storedAt time.Time) {
+	if el, ok := c.index[key]; ok {
+		entry := el.Value.(*fsEntry)
+		c.size += size - entry.size
+		entry.size = size
+		entry.storedAt = storedAt
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	entry := &fsEntry{key: key, path: path, size: size, storedAt: storedAt}
+	el := c.lru.PushFront(entry)
+	c.index[key] = el
+	c.size += size
+}
+
+// evictIfNeededLocked вытесняет наименее недавно использованные тайлы, пока
+// общий размер не уложится в квоту
+func (c *FSTileCache) evictIfNeededLocked() {
+	if c.maxSize <= 0 {
+		return
+	}
+	for c.size > c.maxSize {
+		back := c.lru.Back()
+		if back == nil {
+			return
+		}
+		entry := back.Value.(*fsEntry)
+		c.removeLocked(back)
+		os.Remove(entry.path)
+	}
+}
+
+func (c *FSTileCache) removeLocked(el *list.Element) {
+	entry := el.Value.(*fsEntry)
+	delete(c.index, entry.key)
+	c.lru.Remove(el)
+	c.size -= entry.size
+}
+
+func (c *FSTileCache) Stats() TileCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return TileCacheStats{
+		Entries:   len(c.index),
+		SizeBytes: c.size,
+		Hits:      c.hits,
+		Misses:    c.misses,
+	}
+}
@@ -0,0 +1,45 @@
+package staticmap
+
+import "image/color"
+
+// LatLng — точка в географических координатах
+type LatLng struct {
+	Lat float64
+	Lng float64
+}
+
+// Marker — точечный маркер с опциональной подписью. Color/StrokeColor == nil
+// означает дефолтный красный с чёрной обводкой — тот же вид, что и раньше
+// был жёстко зашит в addLocationMarker
+type Marker struct {
+	Lat, Lng    float64
+	Color       color.Color
+	Radius      float64 // в пикселях, <= 0 — дефолт
+	StrokeColor color.Color
+	StrokeWidth float64 // в пикселях, <= 0 — дефолт
+	Label       string
+}
+
+// Path — полилиния (маршрут, трек)
+type Path struct {
+	Points      []LatLng
+	StrokeColor color.Color
+	StrokeWidth float64 // в пикселях, <= 0 — дефолт
+}
+
+// Area — закрашенный многоугольник
+type Area struct {
+	Points      []LatLng
+	FillColor   color.Color // nil — не заливать
+	StrokeColor color.Color // nil — не обводить
+	StrokeWidth float64     // в пикселях, <= 0 — дефолт при наличии StrokeColor
+}
+
+// Circle — окружность заданного радиуса в метрах вокруг точки
+type Circle struct {
+	Lat, Lng     float64
+	RadiusMeters float64
+	FillColor    color.Color
+	StrokeColor  color.Color
+	StrokeWidth  float64 // в пикселях, <= 0 — дефолт при наличии StrokeColor
+}
@@ -0,0 +1,119 @@
+package staticmap
+
+import (
+	"container/list"
+	"sync"
+)
+
+// memEntry — запись горячего in-memory слоя MemoryLRUTileCache
+type memEntry struct {
+	key  string
+	data []byte
+}
+
+// MemoryLRUTileCache держит capacity последних «горячих» тайлов в памяти
+// перед next (как правило, FSTileCache), чтобы повторный рендер той же
+// области не трогал диск вовсе
+type MemoryLRUTileCache struct {
+	next     TileCache
+	capacity int
+
+	mu     sync.Mutex
+	index  map[string]*list.Element
+	lru    *list.List
+	hits   int64
+	misses int64
+}
+
+// NewMemoryLRUTileCache создаёт in-memory слой ёмкостью capacity тайлов
+// поверх next (может быть nil — тогда кеш работает только в памяти процесса)
+func NewMemoryLRUTileCache(next TileCache, capacity int) *MemoryLRUTileCache {
+	return &MemoryLRUTileCache{
+		next:     next,
+		capacity: capacity,
+		index:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (c *MemoryLRUTileCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	if el, ok := c.index[key]; ok {
+		c.lru.MoveToFront(el)
+		data := el.Value.(*memEntry).data
+		c.hits++
+		c.mu.Unlock()
+		return data, true
+	}
+	c.mu.Unlock()
+
+	if c.next == nil {
+		c.mu.Lock()
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+
+	data, ok := c.next.Get(key)
+
+	c.mu.Lock()
+	if ok {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	c.mu.Unlock()
+
+	if ok {
+		c.insert(key, data)
+	}
+	return data, ok
+}
+
+func (c *MemoryLRUTileCache) Set(key string, data []byte) {
+	if c.next != nil {
+		c.next.Set(key, data)
+	}
+	c.insert(key, data)
+}
+
+func (c *MemoryLRUTileCache) insert(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.index[key]; ok {
+		el.Value.(*memEntry).data = data
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	el := c.lru.PushFront(&memEntry{key: key, data: data})
+	c.index[key] = el
+
+	for c.lru.Len() > c.capacity {
+		back := c.lru.Back()
+		if back == nil {
+			break
+		}
+		entry := back.Value.(*memEntry)
+		delete(c.index, entry.key)
+		c.lru.Remove(back)
+	}
+}
+
+// Stats отражает эффективность кеша в целом: счётчики попаданий/промахов
+// берутся с этого (переднего) слоя, а размер на диске — из next, если он есть
+func (c *MemoryLRUTileCache) Stats() TileCacheStats {
+	c.mu.Lock()
+	stats := TileCacheStats{
+		Entries: c.lru.Len(),
+		Hits:    c.hits,
+		Misses:  c.misses,
+	}
+	c.mu.Unlock()
+
+	if c.next != nil {
+		stats.SizeBytes = c.next.Stats().SizeBytes
+	}
+	return stats
+}
@@ -0,0 +1,23 @@
+package staticmap
+
+import "math"
+
+// wgs84Eccentricity — эксцентриситет эллипсоида WGS84, используемый в
+// проекции Меркатора, чтобы пиксельные координаты совпадали с Leaflet/OSM
+const wgs84Eccentricity = 0.0818191908426
+
+// latLngToPixel переводит широту/долготу в глобальные пиксельные координаты
+// сетки тайлов заданного размера на заданном уровне масштабирования
+func latLngToPixel(lat, lng float64, zoom, tileSize int) (float64, float64) {
+	e := wgs84Eccentricity
+	beta := lat * math.Pi / 180.0
+	phi := (1 - e*math.Sin(beta)) / (1 + e*math.Sin(beta))
+	theta := math.Tan(math.Pi/4+beta/2) * math.Pow(phi, e/2)
+
+	rho := math.Pow(2, float64(zoom)) * float64(tileSize) / 2
+
+	x := rho * (1 + lng/180)
+	y := rho * (1 - math.Log(theta)/math.Pi)
+
+	return x, y
+}
@@ -0,0 +1,30 @@
+// Package staticmap рендерит статические карты из тайловых серверов:
+// подключение любого HTTP-провайдера тайлов через интерфейс TileProvider
+// и сборка итогового изображения билдером Context.
+package staticmap
+
+// DefaultTileSize — размер тайла в пикселях, который используют OSM,
+// Yandex и большинство других серверов
+const DefaultTileSize = 256
+
+// TileProvider описывает источник тайлов карты: как построить URL
+// конкретного тайла и какими параметрами (размер, зум, атрибуция,
+// User-Agent) при этом руководствоваться. Встроенные провайдеры
+// (OpenStreetMap, Yandex, generic-шаблон) реализуют его через
+// templateProvider; организации могут подключить свой через
+// NewGenericProvider или собственный тип и Register.
+type TileProvider interface {
+	// Name — короткий идентификатор провайдера для выбора по имени
+	// (например, через query-параметр provider=)
+	Name() string
+	// TileURL возвращает адрес конкретного тайла
+	TileURL(x, y, zoom int) string
+	// TileSize — сторона тайла в пикселях
+	TileSize() int
+	// MaxZoom — максимальный уровень масштабирования, который отдаёт сервер
+	MaxZoom() int
+	// Attribution — подпись правообладателя для отображения поверх карты
+	Attribution() string
+	// UserAgent — значение заголовка User-Agent для запросов к серверу тайлов
+	UserAgent() string
+}
@@ -0,0 +1,83 @@
+package staticmap
+
+import (
+	"strconv"
+	"strings"
+)
+
+// templateProvider — провайдер на основе URL-шаблона с {s}/{z}/{x}/{y},
+// на нём построены все встроенные провайдеры пакета
+type templateProvider struct {
+	name        string
+	template    string
+	subdomains  []string
+	tileSize    int
+	maxZoom     int
+	attribution string
+	userAgent   string
+}
+
+func (p *templateProvider) Name() string        { return p.name }
+func (p *templateProvider) TileSize() int       { return p.tileSize }
+func (p *templateProvider) MaxZoom() int        { return p.maxZoom }
+func (p *templateProvider) Attribution() string { return p.attribution }
+func (p *templateProvider) UserAgent() string   { return p.userAgent }
+
+func (p *templateProvider) TileURL(x, y, zoom int) string {
+	url := p.template
+	if len(p.subdomains) > 0 {
+		sub := p.subdomains[(x+y)%len(p.subdomains)]
+		url = strings.ReplaceAll(url, "{s}", sub)
+	}
+	url = strings.ReplaceAll(url, "{z}", strconv.Itoa(zoom))
+	url = strings.ReplaceAll(url, "{x}", strconv.Itoa(x))
+	url = strings.ReplaceAll(url, "{y}", strconv.Itoa(y))
+	return url
+}
+
+// NewOSMProvider возвращает встроенный провайдер тайлов OpenStreetMap,
+// ротирующий поддомены {s} так же, как это делает Leaflet
+func NewOSMProvider() TileProvider {
+	return &templateProvider{
+		name:        "osm",
+		template:    "https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png",
+		subdomains:  []string{"a", "b", "c"},
+		tileSize:    DefaultTileSize,
+		maxZoom:     19,
+		attribution: "© OpenStreetMap contributors",
+		userAgent:   "vi-tg/1.0",
+	}
+}
+
+// NewYandexProvider возвращает провайдер тайлов Яндекс.Карт. apiKey
+// подставляется в запрос, если пуст — сервер используется без ключа
+func NewYandexProvider(apiKey string) TileProvider {
+	template := "https://core-renderer-tiles.maps.yandex.net/v2/?l=map&x={x}&y={y}&z={z}"
+	if apiKey != "" {
+		template += "&apikey=" + apiKey
+	}
+	return &templateProvider{
+		name:        "yandex",
+		template:    template,
+		tileSize:    DefaultTileSize,
+		maxZoom:     19,
+		attribution: "© Яндекс.Карты",
+		userAgent:   "vi-tg/1.0",
+	}
+}
+
+// NewGenericProvider оборачивает произвольный шаблон URL (с {z}/{x}/{y} и,
+// опционально, {s}) — для внутренних серверов тайлов организаций
+func NewGenericProvider(name, template string, tileSize, maxZoom int) TileProvider {
+	if tileSize <= 0 {
+		tileSize = DefaultTileSize
+	}
+	return &templateProvider{
+		name:       name,
+		template:   template,
+		subdomains: []string{"a", "b", "c"},
+		tileSize:   tileSize,
+		maxZoom:    maxZoom,
+		userAgent:  "vi-tg/1.0",
+	}
+}
@@ -0,0 +1,28 @@
+package staticmap
+
+import "sync"
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]TileProvider{
+		"osm":    NewOSMProvider(),
+		"yandex": NewYandexProvider(""),
+	}
+)
+
+// Register добавляет (или переопределяет) именованный провайдер в реестр,
+// откуда его можно выбрать через Provider — например, по query-параметру
+// provider= без перекомпиляции сервера
+func Register(p TileProvider) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[p.Name()] = p
+}
+
+// Provider возвращает зарегистрированный провайдер по имени
+func Provider(name string) (TileProvider, bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	p, ok := registry[name]
+	return p, ok
+}
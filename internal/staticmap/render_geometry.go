@@ -0,0 +1,252 @@
+package staticmap
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// metersPerDegreeLat — приближение числа метров в одном градусе широты,
+// используется для грубого перевода радиуса Circle из метров в градусы при
+// подборе ограничивающего прямоугольника
+const metersPerDegreeLat = 111320.0
+
+// autoFit подбирает центр и зум так, чтобы вся накопленная геометрия
+// (маркеры, точки путей и областей, окружности с учётом радиуса) поместилась
+// в холст с небольшим запасом по краям. Если геометрии нет, оставляет
+// центр/зум без изменений
+func (c *Context) autoFit() {
+	points := c.collectBoundingPoints()
+	if len(points) == 0 {
+		return
+	}
+
+	minLat, maxLat := points[0].Lat, points[0].Lat
+	minLng, maxLng := points[0].Lng, points[0].Lng
+	for _, p := range points[1:] {
+		minLat = math.Min(minLat, p.Lat)
+		maxLat = math.Max(maxLat, p.Lat)
+		minLng = math.Min(minLng, p.Lng)
+		maxLng = math.Max(maxLng, p.Lng)
+	}
+
+	const marginFraction = 0.1
+	latMargin := (maxLat - minLat) * marginFraction
+	lngMargin := (maxLng - minLng) * marginFraction
+	minLat -= latMargin
+	maxLat += latMargin
+	minLng -= lngMargin
+	maxLng += lngMargin
+
+	c.lat = (minLat + maxLat) / 2
+	c.lng = (minLng + maxLng) / 2
+
+	tileSize := c.provider.TileSize()
+	if tileSize <= 0 {
+		tileSize = DefaultTileSize
+	}
+
+	maxZoom := c.provider.MaxZoom()
+	if maxZoom <= 0 {
+		maxZoom = 19
+	}
+
+	zoom := maxZoom
+	for ; zoom > 0; zoom-- {
+		x1, y1 := latLngToPixel(minLat, minLng, zoom, tileSize)
+		x2, y2 := latLngToPixel(maxLat, maxLng, zoom, tileSize)
+		if math.Abs(x2-x1) <= float64(c.width) && math.Abs(y2-y1) <= float64(c.height) {
+			break
+		}
+	}
+	c.zoom = zoom
+}
+
+// collectBoundingPoints возвращает все географические точки, которые должны
+// поместиться в кадр: вершины маркеров/путей/областей и угловые точки
+// окружностей, расширенные на их радиус
+func (c *Context) collectBoundingPoints() []LatLng {
+	var points []LatLng
+
+	for _, m := range c.markers {
+		points = append(points, LatLng{Lat: m.Lat, Lng: m.Lng})
+	}
+	for _, p := range c.paths {
+		points = append(points, p.Points...)
+	}
+	for _, a := range c.areas {
+		points = append(points, a.Points...)
+	}
+	for _, ci := range c.circles {
+		degLat := ci.RadiusMeters / metersPerDegreeLat
+		degLng := degLat
+		if cos := math.Cos(ci.Lat * math.Pi / 180); cos != 0 {
+			degLng = degLat / math.Abs(cos)
+		}
+		points = append(points,
+			LatLng{Lat: ci.Lat + degLat, Lng: ci.Lng + degLng},
+			LatLng{Lat: ci.Lat - degLat, Lng: ci.Lng - degLng},
+		)
+	}
+
+	return points
+}
+
+// pixelForLatLng переводит географическую точку в пиксель холста по той же
+// проекции и тому же центру/зуму, что используются для базового слоя тайлов
+func (c *Context) pixelForLatLng(lat, lng float64) (float64, float64) {
+	tileSize := c.provider.TileSize()
+	if tileSize <= 0 {
+		tileSize = DefaultTileSize
+	}
+
+	px, py := latLngToPixel(lat, lng, c.zoom, tileSize)
+	centerPxX, centerPxY := latLngToPixel(c.lat, c.lng, c.zoom, tileSize)
+	originX := centerPxX - float64(c.width)/2
+	originY := centerPxY - float64(c.height)/2
+
+	return px - originX, py - originY
+}
+
+// metersToPixels переводит метры в пиксели на данной широте и зуме,
+// используя стандартное приближение масштаба проекции Меркатора
+func metersToPixels(meters, lat float64, zoom, tileSize int) float64 {
+	metersPerPixel := 156543.03392 * math.Cos(lat*math.Pi/180) / math.Pow(2, float64(zoom))
+	metersPerPixel *= float64(DefaultTileSize) / float64(tileSize)
+	if metersPerPixel <= 0 {
+		return 0
+	}
+	return meters / metersPerPixel
+}
+
+// drawGeometry рисует поверх холста области, пути, окружности и маркеры (в
+// этом порядке, чтобы маркеры и подписи всегда были видны поверх остальной
+// геометрии) с помощью сглаженного рендеринга gg
+func (c *Context) drawGeometry(canvas *image.RGBA) {
+	if len(c.areas)+len(c.paths)+len(c.circles)+len(c.markers) == 0 {
+		return
+	}
+
+	dc := gg.NewContextForRGBA(canvas)
+	tileSize := c.provider.TileSize()
+	if tileSize <= 0 {
+		tileSize = DefaultTileSize
+	}
+
+	for _, a := range c.areas {
+		if len(a.Points) < 3 {
+			continue
+		}
+		dc.NewSubPath()
+		for i, pt := range a.Points {
+			x, y := c.pixelForLatLng(pt.Lat, pt.Lng)
+			if i == 0 {
+				dc.MoveTo(x, y)
+			} else {
+				dc.LineTo(x, y)
+			}
+		}
+		dc.ClosePath()
+
+		if a.FillColor != nil {
+			dc.SetColor(a.FillColor)
+			dc.FillPreserve()
+		}
+		if a.StrokeColor != nil {
+			width := a.StrokeWidth
+			if width <= 0 {
+				width = 2
+			}
+			dc.SetLineWidth(width)
+			dc.SetColor(a.StrokeColor)
+			dc.Stroke()
+		} else {
+			dc.ClearPath()
+		}
+	}
+
+	for _, p := range c.paths {
+		if len(p.Points) < 2 {
+			continue
+		}
+		dc.NewSubPath()
+		for i, pt := range p.Points {
+			x, y := c.pixelForLatLng(pt.Lat, pt.Lng)
+			if i == 0 {
+				dc.MoveTo(x, y)
+			} else {
+				dc.LineTo(x, y)
+			}
+		}
+
+		width := p.StrokeWidth
+		if width <= 0 {
+			width = 3
+		}
+		strokeColor := p.StrokeColor
+		if strokeColor == nil {
+			strokeColor = color.RGBA{R: 0, G: 100, B: 255, A: 255}
+		}
+		dc.SetLineWidth(width)
+		dc.SetColor(strokeColor)
+		dc.Stroke()
+	}
+
+	for _, ci := range c.circles {
+		x, y := c.pixelForLatLng(ci.Lat, ci.Lng)
+		radius := metersToPixels(ci.RadiusMeters, ci.Lat, c.zoom, tileSize)
+
+		dc.DrawCircle(x, y, radius)
+		if ci.FillColor != nil {
+			dc.SetColor(ci.FillColor)
+			dc.FillPreserve()
+		}
+		if ci.StrokeColor != nil {
+			width := ci.StrokeWidth
+			if width <= 0 {
+				width = 2
+			}
+			dc.SetLineWidth(width)
+			dc.SetColor(ci.StrokeColor)
+			dc.Stroke()
+		} else {
+			dc.ClearPath()
+		}
+	}
+
+	for _, m := range c.markers {
+		x, y := c.pixelForLatLng(m.Lat, m.Lng)
+		radius := m.Radius
+		if radius <= 0 {
+			radius = 10
+		}
+		fill := m.Color
+		if fill == nil {
+			fill = color.RGBA{R: 255, G: 0, B: 0, A: 255}
+		}
+		strokeColor := m.StrokeColor
+		if strokeColor == nil {
+			strokeColor = color.Black
+		}
+		strokeWidth := m.StrokeWidth
+		if strokeWidth <= 0 {
+			strokeWidth = 2
+		}
+
+		dc.DrawCircle(x, y, radius)
+		dc.SetColor(fill)
+		dc.Fill()
+
+		dc.DrawCircle(x, y, radius)
+		dc.SetLineWidth(strokeWidth)
+		dc.SetColor(strokeColor)
+		dc.Stroke()
+
+		if m.Label != "" {
+			dc.SetColor(color.Black)
+			dc.DrawStringAnchored(m.Label, x, y-radius-4, 0.5, 1)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package staticmap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// TileCache — кеш сырых байт тайлов, консультируемый в fetchTile перед
+// HTTP-запросом к серверу тайлов. Реализации: FSTileCache (диск, с TTL и
+// квотой) и MemoryLRUTileCache (горячий слой поверх другого TileCache)
+type TileCache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, data []byte)
+	Stats() TileCacheStats
+}
+
+// TileCacheStats — снимок состояния кеша для /api/cache/stats
+type TileCacheStats struct {
+	Entries   int   `json:"entries"`
+	SizeBytes int64 `json:"size_bytes"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+}
+
+// TileCacheKey строит content-addressed ключ кеша из провайдера и координат
+// тайла: sha256(provider|z|x|y)
+func TileCacheKey(provider string, zoom, x, y int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%d", provider, zoom, x, y)))
+	return hex.EncodeToString(sum[:])
+}
+
+// cache — кеш тайлов, используемый fetchTile. nil по умолчанию отключает
+// кеширование и сохраняет прежнее поведение (каждый рендер бьёт по сети)
+var cache TileCache
+
+// SetCache подключает кеш тайлов, консультируемый перед каждым HTTP-запросом
+// в fetchTile
+func SetCache(c TileCache) {
+	cache = c
+}
+
+// Cache возвращает текущий подключённый кеш тайлов (может быть nil)
+func Cache() TileCache {
+	return cache
+}
@@ -0,0 +1,219 @@
+package staticmap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	tileFetchTimeout        = 8 * time.Second
+	tileFetchMaxRetries     = 3
+	tileFetchBaseBackoff    = 200 * time.Millisecond
+	tileFetchMaxConcurrent  = 16
+	tileFetchRatePerSecond  = 20
+	tileFetchRateBurst      = 30
+	circuitBreakerThreshold = 5
+	circuitBreakerCooldown  = 30 * time.Second
+)
+
+// tileHTTPError — немаршальная ошибка с HTTP-статусом тайлового сервера и,
+// если он его передал, временем до следующей попытки из заголовка Retry-After
+type tileHTTPError struct {
+	status     int
+	retryAfter time.Duration
+}
+
+func (e *tileHTTPError) Error() string {
+	return fmt.Sprintf("сервер тайлов вернул статус %d", e.status)
+}
+
+// providerCircuit — состояние автоматического выключателя для одного
+// провайдера: после circuitBreakerThreshold подряд неудачных запросов
+// дальнейшие запросы к нему сразу проваливаются на время
+// circuitBreakerCooldown вместо повторных попыток достучаться до де-факто
+// недоступного сервера
+type providerCircuit struct {
+	consecutiveFailures int
+	openUntil           time.Time
+}
+
+// tileFetcher — единая точка загрузки тайлов для всех провайдеров: общий
+// *http.Client с таймаутом, ограничение скорости запросов на провайдера,
+// семафор на число одновременных запросов и circuit breaker на провайдера
+type tileFetcher struct {
+	client *http.Client
+	sem    chan struct{}
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	circuits map[string]*providerCircuit
+}
+
+func newTileFetcher() *tileFetcher {
+	return &tileFetcher{
+		client:   &http.Client{Timeout: tileFetchTimeout},
+		sem:      make(chan struct{}, tileFetchMaxConcurrent),
+		limiters: make(map[string]*rate.Limiter),
+		circuits: make(map[string]*providerCircuit),
+	}
+}
+
+// defaultFetcher используется fetchTile для всех запросов тайлов
+var defaultFetcher = newTileFetcher()
+
+func (f *tileFetcher) limiterFor(provider string) *rate.Limiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	l, ok := f.limiters[provider]
+	if !ok {
+		l = rate.NewLimiter(rate.Limit(tileFetchRatePerSecond), tileFetchRateBurst)
+		f.limiters[provider] = l
+	}
+	return l
+}
+
+func (f *tileFetcher) circuitFor(provider string) *providerCircuit {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.circuits[provider]
+	if !ok {
+		c = &providerCircuit{}
+		f.circuits[provider] = c
+	}
+	return c
+}
+
+func (f *tileFetcher) isOpen(provider string) bool {
+	c := f.circuitFor(provider)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return time.Now().Before(c.openUntil)
+}
+
+func (f *tileFetcher) recordSuccess(provider string) {
+	c := f.circuitFor(provider)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c.consecutiveFailures = 0
+	c.openUntil = time.Time{}
+}
+
+func (f *tileFetcher) recordFailure(provider string) {
+	c := f.circuitFor(provider)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c.consecutiveFailures++
+	if c.consecutiveFailures >= circuitBreakerThreshold {
+		c.openUntil = time.Now().Add(circuitBreakerCooldown)
+	}
+}
+
+// fetch скачивает один тайл, применяя circuit breaker, лимит скорости и
+// семафор параллелизма провайдера, с ретраями на 5xx/429 с экспоненциальной
+// задержкой (или задержкой из Retry-After, если сервер её прислал)
+func (f *tileFetcher) fetch(provider TileProvider, x, y, zoom int) ([]byte, error) {
+	name := provider.Name()
+
+	if f.isOpen(name) {
+		return nil, fmt.Errorf("провайдер %s временно отключён после серии ошибок, повтор позже", name)
+	}
+
+	f.sem <- struct{}{}
+	defer func() { <-f.sem }()
+
+	if err := f.limiterFor(name).Wait(context.Background()); err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= tileFetchMaxRetries; attempt++ {
+		data, err := f.doRequest(provider, x, y, zoom)
+		if err == nil {
+			f.recordSuccess(name)
+			return data, nil
+		}
+		lastErr = err
+
+		httpErr, retryable := asRetryableError(err)
+		if !retryable || attempt == tileFetchMaxRetries {
+			break
+		}
+
+		delay := backoffDelay(attempt)
+		if httpErr != nil && httpErr.retryAfter > 0 {
+			delay = httpErr.retryAfter
+		}
+		time.Sleep(delay)
+	}
+
+	f.recordFailure(name)
+	return nil, lastErr
+}
+
+func (f *tileFetcher) doRequest(provider TileProvider, x, y, zoom int) ([]byte, error) {
+	req, err := http.NewRequest("GET", provider.TileURL(x, y, zoom), nil)
+	if err != nil {
+		return nil, err
+	}
+	if ua := provider.UserAgent(); ua != "" {
+		req.Header.Set("User-Agent", ua)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса тайла: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &tileHTTPError{status: resp.StatusCode, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения тайла: %w", err)
+	}
+	return data, nil
+}
+
+// asRetryableError сообщает, стоит ли повторить запрос: сетевые ошибки
+// (таймаут, обрыв соединения) всегда стоит повторить, а из HTTP-ошибок —
+// только 5xx и 429
+func asRetryableError(err error) (*tileHTTPError, bool) {
+	httpErr, ok := err.(*tileHTTPError)
+	if !ok {
+		return nil, true
+	}
+	return httpErr, httpErr.status >= 500 || httpErr.status == http.StatusTooManyRequests
+}
+
+func backoffDelay(attempt int) time.Duration {
+	return time.Duration(math.Pow(2, float64(attempt))) * tileFetchBaseBackoff
+}
+
+// parseRetryAfter разбирает заголовок Retry-After в виде числа секунд или
+// HTTP-даты; отсутствие или прошедшее время даёт 0 (использовать обычный backoff)
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
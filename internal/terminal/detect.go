@@ -0,0 +1,109 @@
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	detectOnce   sync.Once
+	detectedOnce GraphicsBackend
+)
+
+// Detected определяет GraphicsBackend один раз за время работы процесса и
+// кеширует результат — detect() может отправлять DA1-запрос терминалу и
+// ждать ответа, так что вызывать его на каждое сообщение нельзя
+func Detected() GraphicsBackend {
+	detectOnce.Do(func() {
+		detectedOnce = detect()
+	})
+	return detectedOnce
+}
+
+// detect выбирает GraphicsBackend по возможностям текущего терминала.
+// VI_TG_GRAPHICS=kitty|sixel|iterm2|none переопределяет автоопределение
+// для ручной диагностики и окружений, где DA1-запрос ненадёжен (мультиплексоры,
+// логирование в файл вместо реального терминала); VI_TG_NO_KITTY=1 убирает
+// Kitty из автоопределения, не трогая явный VI_TG_GRAPHICS=kitty
+func detect() GraphicsBackend {
+	switch os.Getenv("VI_TG_GRAPHICS") {
+	case "kitty":
+		return kittyBackend{}
+	case "sixel":
+		return sixelBackend{}
+	case "iterm2":
+		return iterm2Backend{}
+	case "none":
+		return noneBackend{}
+	}
+
+	term := os.Getenv("TERM")
+	termProgram := os.Getenv("TERM_PROGRAM")
+	noKitty := os.Getenv("VI_TG_NO_KITTY") == "1"
+
+	if !noKitty && (term == "xterm-kitty" || strings.Contains(term, "kitty") || os.Getenv("KITTY_WINDOW_ID") != "") {
+		return kittyBackend{}
+	}
+
+	if termProgram == "iTerm.app" || termProgram == "WezTerm" {
+		return iterm2Backend{}
+	}
+
+	if strings.Contains(term, "sixel") || supportsSixelDA1() {
+		return sixelBackend{}
+	}
+
+	return noneBackend{}
+}
+
+// supportsSixelDA1 запрашивает у терминала его Primary Device Attributes
+// (ESC [ c) и проверяет, присутствует ли в ответе атрибут "4" (поддержка
+// sixel graphics, см. ECMA-48/VT340). Выполняется только когда stdin —
+// настоящий терминал, и не дольше 200мс, чтобы не подвешивать запуск в
+// пайпах/логах, где ответа не будет вовсе
+func supportsSixelDA1() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	fmt.Fprint(os.Stdout, "\x1b[c")
+
+	ch := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := os.Stdin.Read(buf)
+		ch <- string(buf[:n])
+	}()
+
+	select {
+	case resp := <-ch:
+		return parseDA1SixelAttr(resp)
+	case <-time.After(200 * time.Millisecond):
+		return false
+	}
+}
+
+// parseDA1SixelAttr разбирает ответ вида "\x1b[?62;1;4;6c" и проверяет,
+// входит ли атрибут "4" в список возможностей терминала
+func parseDA1SixelAttr(resp string) bool {
+	start := strings.Index(resp, "[?")
+	if start == -1 {
+		return false
+	}
+	end := strings.IndexByte(resp[start:], 'c')
+	if end == -1 {
+		return false
+	}
+
+	attrs := strings.Split(resp[start+2:start+end], ";")
+	for _, a := range attrs {
+		if a == "4" {
+			return true
+		}
+	}
+	return false
+}
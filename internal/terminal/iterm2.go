@@ -0,0 +1,30 @@
+package terminal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// iterm2Backend реализует inline images протокол iTerm2/WezTerm:
+// OSC 1337;File=inline=1:<base64 PNG> BEL
+type iterm2Backend struct{}
+
+func (iterm2Backend) Name() string { return "iterm2" }
+
+func (iterm2Backend) Render(pngData []byte, opts RenderOptions) (string, uint32, error) {
+	var b strings.Builder
+
+	fmt.Fprint(&b, "\x1b]1337;File=inline=1")
+	if opts.Columns > 0 {
+		fmt.Fprintf(&b, ";width=%d", opts.Columns)
+	}
+	if opts.Rows > 0 {
+		fmt.Fprintf(&b, ";height=%d", opts.Rows)
+	}
+	fmt.Fprintf(&b, ":%s\a", base64.StdEncoding.EncodeToString(pngData))
+
+	return b.String(), 0, nil
+}
+
+func (iterm2Backend) Erase(uint32) string { return "" }
@@ -0,0 +1,17 @@
+package terminal
+
+import "vi-tg/internal/kitty"
+
+// kittyBackend оборачивает internal/kitty.Transmit/Erase как GraphicsBackend
+type kittyBackend struct{}
+
+func (kittyBackend) Name() string { return "kitty" }
+
+func (kittyBackend) Render(pngData []byte, opts RenderOptions) (string, uint32, error) {
+	seq, placementID := kitty.Transmit(pngData, kitty.Options{Columns: opts.Columns, Rows: opts.Rows})
+	return seq, placementID, nil
+}
+
+func (kittyBackend) Erase(placementID uint32) string {
+	return kitty.Erase(placementID)
+}
@@ -0,0 +1,15 @@
+package terminal
+
+// noneBackend не показывает картинки — выбирается, когда терминал не
+// поддерживает ни один известный графический протокол, либо явно запрошен
+// через VI_TG_GRAPHICS=none. Вызывающий код (main.go) обязан сам откатиться
+// на текстовый фоллбэк, когда Render возвращает пустую последовательность
+type noneBackend struct{}
+
+func (noneBackend) Name() string { return "none" }
+
+func (noneBackend) Render(_ []byte, _ RenderOptions) (string, uint32, error) {
+	return "", 0, ErrNoGraphics
+}
+
+func (noneBackend) Erase(uint32) string { return "" }
@@ -0,0 +1,145 @@
+package terminal
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"strings"
+)
+
+// sixelBackend кодирует PNG в DEC Sixel graphics (ESC P ... ESC \) —
+// поддерживается xterm (-ti vt340), foot, WezTerm, mlterm и рядом других
+// терминалов без родного Kitty/iTerm2 протокола
+type sixelBackend struct{}
+
+func (sixelBackend) Name() string { return "sixel" }
+
+func (sixelBackend) Render(pngData []byte, _ RenderOptions) (string, uint32, error) {
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		return "", 0, fmt.Errorf("sixel: ошибка декодирования PNG: %w", err)
+	}
+	return encodeSixel(img), 0, nil
+}
+
+func (sixelBackend) Erase(uint32) string { return "" }
+
+// sixelLevels — число уровней квантования на канал: куб sixelLevels^3 цветов
+// (216 при 6 уровнях) — классическая "safe"-палитра, без неё пришлось бы
+// писать полноценный квантователь (octree/median-cut), что для стикеров
+// избыточно
+const sixelLevels = 6
+
+// quantizeChannel переводит 16-битный канал из image.Color.RGBA() в индекс
+// 0..sixelLevels-1
+func quantizeChannel(c uint32) int {
+	v := int(c >> 8)
+	return v * (sixelLevels - 1) / 255
+}
+
+// colorIndexAt возвращает индекс квантованного цвета пикселя (x,y) в
+// координатах img.Bounds()
+func colorIndexAt(img image.Image, x, y int) int {
+	b := img.Bounds()
+	r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+	ri, gi, bi := quantizeChannel(r), quantizeChannel(g), quantizeChannel(bl)
+	return ri*sixelLevels*sixelLevels + gi*sixelLevels + bi
+}
+
+// encodeSixel строит полную DCS-последовательность Sixel для img: растровые
+// атрибуты, определения палитры для реально встреченных цветов и тело
+// изображения полосами по 6 строк, каждая цветовая плоскость — с RLE
+// (!count char) по протоколу Sixel
+func encodeSixel(img image.Image) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	used := make(map[int]bool)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			used[colorIndexAt(img, x, y)] = true
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1bPq")
+	fmt.Fprintf(&b, "\"1;1;%d;%d", width, height)
+
+	for idx := range used {
+		ri := idx / (sixelLevels * sixelLevels)
+		gi := (idx / sixelLevels) % sixelLevels
+		bi := idx % sixelLevels
+		pr := ri * 100 / (sixelLevels - 1)
+		pg := gi * 100 / (sixelLevels - 1)
+		pb := bi * 100 / (sixelLevels - 1)
+		fmt.Fprintf(&b, "#%d;2;%d;%d;%d", idx, pr, pg, pb)
+	}
+
+	for top := 0; top < height; top += 6 {
+		bandHeight := 6
+		if top+bandHeight > height {
+			bandHeight = height - top
+		}
+
+		colorsInBand := make(map[int]bool)
+		for x := 0; x < width; x++ {
+			for dy := 0; dy < bandHeight; dy++ {
+				colorsInBand[colorIndexAt(img, x, top+dy)] = true
+			}
+		}
+
+		first := true
+		for color := range colorsInBand {
+			if !first {
+				b.WriteByte('$') // возврат к началу строки полосы для следующего цвета
+			}
+			first = false
+			fmt.Fprintf(&b, "#%d", color)
+			writeSixelRow(&b, img, top, bandHeight, width, color)
+		}
+		b.WriteByte('-') // переход на следующую полосу из 6 строк
+	}
+
+	b.WriteString("\x1b\\")
+	return b.String()
+}
+
+// writeSixelRow пишет один цветовой проход полосы: для каждого столбца x
+// считает 6-битную маску пикселей цвета color и сворачивает повторяющиеся
+// символы через !count char
+func writeSixelRow(b *strings.Builder, img image.Image, top, bandHeight, width, color int) {
+	var runChar byte
+	runLen := 0
+
+	flush := func() {
+		if runLen == 0 {
+			return
+		}
+		if runLen > 1 {
+			fmt.Fprintf(b, "!%d%c", runLen, runChar)
+		} else {
+			b.WriteByte(runChar)
+		}
+		runLen = 0
+	}
+
+	for x := 0; x < width; x++ {
+		var mask byte
+		for dy := 0; dy < bandHeight; dy++ {
+			if colorIndexAt(img, x, top+dy) == color {
+				mask |= 1 << uint(dy)
+			}
+		}
+		ch := byte('?') + mask
+
+		if runLen > 0 && ch == runChar {
+			runLen++
+			continue
+		}
+		flush()
+		runChar = ch
+		runLen = 1
+	}
+	flush()
+}
@@ -0,0 +1,55 @@
+// Package terminal определяет общий интерфейс показа изображений inline
+// (GraphicsBackend) и выбирает подходящую реализацию по возможностям
+// терминала: Kitty graphics protocol, Sixel или iTerm2 inline images
+// (chunk3-4). internal/kitty по-прежнему отвечает за анимацию стикеров
+// (webm/tgs) и низкоуровневое кодирование Kitty APC — этот пакет лишь
+// оборачивает его как один из бэкендов наравне с sixel/iterm2
+package terminal
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// ErrNoGraphics возвращает noneBackend.Render — терминал не поддерживает ни
+// один известный графический протокол (или он явно отключён через
+// VI_TG_GRAPHICS=none); вызывающий код должен откатиться на текстовый фоллбэк
+var ErrNoGraphics = errors.New("terminal: графический вывод не поддерживается терминалом")
+
+// RenderOptions управляет необязательным размером картинки в текстовых
+// ячейках — бэкенды, не поддерживающие точный размер, игнорируют нулевые поля
+type RenderOptions struct {
+	Columns int
+	Rows    int
+}
+
+// GraphicsBackend показывает PNG-данные inline в терминале тем протоколом,
+// который реализует конкретный бэкенд
+type GraphicsBackend interface {
+	// Name — короткое имя бэкенда, используется для логов и VI_TG_GRAPHICS
+	Name() string
+	// Render кодирует pngData в escape-последовательность показа картинки.
+	// placementID ненулевой только у бэкендов, поддерживающих адресное
+	// стирание (сейчас — только Kitty); у остальных всегда 0
+	Render(pngData []byte, opts RenderOptions) (sequence string, placementID uint32, err error)
+	// Erase возвращает escape-последовательность, стирающую ранее
+	// показанную картинку с данным placementID; бэкенды без поддержки
+	// адресного стирания возвращают ""
+	Erase(placementID uint32) string
+}
+
+// WrapTmuxPassthrough оборачивает seq в tmux DCS passthrough
+// (\ePtmux;...\e\\), удваивая встречающиеся ESC, если процесс запущен под
+// tmux ($TMUX задан) — иначе tmux перехватывает графическую
+// escape-последовательность вместо передачи её терминалу. Вызывающий код сам
+// решает, когда оборачивать свой вывод (полноэкранный показ стикера —
+// хороший кандидат; построчный рендер в обычном чате — нет, чтобы не платить
+// за двойное экранирование на каждое сообщение)
+func WrapTmuxPassthrough(seq string) string {
+	if os.Getenv("TMUX") == "" {
+		return seq
+	}
+	escaped := strings.ReplaceAll(seq, "\x1b", "\x1b\x1b")
+	return "\x1bPtmux;" + escaped + "\x1b\\"
+}
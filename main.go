@@ -1,23 +1,225 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
 	"log"
 	"os"
 	"os/exec"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"vi-tg/auth"
+	"vi-tg/calls"
 	"vi-tg/config"
+	"vi-tg/internal/customemoji"
+	"vi-tg/internal/downloader"
+	"vi-tg/internal/ffmpeg"
+	"vi-tg/internal/ffmpegprovision"
+	"vi-tg/internal/ffprobe"
+	"vi-tg/internal/kitty"
+	"vi-tg/internal/secrets"
+	"vi-tg/internal/terminal"
+	"vi-tg/media"
+	"vi-tg/notify"
+	"vi-tg/search"
 	"vi-tg/telegram"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"golang.org/x/image/webp"
 )
 
+// maxStickerFileSize ограничивает объём читаемых целиком в память файлов
+// стикеров/превью (текстовый фоллбэк, исходный WebM до конвертации). Kitty
+// передаёт данные чанками напрямую с диска (kitty.TransmitFile, chunk3-5) и
+// под это ограничение не подпадает — оно не про размер итоговой escape-
+// последовательности, а про защиту путей, которые декодируют файл целиком
+const maxStickerFileSize = 8 * 1024 * 1024
+
+// stickerPlacements отслеживает ID размещений Kitty-картинок по пути к
+// файлу стикера, чтобы при перерисовке экрана стирать прежние размещения
+// вместо их бесконтрольного накопления (см. internal/kitty.Tracker)
+var stickerPlacements = kitty.NewTracker()
+
+// stickerCacheEntry хранит результат обработки стикера по конкретному
+// пути: mu гарантирует, что конкурентные сообщения, ссылающиеся на один и
+// тот же файл (быстрый скролл истории), конвертируют его и кодируют в
+// payload выбранного графического бэкенда не более одного раза за время
+// работы процесса. В отличие от sync.Once, успех не фиксируется до тех пор,
+// пока resolve не пройдёт целиком — транзиентная ошибка (обрыв ffmpeg,
+// сбой записи в kitty.TransmitFile) не запоминается навсегда и следующий
+// вызов повторит попытку (chunk3-3)
+type stickerCacheEntry struct {
+	mu   sync.Mutex
+	done bool // true только после успешного resolve
+
+	resolvedPath string // путь к PNG, пригодному для показа (сам файл либо превью webm)
+	payload      string // готовая escape-последовательность выбранного terminal.GraphicsBackend
+	placementID  uint32
+	backend      string // terminal.GraphicsBackend.Name() — нужен вызывающему коду, чтобы понять, можно ли стирать placementID
+	err          error
+}
+
+var stickerCache = struct {
+	mu      sync.Mutex
+	entries map[string]*stickerCacheEntry
+}{entries: make(map[string]*stickerCacheEntry)}
+
+func stickerCacheEntryFor(path string) *stickerCacheEntry {
+	stickerCache.mu.Lock()
+	defer stickerCache.mu.Unlock()
+
+	entry, ok := stickerCache.entries[path]
+	if !ok {
+		entry = &stickerCacheEntry{}
+		stickerCache.entries[path] = entry
+	}
+	return entry
+}
+
+// ffmpegSlots ограничивает число ffmpeg/ffprobe процессов конвейера превью,
+// запущенных одновременно, числом ядер — быстрый скролл истории с кучей
+// ещё не сконвертированных стикеров не должен породить десятки параллельных
+// ffmpeg
+var ffmpegSlots = make(chan struct{}, runtime.NumCPU())
+
+func acquireFFmpegSlot() func() {
+	ffmpegSlots <- struct{}{}
+	return func() { <-ffmpegSlots }
+}
+
+// fileBufPool переиспользует буферы чтения файлов стикеров между рендерами
+// вместо нового []byte на каждое сообщение
+var fileBufPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// readFilePooled читает файл через буфер из fileBufPool; возвращённый срез
+// — отдельная копия содержимого буфера, так как сам буфер уходит обратно в пул
+func readFilePooled(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	bufIface := fileBufPool.Get()
+	buf := bufIface.(*bytes.Buffer)
+	buf.Reset()
+	defer fileBufPool.Put(buf)
+
+	if _, err := buf.ReadFrom(f); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, buf.Len())
+	copy(data, buf.Bytes())
+	return data, nil
+}
+
+// resolve конвертирует стикер path (если нужно — строит PNG-превью webm
+// через media.Thumbnail под ffmpegSlots) и кодирует результат через
+// terminal.Detected(), запоминая его не более одного раза за процесс;
+// width учитывается только первым успешным вызовом — именно он определяет
+// размер превью, сохраняемого на диске media.Thumbnail. Для Kitty данные
+// передаются напрямую с диска через kitty.TransmitFile (chunk3-5) —
+// base64-кодирование там идёт чанками по rawChunkSize байт, так что
+// большой стикер или превью видео не приходится сперва целиком грузить в
+// readFilePooled; остальные бэкенды такой потоковой передачи не
+// поддерживают и читают файл целиком. Провал (ffmpeg, I/O) не запоминается
+// — следующий вызов повторит попытку вместо того, чтобы навсегда вернуть
+// ту же ошибку
+func (e *stickerCacheEntry) resolve(path string, width int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.done {
+		return
+	}
+
+	release := acquireFFmpegSlot()
+	defer release()
+
+	ctx := context.Background()
+	resolved := path
+	if info, err := media.Probe(ctx, path); err == nil && strings.Contains(info.Container, "webm") {
+		px := width * 8
+		thumbPath, thumbErr := media.Thumbnail(ctx, path, media.ThumbnailOptions{Width: px, Height: px})
+		if thumbErr != nil {
+			e.err = thumbErr
+			return
+		}
+		resolved = thumbPath
+	}
+
+	backendName := terminal.Detected().Name()
+	if backendName == "kitty" {
+		seq, placementID, err := kitty.TransmitFile(resolved, kitty.Options{})
+		if err != nil {
+			e.err = err
+			return
+		}
+		e.resolvedPath = resolved
+		e.backend = "kitty"
+		e.payload = seq
+		e.placementID = placementID
+		e.err = nil
+		e.done = true
+		return
+	}
+
+	data, err := readFilePooled(resolved)
+	if err != nil {
+		e.err = err
+		return
+	}
+
+	backend := terminal.Detected()
+	seq, placementID, renderErr := backend.Render(data, terminal.RenderOptions{})
+	if renderErr != nil {
+		e.err = renderErr
+		return
+	}
+	e.resolvedPath = resolved
+	e.backend = backend.Name()
+	e.payload = seq
+	e.placementID = placementID
+	e.err = nil
+	e.done = true
+}
+
+// resolveStickerPath возвращает путь к файлу, пригодному для показа
+// стикером path (сам файл либо png-превью webm), конвертируя не более
+// одного раза за процесс — используется внешними просмотрщиками
+// (showStickerInNewKitty, showStickerFullscreen), которым нужен путь, а не
+// готовый payload
+func resolveStickerPath(path string, width int) (string, error) {
+	entry := stickerCacheEntryFor(path)
+	entry.resolve(path, width)
+	return entry.resolvedPath, entry.err
+}
+
+// inlinePayload возвращает готовую escape-последовательность выбранного
+// графического бэкенда для встроенного показа стикера path вместе с её ID
+// размещения (ненулевой только для Kitty) и именем бэкенда, конвертируя и
+// кодируя стикер не более одного раза за процесс
+func inlinePayload(path string, width int) (seq string, placementID uint32, backend string, err error) {
+	entry := stickerCacheEntryFor(path)
+	entry.resolve(path, width)
+	return entry.payload, entry.placementID, entry.backend, entry.err
+}
+
 // Стили для интерфейса
 var (
 	titleStyle = lipgloss.NewStyle().
@@ -44,6 +246,7 @@ var (
 type model struct {
 	telegram *telegram.Client
 	mtproto  *auth.MTProtoClient
+	calls    *calls.Manager
 	config   *config.Config
 	ctx      context.Context
 
@@ -66,12 +269,34 @@ type model struct {
 	stickerViewMode   bool
 	selectedSticker   *MessageItem
 	stickerPanelIndex int // Индекс выбранного стикера в панели
+
+	// Свёрнутые форум-группы (ID супергруппы -> true, если темы скрыты)
+	collapsedForums map[int64]bool
+
+	// Поиск сообщений (chunk2-5)
+	localIndex    *search.LocalIndex
+	searchMode    bool
+	searchInput   string
+	searchResults []search.Result
+	searchHit     int
+	messageScroll int   // Индекс первого видимого сообщения при прокрутке к результату поиска
+	jumpToID      int64 // MessageID, к которому нужно прокрутить после загрузки сообщений чата
+
+	// Уведомления о новых сообщениях с учётом приглушённых чатов (chunk2-6)
+	notifier *notify.Dispatcher
 }
 
 type ChatItem struct {
-	Name   string
-	ID     int64
-	Unread int
+	Name       string
+	ID         int64
+	Unread     int
+	AccessHash int64 // Access hash канала, нужен для тем форума и отправки в канал
+
+	// Поля темы форума (chunk2-2): заполнены, если эта строка — тема, а не чат целиком
+	IsForum      bool  // true для строки-супергруппы с включёнными темами
+	TopicID      int64 // ID темы форума, 0 если это обычный чат
+	TopicTitle   string
+	ParentChatID int64 // ID супергруппы-родителя для строки темы
 }
 
 type MessageItem struct {
@@ -82,6 +307,15 @@ type MessageItem struct {
 	StickerID    int64  // ID стикера если Type == "sticker"
 	StickerEmoji string // Эмодзи стикера
 	StickerPath  string // Путь к файлу стикера (если скачан)
+
+	// Entities — сущности форматирования и кастомных эмодзи, разобранные
+	// customemoji.ParseEntities (chunk2-3); смещения заданы в байтах Text,
+	// который уже содержит плейсхолдеры "[ce:<id>]" вместо customEmoji
+	Entities []customemoji.Entity
+
+	// ID — стабильный идентификатор сообщения в рамках чата, нужен для
+	// перехода к сообщению из панели результатов поиска (chunk2-5)
+	ID int64
 }
 
 // Сообщения для обновления модели
@@ -89,8 +323,10 @@ type loadChatsMsg []ChatItem
 type loadMessagesMsg []MessageItem
 type errorMsg string
 type reloadMessagesMsg struct {
-	chatName string
-	chatID   int64
+	chatName   string
+	chatID     int64
+	accessHash int64
+	topicID    int64
 }
 
 func initialModel() model {
@@ -101,24 +337,49 @@ func initialModel() model {
 
 	var tgClient *telegram.Client
 	var mtprotoClient *auth.MTProtoClient
+	var callManager *calls.Manager
 
 	if cfg.UseMTProto {
 		mtprotoClient = auth.NewMTProtoClient()
-	} else if cfg.TelegramToken != "" {
-		tgClient, err = telegram.NewClient(cfg.TelegramToken)
+		callManager = calls.NewManager(mtprotoClient)
+	}
+	if cfg.UseMTProto || cfg.TelegramToken != "" {
+		tgClient, err = telegram.NewClient(cfg, mtprotoClient)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 
+	// Локальный индекс поиска не критичен для работы приложения — при ошибке
+	// просто продолжаем без офлайн-поиска, как и остальные опциональные подсистемы
+	localIndex, err := search.OpenLocalIndex()
+	if err != nil {
+		log.Printf("Поиск: не удалось открыть локальный индекс: %v", err)
+		localIndex = nil
+	}
+
+	// Если ffmpeg/ffprobe уже были установлены через ":ffmpeg install" или
+	// заданы вручную через ":ffmpeg path <p>" в прошлом запуске, используем
+	// сохранённый путь вместо повторного поиска в PATH (chunk5-6)
+	if cfg.FfmpegPath != "" {
+		ffmpeg.SetBinaryPath(cfg.FfmpegPath)
+	}
+	if cfg.FfprobePath != "" {
+		ffprobe.SetBinaryPath(cfg.FfprobePath)
+	}
+
 	return model{
-		telegram: tgClient,
-		mtproto:  mtprotoClient,
-		config:   cfg,
-		ctx:      context.Background(),
-		chats:    []ChatItem{},
-		messages: []MessageItem{},
-		loading:  true,
+		telegram:        tgClient,
+		mtproto:         mtprotoClient,
+		calls:           callManager,
+		localIndex:      localIndex,
+		notifier:        notify.NewDispatcher(cfg, notify.DetectBackend()),
+		config:          cfg,
+		ctx:             context.Background(),
+		chats:           []ChatItem{},
+		messages:        []MessageItem{},
+		loading:         true,
+		collapsedForums: map[int64]bool{},
 	}
 }
 
@@ -127,6 +388,8 @@ func (m model) Init() tea.Cmd {
 		tea.EnterAltScreen,
 		m.initAuth(),
 		m.loadChats(),
+		m.pollCalls(),
+		m.pollNotify(),
 	)
 }
 
@@ -164,10 +427,34 @@ func (m model) loadChats() tea.Cmd {
 
 			for _, dialog := range dialogs {
 				chats = append(chats, ChatItem{
-					Name:   dialog.Title,
-					ID:     dialog.ID,
-					Unread: dialog.Unread,
+					Name:       dialog.Title,
+					ID:         dialog.ID,
+					Unread:     dialog.Unread,
+					AccessHash: dialog.AccessHash,
+					IsForum:    dialog.IsForum,
 				})
+
+				m.importNotifySettings(dialogsCtx, dialog.ID, dialog.AccessHash)
+
+				if dialog.IsForum && !m.collapsedForums[dialog.ID] {
+					topics, err := m.mtproto.GetForumTopics(dialogsCtx, dialog.ID, dialog.AccessHash)
+					if err != nil {
+						// Не прерываем загрузку остальных чатов из-за одной
+						// супергруппы с недоступными темами
+						continue
+					}
+					for _, topic := range topics {
+						chats = append(chats, ChatItem{
+							Name:         "  💬 " + topic.Title,
+							ID:           dialog.ID,
+							Unread:       topic.Unread,
+							AccessHash:   dialog.AccessHash,
+							TopicID:      topic.ID,
+							TopicTitle:   topic.Title,
+							ParentChatID: dialog.ID,
+						})
+					}
+				}
 			}
 		} else if m.telegram != nil {
 			tgChats, err := m.telegram.GetChats()
@@ -192,18 +479,57 @@ func (m model) loadChats() tea.Cmd {
 	})
 }
 
+// importNotifySettings переносит приглушение чата из официального клиента
+// Telegram (account.getNotifySettings) в config.Config.MutedChats при первой
+// загрузке списка чатов — не трогает запись, если пользователь уже настроил
+// её вручную в config.json (chunk2-6)
+func (m model) importNotifySettings(ctx context.Context, chatID, accessHash int64) {
+	if m.mtproto == nil {
+		return
+	}
+	if _, exists := m.config.MutedChats[chatID]; exists {
+		return
+	}
+
+	settings, err := m.mtproto.GetNotifySettings(ctx, chatID, accessHash)
+	if err != nil {
+		return
+	}
+
+	rule, muted := notify.FromTelegramSettings(settings)
+	if !muted {
+		return
+	}
+
+	m.config.MutedChats[chatID] = rule
+	config.SaveConfig(m.config)
+}
+
 func (m model) loadMessages(chatName string, chatID int64) tea.Cmd {
+	return m.loadMessagesForTopic(chatName, chatID, 0, 0)
+}
+
+// loadMessagesForTopic — как loadMessages, но для строки-темы форума (topicID != 0)
+// запрашивает сообщения только этой темы через GetTopicMessages
+func (m model) loadMessagesForTopic(chatName string, chatID, accessHash, topicID int64) tea.Cmd {
 	return tea.Tick(time.Millisecond*100, func(time.Time) tea.Msg {
 		var messages []MessageItem
 
 		if m.config.UseMTProto && m.mtproto != nil {
-			msgs, err := m.mtproto.GetMessages(m.ctx, chatID, 50)
+			var msgs []auth.Message
+			var err error
+			if topicID != 0 {
+				msgs, err = m.mtproto.GetTopicMessages(m.ctx, chatID, accessHash, topicID, 50)
+			} else {
+				msgs, err = m.mtproto.GetMessages(m.ctx, chatID, 50)
+			}
 			if err != nil {
 				return errorMsg(fmt.Sprintf("Ошибка загрузки сообщений: %v", err))
 			}
 
 			for _, msg := range msgs {
 				messages = append(messages, MessageItem{
+					ID:           int64(msg.ID),
 					From:         msg.From,
 					Text:         msg.Text,
 					Timestamp:    msg.Timestamp.Format("15:04"),
@@ -211,6 +537,7 @@ func (m model) loadMessages(chatName string, chatID int64) tea.Cmd {
 					StickerID:    msg.StickerID,
 					StickerEmoji: msg.StickerEmoji,
 					StickerPath:  msg.StickerPath,
+					Entities:     msg.Entities,
 				})
 			}
 		} else if m.telegram != nil {
@@ -221,6 +548,7 @@ func (m model) loadMessages(chatName string, chatID int64) tea.Cmd {
 
 			for _, msg := range msgs {
 				messages = append(messages, MessageItem{
+					ID:           int64(msg.ID),
 					From:         msg.From,
 					Text:         msg.Text,
 					Timestamp:    msg.Timestamp.Format("15:04"),
@@ -232,10 +560,131 @@ func (m model) loadMessages(chatName string, chatID int64) tea.Cmd {
 			}
 		}
 
+		m.mirrorToSearchIndex(chatName, chatID, accessHash, messages)
+
 		return loadMessagesMsg(messages)
 	})
 }
 
+// mirrorToSearchIndex зеркалирует загруженные сообщения в локальный FTS5-
+// индекс фоновой горутиной, чтобы не блокировать отрисовку сообщений записью
+// на диск — индекс нужен только для последующего офлайн-поиска (chunk2-5)
+func (m model) mirrorToSearchIndex(chatName string, chatID, accessHash int64, messages []MessageItem) {
+	if m.localIndex == nil {
+		return
+	}
+
+	go func() {
+		for _, msg := range messages {
+			if msg.Text == "" {
+				continue
+			}
+			ts, _ := time.Parse("15:04", msg.Timestamp)
+			m.localIndex.Index(search.Result{
+				ChatID:     chatID,
+				AccessHash: accessHash,
+				ChatName:   chatName,
+				MessageID:  msg.ID,
+				Text:       msg.Text,
+				From:       msg.From,
+				Timestamp:  ts,
+			})
+		}
+	}()
+}
+
+// dialCall звонит собеседнику из выбранной строки списка чатов (chunk2-4).
+// Ошибки звонка показываются в строке состояния так же, как ошибки загрузки
+func (m model) dialCall() tea.Cmd {
+	return func() tea.Msg {
+		if m.calls == nil || len(m.chats) == 0 {
+			return nil
+		}
+		chat := m.chats[m.chatIndex]
+
+		ctx, cancel := context.WithTimeout(m.ctx, 30*time.Second)
+		defer cancel()
+		if err := m.calls.Dial(ctx, chat.ID, chat.AccessHash); err != nil {
+			return errorMsg(fmt.Sprintf("Ошибка звонка: %v", err))
+		}
+		return nil
+	}
+}
+
+// acceptCall принимает текущий входящий звонок
+func (m model) acceptCall() tea.Cmd {
+	return func() tea.Msg {
+		if m.calls == nil {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(m.ctx, 30*time.Second)
+		defer cancel()
+		if err := m.calls.Accept(ctx); err != nil {
+			return errorMsg(fmt.Sprintf("Ошибка приёма звонка: %v", err))
+		}
+		return nil
+	}
+}
+
+// searchResultsMsg несёт результаты поиска (chunk2-5); пустой, но не nil
+// срез отличает "ничего не нашлось" от "поиск ещё не выполнялся"
+type searchResultsMsg []search.Result
+
+// performSearch ищет query: если MTProto подключен, бьёт в messages.search/
+// messages.searchGlobal (смотря выбран ли конкретный чат), иначе сразу идёт
+// в локальный FTS5-индекс, чтобы поиск работал и без сети
+func (m model) performSearch(query string) tea.Cmd {
+	return func() tea.Msg {
+		var backend search.Backend
+		switch {
+		case m.mtproto != nil:
+			var chatID, accessHash int64
+			var chatName string
+			if m.currentChat != "" && len(m.chats) > 0 {
+				chat := m.chats[m.chatIndex]
+				chatID, accessHash, chatName = chat.ID, chat.AccessHash, chat.Name
+			}
+			backend = search.NewServerBackend(m.mtproto, chatID, accessHash, chatName)
+		case m.localIndex != nil:
+			backend = m.localIndex
+		default:
+			return errorMsg("поиск недоступен: нет ни подключения, ни локального индекса")
+		}
+
+		ctx, cancel := context.WithTimeout(m.ctx, 15*time.Second)
+		defer cancel()
+
+		results, err := backend.Search(ctx, query, 50)
+		if err != nil && m.localIndex != nil {
+			// Сервер недоступен — пробуем локальный офлайн-индекс как фоллбэк
+			results, err = m.localIndex.Search(ctx, query, 50)
+		}
+		if err != nil {
+			return errorMsg(fmt.Sprintf("Ошибка поиска: %v", err))
+		}
+		if results == nil {
+			results = []search.Result{}
+		}
+
+		return searchResultsMsg(results)
+	}
+}
+
+// declineCall отклоняет входящий звонок или завершает активный
+func (m model) declineCall() tea.Cmd {
+	return func() tea.Msg {
+		if m.calls == nil {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(m.ctx, 10*time.Second)
+		defer cancel()
+		if err := m.calls.Decline(ctx); err != nil {
+			return errorMsg(fmt.Sprintf("Ошибка завершения звонка: %v", err))
+		}
+		return nil
+	}
+}
+
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
@@ -244,10 +693,91 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case tea.KeyMsg:
+		if m.searchMode {
+			if m.searchResults == nil {
+				// Ещё набираем поисковый запрос
+				switch msg.String() {
+				case "enter":
+					if m.searchInput == "" {
+						m.searchMode = false
+						return m, nil
+					}
+					return m, m.performSearch(m.searchInput)
+				case "esc":
+					m.searchMode = false
+					m.searchInput = ""
+					return m, nil
+				case "backspace":
+					if len(m.searchInput) > 0 {
+						m.searchInput = m.searchInput[:len(m.searchInput)-1]
+					}
+					return m, nil
+				default:
+					if len(msg.String()) == 1 {
+						m.searchInput += msg.String()
+					}
+					return m, nil
+				}
+			}
+
+			// Результаты уже получены — листаем их n/N, Enter открывает чат
+			switch msg.String() {
+			case "n":
+				if m.searchHit < len(m.searchResults)-1 {
+					m.searchHit++
+				}
+				return m, nil
+			case "N":
+				if m.searchHit > 0 {
+					m.searchHit--
+				}
+				return m, nil
+			case "enter":
+				if len(m.searchResults) == 0 {
+					return m, nil
+				}
+				hit := m.searchResults[m.searchHit]
+				m.currentChat = hit.ChatName
+				m.loading = true
+				m.jumpToID = hit.MessageID
+				for i, chat := range m.chats {
+					if chat.ID == hit.ChatID {
+						m.chatIndex = i
+						break
+					}
+				}
+				m.searchMode = false
+				m.searchResults = nil
+				return m, m.loadMessagesForTopic(hit.ChatName, hit.ChatID, hit.AccessHash, 0)
+			case "esc":
+				m.searchMode = false
+				m.searchInput = ""
+				m.searchResults = nil
+				return m, nil
+			}
+			return m, nil
+		}
+
 		if m.inputMode {
 			switch msg.String() {
 			case "enter":
 				if m.input != "" {
+					// Команды кеша медиафайлов (chunk5-3) набираются в том же
+					// поле ввода, что и текст сообщения, но начинаются с ":"
+					// и не отправляются как сообщение
+					if strings.HasPrefix(m.input, ":") {
+						if cmd, result, handled := runFfmpegCommand(m, m.input); handled {
+							m.input = ""
+							m.inputMode = false
+							m.error = result
+							return m, cmd
+						}
+						result := runCacheCommand(m.input)
+						m.input = ""
+						m.inputMode = false
+						m.error = result
+						return m, nil
+					}
 					cmd := m.sendMessage()
 					m.input = ""
 					m.inputMode = false
@@ -300,7 +830,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				chat := m.chats[m.chatIndex]
 				m.currentChat = chat.Name
 				m.loading = true
-				return m, m.loadMessages(chat.Name, chat.ID)
+				return m, m.loadMessagesForTopic(chat.Name, chat.ID, chat.AccessHash, chat.TopicID)
 			}
 			return m, nil
 		case "i":
@@ -309,11 +839,41 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.error = "" // Очищаем ошибку при входе в режим ввода
 			}
 			return m, nil
+		case "c":
+			// У супергруппы со включёнными темами — свернуть/развернуть темы
+			// форума; для обычного чата — позвонить выбранному собеседнику
+			if len(m.chats) > 0 {
+				chat := m.chats[m.chatIndex]
+				if chat.IsForum {
+					if m.collapsedForums == nil {
+						m.collapsedForums = map[int64]bool{}
+					}
+					m.collapsedForums[chat.ID] = !m.collapsedForums[chat.ID]
+					m.loading = true
+					return m, m.loadChats()
+				}
+				return m, m.dialCall()
+			}
+			return m, nil
+		case "/":
+			// Поиск сообщений (chunk2-5): по текущему чату, если он выбран,
+			// иначе по всем чатам (messages.searchGlobal/локальный индекс)
+			m.searchMode = true
+			m.searchInput = ""
+			m.searchResults = nil
+			m.error = ""
+			return m, nil
+		case "a":
+			// Принять входящий звонок (chunk2-4)
+			return m, m.acceptCall()
+		case "d":
+			// Отклонить входящий звонок или завершить активный
+			return m, m.declineCall()
 		case "r", "f5":
 			if m.currentChat != "" {
 				m.loading = true
 				chat := m.chats[m.chatIndex]
-				return m, m.loadMessages(chat.Name, chat.ID)
+				return m, m.loadMessagesForTopic(chat.Name, chat.ID, chat.AccessHash, chat.TopicID)
 			} else {
 				// Если мы не в чате, обновляем список чатов
 				m.loading = true
@@ -407,6 +967,25 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case loadMessagesMsg:
 		m.messages = []MessageItem(msg)
 		m.loading = false
+
+		// Если загрузка была инициирована переходом из результатов поиска,
+		// прокручиваем список так, чтобы искомое сообщение оказалось видно
+		if m.jumpToID != 0 {
+			for i, item := range m.messages {
+				if item.ID == m.jumpToID {
+					m.messageScroll = i
+					break
+				}
+			}
+			m.jumpToID = 0
+		} else {
+			m.messageScroll = 0
+		}
+		return m, nil
+
+	case searchResultsMsg:
+		m.searchResults = []search.Result(msg)
+		m.searchHit = 0
 		return m, nil
 
 	case errorMsg:
@@ -416,23 +995,185 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case reloadMessagesMsg:
 		m.loading = true
-		return m, m.loadMessages(msg.chatName, msg.chatID)
+		return m, m.loadMessagesForTopic(msg.chatName, msg.chatID, msg.accessHash, msg.topicID)
+
+	case callTickMsg:
+		// Периодический опрос состояния звонка — updatePhoneCall приходит в
+		// фоновой горутине MTProto-диспетчера, поэтому перерисовываем оверлей
+		// по таймеру, а не ждём следующего пользовательского ввода
+		return m, m.pollCalls()
+
+	case notifyTickMsg:
+		// Новые сообщения копятся в notify.Dispatcher фоновой горутиной;
+		// переносим их в список чатов здесь же, без полной перезагрузки
+		// через loadChats
+		if m.notifier != nil {
+			for _, evt := range m.notifier.Poll() {
+				idx := -1
+				for i, chat := range m.chats {
+					if chat.ID == evt.ChatID && chat.TopicID == 0 {
+						idx = i
+						break
+					}
+				}
+				if idx == -1 {
+					continue
+				}
+
+				chat := m.chats[idx]
+				chat.Unread++
+				m.chats = append(m.chats[:idx], m.chats[idx+1:]...)
+				m.chats = append([]ChatItem{chat}, m.chats...)
+
+				switch {
+				case m.chatIndex == idx:
+					m.chatIndex = 0
+				case m.chatIndex < idx:
+					m.chatIndex++
+				}
+			}
+		}
+		return m, m.pollNotify()
+
+	case ffmpegInstallMsg:
+		if msg.closed {
+			return m, nil
+		}
+		if msg.progress.Err != nil {
+			m.error = fmt.Sprintf("Ошибка установки ffmpeg: %v", msg.progress.Err)
+			return m, nil
+		}
+		if msg.progress.Total > 0 && msg.progress.Done >= msg.progress.Total {
+			m.config.FfmpegPath, m.config.FfprobePath = ffmpegprovision.InstalledPaths()
+			if err := config.SaveConfig(m.config); err != nil {
+				m.error = fmt.Sprintf("ffmpeg установлен, но не сохранён в конфиге: %v", err)
+				return m, nil
+			}
+			m.error = "ffmpeg установлен"
+			return m, nil
+		}
+		m.error = fmt.Sprintf("Установка ffmpeg: %d/%d байт", msg.progress.Done, msg.progress.Total)
+		return m, listenFfmpegInstall(msg.ch)
 	}
 
 	return m, nil
 }
 
+// callTickMsg — тик перерисовки оверлея звонка, см. pollCalls
+type callTickMsg struct{}
+
+// pollCalls планирует следующую перерисовку оверлея звонка
+func (m model) pollCalls() tea.Cmd {
+	return tea.Tick(250*time.Millisecond, func(time.Time) tea.Msg {
+		return callTickMsg{}
+	})
+}
+
+// notifyTickMsg — тик проверки накопленных уведомлений, см. pollNotify
+type notifyTickMsg struct{}
+
+// pollNotify планирует следующую проверку очереди notify.Dispatcher
+func (m model) pollNotify() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return notifyTickMsg{}
+	})
+}
+
+// ffmpegInstallMsg — промежуточный отчёт о ходе автоустановки ffmpeg/ffprobe
+// (chunk5-6), см. listenFfmpegInstall. Хранит сам канал, чтобы обработчик в
+// Update мог запланировать следующее чтение, не заводя для этого отдельное
+// поле в model.
+type ffmpegInstallMsg struct {
+	progress downloader.Progress
+	closed   bool
+	ch       <-chan downloader.Progress
+}
+
+// listenFfmpegInstall читает одно сообщение из канала InstallAsync — тот же
+// приём потоковой передачи через tea.Cmd, что и у тиков
+// pollCalls/pollNotify, только управляемый значениями из канала, а не
+// таймером
+func listenFfmpegInstall(ch <-chan downloader.Progress) tea.Cmd {
+	return func() tea.Msg {
+		p, ok := <-ch
+		return ffmpegInstallMsg{progress: p, closed: !ok, ch: ch}
+	}
+}
+
+// runFfmpegCommand обрабатывает ":ffmpeg install"/":ffmpeg path <p>" —
+// команды управления бинарниками ffmpeg/ffprobe (chunk5-6), введённые в то
+// же поле сообщения, что и ":cachestats"/":cacheclear". В отличие от
+// runCacheCommand, ":ffmpeg install" — длительная операция, поэтому
+// возвращает tea.Cmd, запускающий чтение прогресса из канала, а не готовый
+// текст. handled == false означает, что это не ":ffmpeg ...", и вызывающий
+// код должен передать ввод дальше в runCacheCommand.
+func runFfmpegCommand(m model, input string) (tea.Cmd, string, bool) {
+	input = strings.TrimSpace(input)
+	if !strings.HasPrefix(input, ":ffmpeg") {
+		return nil, "", false
+	}
+
+	args := strings.Fields(input)
+	if len(args) < 2 {
+		return nil, "Использование: :ffmpeg install | :ffmpeg path <путь>", true
+	}
+
+	switch args[1] {
+	case "install":
+		ch := ffmpegprovision.InstallAsync(context.Background(), ffmpegprovision.Options{ReleaseURL: m.config.FfmpegReleaseURL})
+		return listenFfmpegInstall(ch), "Установка ffmpeg начата…", true
+	case "path":
+		if len(args) < 3 {
+			return nil, "Использование: :ffmpeg path <путь к ffmpeg>", true
+		}
+		ffmpegPath := args[2]
+		ffprobePath := strings.TrimSuffix(ffmpegPath, "ffmpeg") + "ffprobe"
+		ffmpeg.SetBinaryPath(ffmpegPath)
+		ffprobe.SetBinaryPath(ffprobePath)
+		m.config.FfmpegPath = ffmpegPath
+		m.config.FfprobePath = ffprobePath
+		if err := config.SaveConfig(m.config); err != nil {
+			return nil, fmt.Sprintf("Путь обновлён, но не сохранён в конфиге: %v", err), true
+		}
+		return nil, fmt.Sprintf("ffmpeg: используется %s", ffmpegPath), true
+	default:
+		return nil, fmt.Sprintf("Неизвестная команда: %s", input), true
+	}
+}
+
+// runCacheCommand обрабатывает ":cachestats"/":cacheclear" — команды
+// управления кешем скачанных медиафайлов (chunk5-3), введённые в поле
+// сообщения. Возвращает текст, который показывается в строке состояния так
+// же, как и обычные ошибки.
+func runCacheCommand(input string) string {
+	switch strings.TrimSpace(input) {
+	case ":cachestats":
+		count, totalBytes, maxBytes := auth.MediaCacheStats()
+		return fmt.Sprintf("Кеш медиа: %d файлов, %.1f/%.1f МиБ",
+			count, float64(totalBytes)/(1024*1024), float64(maxBytes)/(1024*1024))
+	case ":cacheclear":
+		if err := auth.ClearMediaCache(); err != nil {
+			return fmt.Sprintf("Ошибка очистки кеша медиа: %v", err)
+		}
+		return "Кеш медиа очищен"
+	default:
+		return fmt.Sprintf("Неизвестная команда: %s", input)
+	}
+}
+
 func (m model) sendMessage() tea.Cmd {
 	if m.currentChat == "" || m.input == "" {
 		return nil
 	}
 
-	// Найти ID текущего чата
-	var chatID int64
+	// Найти текущий чат (или тему форума) по имени
+	var chatID, accessHash, topicID int64
 	chatName := m.currentChat
 	for _, chat := range m.chats {
 		if chat.Name == m.currentChat {
 			chatID = chat.ID
+			accessHash = chat.AccessHash
+			topicID = chat.TopicID
 			break
 		}
 	}
@@ -441,7 +1182,13 @@ func (m model) sendMessage() tea.Cmd {
 
 	return tea.Tick(time.Millisecond*100, func(time.Time) tea.Msg {
 		if m.config.UseMTProto && m.mtproto != nil {
-			if err := m.mtproto.SendMessage(m.ctx, chatID, message); err != nil {
+			var err error
+			if topicID != 0 {
+				err = m.mtproto.SendMessageToTopic(m.ctx, chatID, accessHash, topicID, message)
+			} else {
+				err = m.mtproto.SendMessage(m.ctx, chatID, message)
+			}
+			if err != nil {
 				return errorMsg(fmt.Sprintf("Ошибка отправки: %v", err))
 			}
 		} else if m.telegram != nil {
@@ -451,7 +1198,7 @@ func (m model) sendMessage() tea.Cmd {
 		}
 
 		// После отправки загружаем сообщения заново
-		return reloadMessagesMsg{chatName: chatName, chatID: chatID}
+		return reloadMessagesMsg{chatName: chatName, chatID: chatID, accessHash: accessHash, topicID: topicID}
 	})
 }
 
@@ -460,6 +1207,10 @@ func (m model) View() string {
 		return "Загрузка..."
 	}
 
+	// Стираем все ранее размещённые через Kitty картинки — при полной
+	// перерисовке экрана их позиции больше не гарантированы
+	eraseSeq := strings.Join(stickerPlacements.EraseAll(), "")
+
 	// Проверяем, есть ли стикеры для отображения
 	var stickers []MessageItem
 	for _, msg := range m.messages {
@@ -473,9 +1224,13 @@ func (m model) View() string {
 	stickerWidth := 0
 	rightWidth := m.width - leftWidth - 1
 
-	// Если есть стикеры, выделяем место для панели стикеров
-	if len(stickers) > 0 {
-		stickerWidth = m.width / 4                          // 25% ширины для стикеров
+	// Панель результатов поиска вытесняет панель стикеров, пока активен
+	// режим поиска (chunk2-5)
+	showSearchPanel := m.searchMode && m.searchResults != nil
+
+	// Если есть стикеры или идёт поиск, выделяем место для боковой панели
+	if len(stickers) > 0 || showSearchPanel {
+		stickerWidth = m.width / 4                          // 25% ширины для боковой панели
 		rightWidth = m.width - leftWidth - stickerWidth - 2 // -2 для разделителей
 	}
 
@@ -485,9 +1240,11 @@ func (m model) View() string {
 	// правая панель - сообщения
 	rightPanel := m.renderMessages(rightWidth, m.height-0)
 
-	// панель стикеров (если есть)
+	// боковая панель: результаты поиска, иначе стикеры (если есть)
 	stickerPanel := ""
-	if len(stickers) > 0 {
+	if showSearchPanel {
+		stickerPanel = m.renderSearchPanel(stickerWidth, m.height-0)
+	} else if len(stickers) > 0 {
 		stickerPanel = m.renderStickerPanel(stickerWidth, m.height-0)
 	}
 
@@ -561,8 +1318,8 @@ func (m model) View() string {
 			rightLine = rightLine[:rightWidth]
 		}
 
-		// Обработка панели стикеров
-		if len(stickers) > 0 {
+		// Обработка боковой панели (стикеры или результаты поиска)
+		if len(stickers) > 0 || showSearchPanel {
 			visibleLen = lipgloss.Width(stickerLine)
 			if visibleLen < stickerWidth {
 				stickerLine += strings.Repeat(" ", stickerWidth-visibleLen)
@@ -577,11 +1334,48 @@ func (m model) View() string {
 
 	result := strings.Join(lines, "\n")
 
+	// Оверлей звонка (если есть активный/входящий) — поверх строки состояния
+	if overlay := m.renderCallOverlay(); overlay != "" {
+		result += "\n" + overlay
+	}
+
 	// Добавляем строку состояния
 	status := m.renderStatus()
 	result += "\n" + strings.Repeat("─", m.width) + "\n" + status
 
-	return result
+	return eraseSeq + result
+}
+
+// renderCallOverlay отображает строку состояния текущего звонка: входящий
+// вызов с подсказкой принять/отклонить, ожидание установления защищённого
+// соединения или активный разговор с эмодзи-отпечатком ключа для сверки
+func (m model) renderCallOverlay() string {
+	if m.calls == nil {
+		return ""
+	}
+	call := m.calls.Current()
+	if call == nil || call.State == calls.StateIdle || call.State == calls.StateEnded {
+		return ""
+	}
+
+	var line string
+	switch call.State {
+	case calls.StateIncoming:
+		line = fmt.Sprintf("📞 Входящий звонок от %d — a: принять, d: отклонить", call.PeerUserID)
+	case calls.StateRequesting:
+		line = fmt.Sprintf("📞 Звоним %d...", call.PeerUserID)
+	case calls.StateExchangingKeys, calls.StateRinging:
+		line = "📞 Устанавливаем защищённое соединение..."
+	case calls.StateActive:
+		duration := call.Duration().Truncate(time.Second)
+		emoji := strings.Join(call.Emoji[:], " ")
+		line = fmt.Sprintf("📞 В разговоре %s — сверьте ключ: %s — d: завершить", duration, emoji)
+	}
+	if call.LastError != nil {
+		line += fmt.Sprintf(" (ошибка: %v)", call.LastError)
+	}
+
+	return titleStyle.Render(line)
 }
 
 func (m model) renderChatList(width, height int) string {
@@ -663,11 +1457,17 @@ func (m model) renderMessages(width, height int) string {
 		if m.loading {
 			lines = append(lines, "Загрузка сообщений...")
 		} else {
-			for _, msg := range m.messages {
+			// messageScroll сдвигает начало видимого окна к сообщению, на
+			// которое перешли из результатов поиска (chunk2-5)
+			visibleMessages := m.messages
+			if m.messageScroll > 0 && m.messageScroll < len(m.messages) {
+				visibleMessages = m.messages[m.messageScroll:]
+			}
+			for _, msg := range visibleMessages {
 				// Форматируем сообщение с фиксированной шириной для времени и имени
 				timeStr := messageStyle.Render(fmt.Sprintf("%-5s", msg.Timestamp))
 				fromStr := chatStyle.Render(fmt.Sprintf("%-12s", msg.From))
-				msgText := msg.Text
+				msgText := substituteCustomEmoji(applyEntities(msg.Text, msg.Entities))
 				prefix := fmt.Sprintf("%s %s: ", timeStr, fromStr)
 				prefixWidth := lipgloss.Width(prefix)
 				availableWidth := width - prefixWidth - 2
@@ -681,27 +1481,17 @@ func (m model) renderMessages(width, height int) string {
 					stickerLine += "[стикер]"
 					lines = append(lines, stickerLine)
 
-					// ЗАКОММЕНТИРОВАНО: попытка отображения стикеров в сообщениях
-					/*
-						// Вставляем картинку через Kitty protocol (если поддерживается)
-						if isKittySupported() {
-							// Добавляем безопасную обработку
-							img := kittyImage(msg.StickerPath, availableWidth)
-							if strings.Contains(img, "[") {
-								// Если произошла ошибка, показываем fallback
-								lines = append(lines, strings.Repeat(" ", prefixWidth)+img)
-							} else {
-								// Пытаемся вывести картинку
-								lines = append(lines, strings.Repeat(" ", prefixWidth)+img)
-							}
-						} else {
-							// Если не поддерживается, выводим путь к файлу
-							lines = append(lines, strings.Repeat(" ", prefixWidth)+"Файл: "+msg.StickerPath)
-						}
-					*/
-
-					// Показываем только путь к файлу
-					lines = append(lines, strings.Repeat(" ", prefixWidth)+"Файл: "+msg.StickerPath)
+					// Вставляем картинку через выбранный графический бэкенд, если
+					// терминал хоть один поддерживает, иначе рисуем текстовый
+					// фоллбэк на полублоках, иначе — путь к файлу
+					if terminal.Detected().Name() != "none" {
+						img := renderInlineImage(msg.StickerPath, availableWidth)
+						lines = append(lines, strings.Repeat(" ", prefixWidth)+img)
+					} else if decoded, decErr := loadAndDecodeImage(msg.StickerPath); decErr == nil {
+						lines = append(lines, kitty.UnicodeBlockFallback(decoded, availableWidth))
+					} else {
+						lines = append(lines, strings.Repeat(" ", prefixWidth)+"Файл: "+msg.StickerPath)
+					}
 					continue
 				}
 
@@ -755,7 +1545,14 @@ func (m model) renderStatus() string {
 		return fmt.Sprintf("Сообщение: %s", m.input)
 	}
 
-	helpText := "q: выход, ↑↓: навигация, Enter: выбор, i: ввод сообщения, r: обновить (чаты/сообщения), s: показать стикеры, Tab: стикеры, ←→: навигация по стикерам, v: просмотр стикера"
+	if m.searchMode && m.searchResults == nil {
+		return fmt.Sprintf("Поиск: %s", m.searchInput)
+	}
+	if m.searchMode {
+		return helpStyle.Render(fmt.Sprintf("Поиск: %d результатов, n/N: далее/назад, Enter: открыть, Esc: закрыть", len(m.searchResults)))
+	}
+
+	helpText := "q: выход, ↑↓: навигация, Enter: выбор, i: ввод сообщения, c: позвонить (форум: свернуть/развернуть темы), a: принять звонок, d: отклонить/завершить звонок, /: поиск, r: обновить (чаты/сообщения), s: показать стикеры, Tab: стикеры, ←→: навигация по стикерам, v: просмотр стикера"
 	if os.Getenv("VI_TG_AUTO_KITTY") == "1" {
 		helpText += " (авто-показ включен)"
 	}
@@ -766,6 +1563,45 @@ func (m model) renderStatus() string {
 }
 
 // renderStickerPanel отображает панель со стикерами в правом нижнем углу
+// renderSearchPanel отображает панель с результатами поиска сообщений
+// вместо панели стикеров, пока активен режим поиска (chunk2-5)
+func (m model) renderSearchPanel(width, height int) string {
+	var lines []string
+
+	title := titleStyle.Render(fmt.Sprintf("Поиск (%d)", len(m.searchResults)))
+	lines = append(lines, title)
+	lines = append(lines, strings.Repeat("─", width))
+
+	if len(m.searchResults) == 0 {
+		lines = append(lines, "Ничего не найдено")
+	}
+
+	maxResults := height - 3
+	results := m.searchResults
+	if len(results) > maxResults {
+		results = results[:maxResults]
+	}
+
+	for i, r := range results {
+		line := fmt.Sprintf("%d. [%s] %s: %s", i+1, r.ChatName, r.From, r.Text)
+		if len(line) > width-2 {
+			line = line[:width-5] + "..."
+		}
+		if i == m.searchHit {
+			line = selectedStyle.Render(line)
+		} else {
+			line = chatStyle.Render(line)
+		}
+		lines = append(lines, line)
+	}
+
+	for len(lines) < height {
+		lines = append(lines, "")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
 func (m model) renderStickerPanel(width, height int) string {
 	var lines []string
 
@@ -819,57 +1655,47 @@ func (m model) renderStickerPanel(width, height int) string {
 
 		lines = append(lines, infoLine)
 
-		// Добавляем картинку стикера если поддерживается Kitty
-		// ЗАКОММЕНТИРОВАНО: попытка отображения стикеров в панели
-		/*
-			if isKittySupported() && sticker.StickerPath != "" {
-				// Определяем путь к изображению
-				var imagePath string
-				if strings.HasSuffix(sticker.StickerPath, ".webp") {
-					// Для WebM файлов ищем PNG версию
-					pngPath := strings.Replace(sticker.StickerPath, ".webp", ".png", 1)
-					if _, err := os.Stat(pngPath); err == nil {
-						imagePath = pngPath
-					} else {
-						imagePath = sticker.StickerPath
-					}
+		// Добавляем картинку стикера, если терминал поддерживает хоть один
+		// графический бэкенд, иначе — текстовый фоллбэк на полублоках, иначе —
+		// путь к файлу
+		if terminal.Detected().Name() != "none" && sticker.StickerPath != "" {
+			var imagePath string
+			if strings.HasSuffix(sticker.StickerPath, ".webp") {
+				// Для WebM файлов ищем PNG версию
+				pngPath := strings.Replace(sticker.StickerPath, ".webp", ".png", 1)
+				if _, err := os.Stat(pngPath); err == nil {
+					imagePath = pngPath
 				} else {
 					imagePath = sticker.StickerPath
 				}
+			} else {
+				imagePath = sticker.StickerPath
+			}
 
-				// Проверяем, что файл существует
-				if _, err := os.Stat(imagePath); err == nil {
-					// Вычисляем размер изображения для панели
-					imageWidth := width - 2 // Оставляем отступы
-					if imageWidth > 20 {    // Минимальная ширина
-						img := kittyImage(imagePath, imageWidth)
-						if !strings.Contains(img, "[") { // Если нет ошибки
-							lines = append(lines, img)
-						} else {
-							lines = append(lines, "  [ошибка загрузки]")
-						}
+			if _, err := os.Stat(imagePath); err == nil {
+				imageWidth := width - 2 // Оставляем отступы
+				if imageWidth > 20 {    // Минимальная ширина
+					img := renderInlineImage(imagePath, imageWidth)
+					if !strings.Contains(img, "[") { // Если нет ошибки
+						lines = append(lines, img)
 					} else {
-						lines = append(lines, "  [слишком узко]")
+						lines = append(lines, "  [ошибка загрузки]")
 					}
 				} else {
-					lines = append(lines, "  [файл не найден]")
+					lines = append(lines, "  [слишком узко]")
 				}
 			} else {
-				// Если Kitty не поддерживается, показываем путь к файлу
-				fileInfo := "  Файл: " + sticker.StickerPath
-				if len(fileInfo) > width-2 {
-					fileInfo = fileInfo[:width-5] + "..."
-				}
-				lines = append(lines, messageStyle.Render(fileInfo))
+				lines = append(lines, "  [файл не найден]")
 			}
-		*/
-
-		// Показываем только путь к файлу
-		fileInfo := "  Файл: " + sticker.StickerPath
-		if len(fileInfo) > width-2 {
-			fileInfo = fileInfo[:width-5] + "..."
+		} else if decoded, decErr := loadAndDecodeImage(sticker.StickerPath); decErr == nil {
+			lines = append(lines, kitty.UnicodeBlockFallback(decoded, width-2))
+		} else {
+			fileInfo := "  Файл: " + sticker.StickerPath
+			if len(fileInfo) > width-2 {
+				fileInfo = fileInfo[:width-5] + "..."
+			}
+			lines = append(lines, messageStyle.Render(fileInfo))
 		}
-		lines = append(lines, messageStyle.Render(fileInfo))
 
 		// Добавляем пустую строку между стикерами
 		if i < len(stickers)-1 {
@@ -888,6 +1714,112 @@ func (m model) renderStickerPanel(width, height int) string {
 	return strings.Join(lines, "\n")
 }
 
+// customEmojiPlaceholder находит плейсхолдеры "[ce:<id>]", которыми
+// customemoji.ParseEntities заменяет диапазоны messageEntityCustomEmoji в тексте
+var customEmojiPlaceholder = regexp.MustCompile(`\[ce:(\d+)\]`)
+
+// applyEntities оборачивает диапазоны text соответствующими lipgloss-стилями
+// по сущностям форматирования (bold, italic, spoiler, code и т.д.),
+// разобранным customemoji.ParseEntities. Смещения заданы в байтах того же
+// text, что и [ce:<id>]-плейсхолдеры — substituteCustomEmoji нужно вызывать
+// после applyEntities, иначе смещения "поплывут"
+func applyEntities(text string, entities []customemoji.Entity) string {
+	if len(entities) == 0 {
+		return text
+	}
+
+	sorted := make([]customemoji.Entity, len(entities))
+	copy(sorted, entities)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+
+	var b strings.Builder
+	pos := 0
+	for _, e := range sorted {
+		if e.Offset < pos || e.Offset+e.Length > len(text) {
+			continue // пропускаем пересекающиеся или повреждённые диапазоны
+		}
+		b.WriteString(text[pos:e.Offset])
+		b.WriteString(entityStyle(e.Type).Render(text[e.Offset : e.Offset+e.Length]))
+		pos = e.Offset + e.Length
+	}
+	b.WriteString(text[pos:])
+	return b.String()
+}
+
+// entityStyle возвращает lipgloss-стиль для одного типа сущности форматирования
+func entityStyle(typ string) lipgloss.Style {
+	switch typ {
+	case "bold":
+		return lipgloss.NewStyle().Bold(true)
+	case "italic":
+		return lipgloss.NewStyle().Italic(true)
+	case "underline":
+		return lipgloss.NewStyle().Underline(true)
+	case "strikethrough":
+		return lipgloss.NewStyle().Strikethrough(true)
+	case "spoiler":
+		// Скрываем текст, делая его того же цвета что и фон, пока не
+		// появится отдельный режим "раскрытия" спойлеров
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Background(lipgloss.Color("240"))
+	case "code", "pre":
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("215"))
+	default:
+		return lipgloss.NewStyle()
+	}
+}
+
+// substituteCustomEmoji заменяет плейсхолдеры "[ce:<id>]" на inline-картинку
+// через Kitty graphics protocol (если доступна и терминал поддерживает) или
+// на fallback-эмодзи, сохранённые customemoji.Default при разборе сообщения
+func substituteCustomEmoji(text string) string {
+	if !strings.Contains(text, "[ce:") {
+		return text
+	}
+	return customEmojiPlaceholder.ReplaceAllStringFunc(text, func(match string) string {
+		groups := customEmojiPlaceholder.FindStringSubmatch(match)
+		id, err := strconv.ParseInt(groups[1], 10, 64)
+		if err != nil {
+			return match
+		}
+		return renderCustomEmoji(id)
+	})
+}
+
+// renderCustomEmoji отображает один кастомный эмодзи по его document ID:
+// первым кадром анимации через Kitty, если терминал поддерживает и .tgs уже
+// скачан, иначе — сохранённым fallback-эмодзи, иначе — самим плейсхолдером
+func renderCustomEmoji(id int64) string {
+	path, fallback, ok := customemoji.Default.Lookup(id)
+	if !ok {
+		return fmt.Sprintf("[ce:%d]", id)
+	}
+
+	if isKittySupported() && path != "" {
+		if frames, err := kitty.DecodeTGSFrames(context.Background(), path, 32, 32); err == nil && len(frames) > 0 {
+			seq, placementID := kitty.Transmit(frames[0], kitty.Options{Columns: 1, Rows: 1})
+			erase := stickerPlacements.Track(path, placementID)
+			return erase + seq
+		}
+	}
+
+	if fallback != "" {
+		return fallback
+	}
+	return fmt.Sprintf("[ce:%d]", id)
+}
+
+// animationSupported проверяет, стоит ли пытаться декодировать и проигрывать
+// анимацию стикера через Kitty animation frames API — отключается через
+// VI_TG_NO_ANIMATION по тому же принципу, что и VI_TG_NO_KITTY/VI_TG_NO_INLINE,
+// чтобы терминалы без поддержки анимации (или медленные SSH-сессии) получали
+// единственный статичный кадр вместо покадровой передачи (chunk3-1)
+func animationSupported() bool {
+	if os.Getenv("VI_TG_NO_ANIMATION") == "1" {
+		return false
+	}
+	return isKittySupported()
+}
+
 // isKittySupported проверяет, поддерживает ли терминал Kitty graphics protocol
 func isKittySupported() bool {
 	// Проверяем, не отключен ли Kitty через переменную окружения
@@ -908,23 +1840,16 @@ func isKittySupported() bool {
 	return isKitty
 }
 
-// checkImageFormat проверяет формат изображения по заголовку файла
-func checkImageFormat(data []byte) string {
+// nativeImageFormat определяет формат изображения по заголовку файла среди
+// трёх форматов, которые умеет декодировать сам процесс (без ffmpeg) —
+// png/jpeg/webp. Используется только текстовым фоллбэком на полублоках,
+// когда Kitty graphics protocol недоступен; для всего остального (включая
+// распознавание webm и прочих контейнеров) теперь отвечает media.Probe
+func nativeImageFormat(data []byte) string {
 	if len(data) < 4 {
 		return "unknown"
 	}
 
-	// WebM файлы начинаются с EBML header (1A 45 DF A3) и содержат "webm"
-	if len(data) >= 4 && data[0] == 0x1A && data[1] == 0x45 && data[2] == 0xDF && data[3] == 0xA3 {
-		// Проверяем, что это WebM (анимированный стикер)
-		if len(data) >= 20 {
-			dataStr := string(data[:50]) // Проверяем первые 50 байт
-			if strings.Contains(dataStr, "webm") {
-				return "webm"
-			}
-		}
-	}
-
 	// WebP файлы начинаются с "RIFF" и содержат "WEBP"
 	if len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP" {
 		return "webp"
@@ -943,50 +1868,30 @@ func checkImageFormat(data []byte) string {
 	return "unknown"
 }
 
-// convertWebmToPng конвертирует WebM файл в PNG с помощью ffmpeg
-func convertWebmToPng(webmPath string) (string, error) {
-	// Создаем путь для png файла
-	pngPath := strings.Replace(webmPath, ".webp", ".png", 1)
-
-	// Проверяем, не создан ли уже png файл
-	if _, err := os.Stat(pngPath); err == nil {
-		return pngPath, nil
-	}
-
-	// Проверяем, что ffmpeg доступен
-	if _, err := exec.LookPath("ffmpeg"); err != nil {
-		return "", fmt.Errorf("ffmpeg не найден: %v", err)
-	}
-
-	// Конвертируем WebM в PNG (первый кадр) с таймаутом
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", webmPath, "-vframes", "1", "-f", "image2", pngPath, "-y")
-
-	// Подавляем вывод ffmpeg
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-
-	fmt.Printf("DEBUG: Запуск ffmpeg для конвертации %s\n", webmPath)
-
-	if err := cmd.Run(); err != nil {
-		// Удаляем частично созданный файл при ошибке
-		os.Remove(pngPath)
-		if ctx.Err() == context.DeadlineExceeded {
-			return "", fmt.Errorf("таймаут конвертации ffmpeg (>10s)")
-		}
-		return "", fmt.Errorf("ошибка конвертации ffmpeg: %v", err)
+// decodeImageByFormat декодирует данные изображения в соответствии с
+// форматом, определённым nativeImageFormat
+func decodeImageByFormat(data []byte, format string) (image.Image, error) {
+	switch format {
+	case "png":
+		return png.Decode(bytes.NewReader(data))
+	case "jpeg":
+		return jpeg.Decode(bytes.NewReader(data))
+	case "webp":
+		return webp.Decode(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("неподдерживаемый формат изображения: %s", format)
 	}
+}
 
-	fmt.Printf("DEBUG: Конвертация завершена успешно\n")
-
-	// Проверяем, что файл создался
-	if _, err := os.Stat(pngPath); err != nil {
-		return "", fmt.Errorf("PNG файл не создался: %v", err)
+// loadAndDecodeImage читает файл изображения с диска и декодирует его,
+// определяя формат по содержимому — используется для текстового фоллбэка,
+// когда Kitty graphics protocol недоступен
+func loadAndDecodeImage(path string) (image.Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
 	}
-
-	return pngPath, nil
+	return decodeImageByFormat(data, nativeImageFormat(data))
 }
 
 // processWebMAsync асинхронно обрабатывает WebM файл
@@ -994,14 +1899,14 @@ func processWebMAsync(data []byte, path string) {
 	fmt.Printf("DEBUG: Асинхронная обработка WebM файла %s\n", path)
 
 	// Проверяем, не слишком ли большой файл
-	if len(data) > 1024*1024 { // 1MB
+	if len(data) > maxStickerFileSize {
 		fmt.Printf("DEBUG: Файл слишком большой (%d байт), пропускаем\n", len(data))
 		return
 	}
 
-	pngPath, err := convertWebmToPng(path)
+	pngPath, err := media.Thumbnail(context.Background(), path, media.ThumbnailOptions{Width: 160, Height: 160})
 	if err != nil {
-		fmt.Printf("DEBUG: Ошибка конвертации WebM в PNG: %v\n", err)
+		fmt.Printf("DEBUG: Ошибка построения превью WebM: %v\n", err)
 		return
 	}
 
@@ -1013,6 +1918,27 @@ func processWebMAsync(data []byte, path string) {
 			fmt.Printf("DEBUG: Не удалось открыть новый Kitty: %v\n", err)
 		}
 	}
+
+	// Встроенное воспроизведение анимации через Kitty animation frames API —
+	// при отсутствии поддержки анимации или ошибке декодирования откатываемся
+	// на уже сконвертированный статичный pngPath (chunk3-1)
+	if animationSupported() && os.Getenv("VI_TG_NO_INLINE") != "1" {
+		const frameWidth, frameHeight = 160, 160
+		frames, err := kitty.DecodeWebMFrames(context.Background(), path, frameWidth, frameHeight, 0)
+		if err != nil {
+			fmt.Printf("DEBUG: Не удалось декодировать кадры WebM для Kitty, показываем статичный кадр: %v\n", err)
+			if data, readErr := os.ReadFile(pngPath); readErr == nil {
+				seq, placementID := kitty.Transmit(data, kitty.Options{})
+				erase := stickerPlacements.Track(path, placementID)
+				fmt.Print(erase + seq)
+			}
+			return
+		}
+
+		seq, placementID := kitty.TransmitAnimation(frames)
+		erase := stickerPlacements.Track(path, placementID)
+		fmt.Print(erase + seq)
+	}
 }
 
 // showStickerInNewKitty открывает новый Kitty терминал и показывает стикер
@@ -1041,17 +1967,11 @@ func showStickersInNewKitty(messages []MessageItem) {
 
 	for _, msg := range messages {
 		if msg.Type == "sticker" && msg.StickerPath != "" && stickersShown < maxStickers {
-			// Проверяем, есть ли PNG версия файла
-			var imagePath string
-			if strings.HasSuffix(msg.StickerPath, ".webp") {
-				// Для WebM файлов ищем PNG версию
-				pngPath := strings.Replace(msg.StickerPath, ".webp", ".png", 1)
-				if _, err := os.Stat(pngPath); err == nil {
-					imagePath = pngPath
-				} else {
-					imagePath = msg.StickerPath
-				}
-			} else {
+			// resolveStickerPath конвертирует webm в PNG-превью не более
+			// одного раза за процесс (stickerCache, chunk3-3); для прочих
+			// форматов возвращает исходный путь без изменений
+			imagePath, err := resolveStickerPath(msg.StickerPath, 40)
+			if err != nil {
 				imagePath = msg.StickerPath
 			}
 
@@ -1076,8 +1996,11 @@ func showStickersInNewKitty(messages []MessageItem) {
 	}
 }
 
-// kittyImage возвращает escape-последовательность для вывода картинки через Kitty protocol
-func kittyImage(path string, width int) string {
+// renderInlineImage возвращает escape-последовательность для встроенного
+// показа картинки выбранным в рантайме terminal.GraphicsBackend (Kitty, Sixel
+// или iTerm2 — terminal.Detected(), chunk3-4; VI_TG_GRAPHICS переопределяет
+// автоопределение вручную)
+func renderInlineImage(path string, width int) string {
 	// Проверяем существование файла
 	if _, err := os.Stat(path); err != nil {
 		return "[файл стикера не найден]"
@@ -1102,8 +2025,11 @@ func kittyImage(path string, width int) string {
 		}
 		data := res.data
 
-		// Проверяем размер файла (не более 500KB для безопасности)
-		if len(data) > 500*1024 {
+		// Проверяем размер файла — встроенный показ Kitty передаёт данные
+		// чанками (kitty.TransmitFile, chunk3-5), так что гвоздь тут не
+		// столько сам размер, сколько защита от откровенно битых/чужеродных
+		// файлов под видом стикера
+		if len(data) > maxStickerFileSize {
 			return fmt.Sprintf("[стикер слишком большой: %d байт]", len(data))
 		}
 
@@ -1112,64 +2038,76 @@ func kittyImage(path string, width int) string {
 			return "[неверный формат стикера]"
 		}
 
-		// Проверяем формат файла
-		format := checkImageFormat(data)
-		fmt.Printf("DEBUG: Формат файла %s: %s\n", path, format)
-
-		return processImageDataWithSize(data, path, format, width)
+		return processImageDataWithSize(data, path, width)
 
 	case <-time.After(5 * time.Second):
 		return "[таймаут чтения стикера]"
 	}
 }
 
-// processImageDataWithSize обрабатывает данные изображения с указанным размером
-func processImageDataWithSize(data []byte, path string, format string, width int) string {
-	// Для WebM файлов запускаем обработку в фоне
-	if format == "webm" {
+// processImageDataWithSize обрабатывает данные изображения с указанным
+// размером. Формат и кодек теперь определяет media.Probe (ffprobe) вместо
+// сниффинга по магическим байтам — это открывает дорогу к mp4/mkv/gif/apng
+// стикерам и видео-кружочкам, а не только webm/webp/png/jpeg
+func processImageDataWithSize(data []byte, path string, width int) string {
+	ctx := context.Background()
+
+	info, probeErr := media.Probe(ctx, path)
+	if probeErr == nil && strings.Contains(info.Container, "webm") {
 		go func() {
 			processWebMAsync(data, path)
 		}()
 		return fmt.Sprintf("🎬 [WebM стикер обрабатывается...]")
 	}
 
-	switch format {
-	case "webp", "png", "jpeg":
-		// Статические изображения
-		fmt.Printf("DEBUG: Обрабатываем %s изображение размером %d байт\n", format, len(data))
+	// Статические изображения и прочие видео-контейнеры с превью
+	fmt.Printf("DEBUG: Обрабатываем %s размером %d байт\n", path, len(data))
 
-		// Проверяем размер файла
-		if len(data) > 1024*1024 { // 1MB
-			fmt.Printf("DEBUG: Файл слишком большой (%d байт), показываем только путь\n", len(data))
-			return fmt.Sprintf("[%s изображение: %s]", format, path)
-		}
+	// Показываем в новом Kitty терминале асинхронно (только если включено)
+	if os.Getenv("VI_TG_AUTO_KITTY") == "1" {
+		go func() {
+			if err := showStickerInNewKitty(path); err != nil {
+				fmt.Printf("DEBUG: Не удалось открыть новый Kitty: %v\n", err)
+			}
+		}()
+	}
 
-		// Показываем в новом Kitty терминале асинхронно (только если включено)
-		if os.Getenv("VI_TG_AUTO_KITTY") == "1" {
-			go func() {
-				if err := showStickerInNewKitty(path); err != nil {
-					fmt.Printf("DEBUG: Не удалось открыть новый Kitty: %v\n", err)
-				}
-			}()
+	// Отображаем встроенно через выбранный графический бэкенд (Kitty, Sixel
+	// или iTerm2 — terminal.Detected(), chunk3-4) — конвертация и кодирование
+	// идут через stickerCache, так что повторные рендеры (скролл истории)
+	// переиспользуют уже готовый payload (chunk3-3)
+	if terminal.Detected().Name() != "none" && os.Getenv("VI_TG_NO_INLINE") != "1" {
+		seq, placementID, backend, err := inlinePayload(path, width)
+		switch {
+		case err == nil:
+			if backend == "kitty" {
+				erase := stickerPlacements.Track(path, placementID)
+				return erase + seq
+			}
+			return seq
+		case errors.Is(err, media.ErrUnsupportedCodec):
+			fmt.Printf("DEBUG: %s: неподдерживаемый кодек для превью %s: %v\n", backend, path, err)
+		default:
+			fmt.Printf("DEBUG: %s: %v\n", backend, err)
 		}
+	}
 
-		// Отображаем встроенно через Kitty graphics protocol
-		// ЗАКОММЕНТИРОВАНО: попытка отображения стикеров в том же окне терминала
-		/*
-			if isKittySupported() && os.Getenv("VI_TG_NO_INLINE") != "1" {
-				// Кодируем данные в base64
-				encoded := fmt.Sprintf("\033_Ga=T,f=100,s=%d,v=%d;S=%d;a=%s\033\\",
-					len(data), len(data), width, base64.StdEncoding.EncodeToString(data))
-				return encoded
-			}
-		*/
+	// Если терминал не поддерживает ни один графический бэкенд (или
+	// построение превью не удалось), показываем текстовый фоллбэк на основе
+	// полублоков для нативно декодируемых форматов. В отличие от Kitty, этот
+	// путь декодирует data целиком в памяти (image.Image из пикселей), так
+	// что ограничение размера тут сохраняется
+	if terminal.Detected().Name() == "none" && len(data) <= maxStickerFileSize {
+		format := nativeImageFormat(data)
+		if img, decErr := decodeImageByFormat(data, format); decErr == nil {
+			return kitty.UnicodeBlockFallback(img, width)
+		}
+	}
 
-		// Если Kitty не поддерживается, показываем информацию
-		fmt.Printf("DEBUG: %s изображение готово для отображения\n", format)
-		return fmt.Sprintf("🖼️ [%s стикер: %s]", format, path)
-	default:
-		return "[неизвестный формат изображения]"
+	if info.VideoCodec != "" {
+		return fmt.Sprintf("🖼️ [%s стикер: %s]", info.VideoCodec, path)
 	}
+	return fmt.Sprintf("[не удалось определить формат: %s]", path)
 }
 
 // showStickerFullscreen показывает стикер в полноэкранном режиме
@@ -1178,17 +2116,10 @@ func showStickerFullscreen(sticker *MessageItem) error {
 		return fmt.Errorf("стикер не найден")
 	}
 
-	// Определяем путь к изображению
-	var imagePath string
-	if strings.HasSuffix(sticker.StickerPath, ".webp") {
-		// Для WebM файлов ищем PNG версию
-		pngPath := strings.Replace(sticker.StickerPath, ".webp", ".png", 1)
-		if _, err := os.Stat(pngPath); err == nil {
-			imagePath = pngPath
-		} else {
-			imagePath = sticker.StickerPath
-		}
-	} else {
+	// Определяем путь к изображению — resolveStickerPath конвертирует webm
+	// в PNG-превью не более одного раза за процесс (stickerCache, chunk3-3)
+	imagePath, err := resolveStickerPath(sticker.StickerPath, 40)
+	if err != nil {
 		imagePath = sticker.StickerPath
 	}
 
@@ -1211,37 +2142,38 @@ func showStickerFullscreen(sticker *MessageItem) error {
 	}
 	fmt.Printf("\n")
 
-	// Показываем стикер через Kitty graphics
-	if isKittySupported() {
-		// Читаем файл
-		data, err := os.ReadFile(imagePath)
-		if err != nil {
-			return fmt.Errorf("ошибка чтения файла: %v", err)
-		}
-
-		// Проверяем размер
-		if len(data) > 1024*1024 { // 1MB
-			return fmt.Errorf("файл слишком большой: %d байт", len(data))
-		}
-
-		// Проверяем формат
-		format := checkImageFormat(data)
-		if format == "webm" {
-			// Для WebM файлов конвертируем в PNG
-			pngPath, err := convertWebmToPng(imagePath)
+	// Показываем стикер через выбранный графический бэкенд
+	backend := terminal.Detected()
+	if backend.Name() != "none" {
+		var seq string
+		if backend.Name() == "kitty" {
+			// Kitty читает и кодирует файл чанками прямо с диска
+			// (kitty.TransmitFile, chunk3-5), так что размер тут не ограничен
+			var err error
+			seq, _, err = kitty.TransmitFile(imagePath, kitty.Options{})
+			if err != nil {
+				return fmt.Errorf("ошибка рендеринга стикера: %v", err)
+			}
+		} else {
+			// Остальные бэкенды кодируют данные целиком в памяти
+			data, err := os.ReadFile(imagePath)
 			if err != nil {
-				return fmt.Errorf("ошибка конвертации WebM: %v", err)
+				return fmt.Errorf("ошибка чтения файла: %v", err)
+			}
+			if len(data) > maxStickerFileSize {
+				return fmt.Errorf("файл слишком большой: %d байт", len(data))
 			}
-			data, err = os.ReadFile(pngPath)
+
+			seq, _, err = backend.Render(data, terminal.RenderOptions{})
 			if err != nil {
-				return fmt.Errorf("ошибка чтения PNG: %v", err)
+				return fmt.Errorf("ошибка рендеринга стикера: %v", err)
 			}
 		}
 
-		// Выводим через Kitty graphics
-		encoded := fmt.Sprintf("\033_Ga=T,f=100,s=%d,v=%d;a=%s\033\\",
-			len(data), len(data), base64.StdEncoding.EncodeToString(data))
-		fmt.Print(encoded)
+		// Оборачиваем в tmux DCS passthrough, если запущены под tmux — иначе
+		// tmux перехватит графическую escape-последовательность вместо
+		// передачи её терминалу
+		fmt.Print(terminal.WrapTmuxPassthrough(seq))
 
 		fmt.Printf("\n\n")
 		fmt.Printf("Нажмите любую клавишу для возврата...\n")
@@ -1252,8 +2184,8 @@ func showStickerFullscreen(sticker *MessageItem) error {
 
 		return nil
 	} else {
-		// Если Kitty не поддерживается, показываем путь
-		fmt.Printf("Kitty не поддерживается. Файл: %s\n", imagePath)
+		// Если ни один графический бэкенд не поддерживается, показываем путь
+		fmt.Printf("Графический вывод не поддерживается. Файл: %s\n", imagePath)
 		fmt.Printf("Нажмите любую клавишу для возврата...\n")
 
 		var buf [1]byte
@@ -1263,64 +2195,61 @@ func showStickerFullscreen(sticker *MessageItem) error {
 	}
 }
 
-// processImageData обрабатывает данные изображения
-func processImageData(data []byte, path string, format string) string {
-	// Для WebM файлов запускаем обработку в фоне
-	if format == "webm" {
-		go func() {
-			processWebMAsync(data, path)
-		}()
-		return fmt.Sprintf("🎬 [WebM стикер обрабатывается...]")
+// runLogin реализует подкоманду "vi-tg login": запрашивает токен бота и
+// номер телефона и сохраняет их в internal/secrets вместо открытого текста
+// config.yml (chunk6-5)
+func runLogin() error {
+	reader := bufio.NewReader(os.Stdin)
+
+	fmt.Print("Номер телефона (Enter, чтобы пропустить): ")
+	phone, _ := reader.ReadString('\n')
+	phone = strings.TrimSpace(phone)
+	if phone != "" {
+		if err := secrets.Set("phone_number", phone); err != nil {
+			return fmt.Errorf("ошибка сохранения номера телефона: %w", err)
+		}
 	}
 
-	switch format {
-	case "webp", "png", "jpeg":
-		// Статические изображения
-		fmt.Printf("DEBUG: Обрабатываем %s изображение размером %d байт\n", format, len(data))
-
-		// Проверяем размер файла
-		if len(data) > 1024*1024 { // 1MB
-			fmt.Printf("DEBUG: Файл слишком большой (%d байт), показываем только путь\n", len(data))
-			return fmt.Sprintf("[%s изображение: %s]", format, path)
+	fmt.Print("Токен Telegram-бота (Enter, чтобы пропустить): ")
+	token, _ := reader.ReadString('\n')
+	token = strings.TrimSpace(token)
+	if token != "" {
+		if err := secrets.Set("telegram_token", token); err != nil {
+			return fmt.Errorf("ошибка сохранения токена бота: %w", err)
 		}
+	}
 
-		// Показываем в новом Kitty терминале асинхронно (только если включено)
-		if os.Getenv("VI_TG_AUTO_KITTY") == "1" {
-			go func() {
-				if err := showStickerInNewKitty(path); err != nil {
-					fmt.Printf("DEBUG: Не удалось открыть новый Kitty: %v\n", err)
-				}
-			}()
-		}
+	fmt.Println("Секреты сохранены в защищённое хранилище")
+	return nil
+}
 
-		// Отображаем встроенно через Kitty graphics protocol
-		if isKittySupported() {
-			// Кодируем данные в base64
-			encoded := fmt.Sprintf("\033_Ga=T,f=100,s=%d,v=%d;a=%s\033\\",
-				len(data), len(data), base64.StdEncoding.EncodeToString(data))
-			return encoded
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "login" {
+		if err := runLogin(); err != nil {
+			log.Fatal(err)
 		}
-
-		// Если Kitty не поддерживается, показываем информацию
-		fmt.Printf("DEBUG: %s изображение готово для отображения\n", format)
-		return fmt.Sprintf("🖼️ [%s стикер: %s]", format, path)
-	default:
-		return "[неизвестный формат изображения]"
+		return
 	}
-}
 
-func main() {
+	m := initialModel()
 	for {
-		p := tea.NewProgram(initialModel(), tea.WithAltScreen())
-		m, err := p.Run()
+		p := tea.NewProgram(m, tea.WithAltScreen())
+		result, err := p.Run()
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		// Проверяем, нужно ли показать стикер
-		if model, ok := m.(model); ok && model.selectedSticker != nil {
-			// Показываем стикер в полноэкранном режиме
-			if err := showStickerFullscreen(model.selectedSticker); err != nil {
+		var ok bool
+		m, ok = result.(model)
+		if !ok {
+			break
+		}
+
+		// Показ стикера в полноэкранном режиме только переключает терминал в
+		// другой режим и не трогает телеграм-клиент/стор — переиспользуем уже
+		// работающую модель вместо того, чтобы пересоздавать её с нуля
+		if m.selectedSticker != nil {
+			if err := showStickerFullscreen(m.selectedSticker); err != nil {
 				fmt.Printf("Ошибка показа стикера: %v\n", err)
 				fmt.Printf("Нажмите любую клавишу для продолжения...\n")
 				var buf [1]byte
@@ -1328,8 +2257,8 @@ func main() {
 			}
 
 			// Очищаем выбранный стикер и продолжаем работу
-			model.selectedSticker = nil
-			model.stickerPanelIndex = 0
+			m.selectedSticker = nil
+			m.stickerPanelIndex = 0
 
 			// Продолжаем цикл (перезапускаем TUI)
 			continue
@@ -1338,4 +2267,13 @@ func main() {
 		// Если не нужно показывать стикер, выходим
 		break
 	}
+
+	// Останавливаем телеграм-клиент (закрывает store/localIndex, снимает
+	// webhook) и менеджер звонков только на реальном выходе из программы
+	if m.telegram != nil {
+		m.telegram.Stop(m.ctx)
+	}
+	if m.calls != nil {
+		m.calls.Close()
+	}
 }
@@ -0,0 +1,194 @@
+// Package media реализует ffprobe-driven определение формата и построение
+// превью для стикеров и видео-сообщений (chunk3-2): единый вызов
+// ffprobe -show_streams -show_format даёт контейнер, кодек, размеры и
+// длительность, и дальше конвейер выбирает ffmpeg-пайплайн по кодеку вместо
+// сниффинга по магическим байтам и набора точечных convertXToY-функций.
+// Анимированные .tgs-стикеры (gzip Lottie JSON) ffprobe не распознаёт и
+// продолжают обрабатываться отдельно пакетом kitty через внешний
+// Lottie-рендерер
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrProbeFailed возвращается, когда ffprobe не смог разобрать файл: не
+// найден на диске, битый контейнер или ffprobe отсутствует в PATH
+var ErrProbeFailed = errors.New("media: ffprobe не смог разобрать файл")
+
+// ErrUnsupportedCodec возвращается, когда файл успешно разобран, но его
+// видеокодек не входит в список поддерживаемых конвейером превью
+var ErrUnsupportedCodec = errors.New("media: кодек не поддерживается конвейером превью")
+
+// Info — сведения о файле, извлечённые Probe из ffprobe -show_streams -show_format
+type Info struct {
+	Container  string // format_name, например "matroska,webm" или "image2"
+	VideoCodec string // codec_name видеодорожки (или единственного кадра изображения), пусто если видео нет
+	AudioCodec string // codec_name аудиодорожки, пусто если звука нет
+	Width      int
+	Height     int
+	PixFmt     string
+	Duration   time.Duration
+}
+
+type probeStream struct {
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	PixFmt    string `json:"pix_fmt"`
+}
+
+type probeFormat struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+}
+
+type probeOutput struct {
+	Streams []probeStream `json:"streams"`
+	Format  probeFormat   `json:"format"`
+}
+
+// Probe запускает ffprobe на файле по пути на диске (данные уже скачаны) и
+// возвращает сведения о контейнере и дорожках
+func Probe(ctx context.Context, path string) (Info, error) {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return Info{}, fmt.Errorf("%w: ffprobe не найден: %v", ErrProbeFailed, err)
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(probeCtx, "ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_streams",
+		"-show_format",
+		path,
+	)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return Info{}, fmt.Errorf("%w: %v", ErrProbeFailed, err)
+	}
+
+	var parsed probeOutput
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return Info{}, fmt.Errorf("%w: ошибка разбора вывода ffprobe: %v", ErrProbeFailed, err)
+	}
+
+	info := Info{Container: parsed.Format.FormatName}
+	if seconds, err := strconv.ParseFloat(parsed.Format.Duration, 64); err == nil {
+		info.Duration = time.Duration(seconds * float64(time.Second))
+	}
+
+	for _, s := range parsed.Streams {
+		switch s.CodecType {
+		case "video":
+			if info.VideoCodec == "" {
+				info.VideoCodec = s.CodecName
+				info.Width = s.Width
+				info.Height = s.Height
+				info.PixFmt = s.PixFmt
+			}
+		case "audio":
+			if info.AudioCodec == "" {
+				info.AudioCodec = s.CodecName
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// supportedVideoCodecs перечисляет кодеки, для которых конвейер умеет
+// строить превью через ffmpeg: vp8/vp9 (анимированные WebM-стикеры),
+// h264/hevc (видеосообщения и кружочки), gif/apng (покадровая анимация) и
+// mjpeg/png/webp (статичные изображения, ffmpeg видит их как один кадр "видео")
+var supportedVideoCodecs = map[string]bool{
+	"vp8": true, "vp9": true, "h264": true, "hevc": true,
+	"gif": true, "apng": true, "mjpeg": true, "png": true, "webp": true,
+}
+
+// ThumbnailOptions управляет размером и кадрированием превью
+type ThumbnailOptions struct {
+	Width, Height int
+	// Pad — добивать ли letterbox-полями до точного Width x Height; без
+	// этого флага превью лишь вписывается в рамку с сохранением пропорций
+	Pad bool
+}
+
+// scaleFilter собирает ffmpeg -vf под нужный размер, сохраняя пропорции
+// через force_original_aspect_ratio=decrease и добивая поля только если
+// вызывающий код запросил точный размер коробки (opts.Pad)
+func scaleFilter(opts ThumbnailOptions) string {
+	w, h := opts.Width, opts.Height
+	if w <= 0 {
+		w = 320
+	}
+	if h <= 0 {
+		h = 320
+	}
+
+	scale := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", w, h)
+	if !opts.Pad {
+		return scale
+	}
+	return fmt.Sprintf("%s,pad=%d:%d:(ow-iw)/2:(oh-ih)/2", scale, w, h)
+}
+
+// Thumbnail строит PNG-превью первого кадра файла и кеширует его рядом с
+// исходником (<путь без расширения>.png), возвращая путь к готовому файлу.
+// Перед кодированием вызывает Probe и отказывает с ErrUnsupportedCodec, если
+// видеодорожки нет или её кодек не входит в supportedVideoCodecs
+func Thumbnail(ctx context.Context, path string, opts ThumbnailOptions) (string, error) {
+	pngPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".png"
+	if _, err := os.Stat(pngPath); err == nil {
+		return pngPath, nil
+	}
+
+	info, err := Probe(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if info.VideoCodec == "" || !supportedVideoCodecs[info.VideoCodec] {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedCodec, info.VideoCodec)
+	}
+
+	if _, lookErr := exec.LookPath("ffmpeg"); lookErr != nil {
+		return "", fmt.Errorf("%w: ffmpeg не найден: %v", ErrProbeFailed, lookErr)
+	}
+
+	cmdCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(cmdCtx, "ffmpeg",
+		"-i", path,
+		"-vf", scaleFilter(opts),
+		"-frames:v", "1",
+		"-f", "image2",
+		pngPath, "-y",
+	)
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+
+	if runErr := cmd.Run(); runErr != nil {
+		os.Remove(pngPath)
+		return "", fmt.Errorf("ошибка построения превью через ffmpeg: %w", runErr)
+	}
+
+	if _, statErr := os.Stat(pngPath); statErr != nil {
+		return "", fmt.Errorf("ffmpeg не создал превью для %s", path)
+	}
+
+	return pngPath, nil
+}
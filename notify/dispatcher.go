@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"sync"
+	"time"
+
+	"vi-tg/auth"
+	"vi-tg/config"
+)
+
+// ChatEvent сообщает, что в чате появилось новое сообщение — main.go вычитывает
+// такие события по таймеру, чтобы подвинуть чат в начало списка и увеличить
+// счётчик непрочитанных без полной перезагрузки через loadChats
+type ChatEvent struct {
+	ChatID int64
+}
+
+// Dispatcher подписывается на auth.EventChatNewMessage, отправляет десктопные
+// уведомления для неприглушённых чатов через Backend и копит ChatEvent для
+// main.go — тот же приём, что calls.Manager использует для updatePhoneCall
+type Dispatcher struct {
+	cfg     *config.Config
+	backend Backend
+
+	mu      sync.Mutex
+	pending []ChatEvent
+}
+
+// NewDispatcher создаёт диспетчер уведомлений и сразу подписывает его на
+// шину событий пакета auth
+func NewDispatcher(cfg *config.Config, backend Backend) *Dispatcher {
+	d := &Dispatcher{cfg: cfg, backend: backend}
+
+	ch, _ := auth.Events().Subscribe()
+	go func() {
+		for evt := range ch {
+			if evt.Type != auth.EventChatNewMessage {
+				continue
+			}
+			d.handle(evt.Data)
+		}
+	}()
+
+	return d
+}
+
+func (d *Dispatcher) handle(data interface{}) {
+	fields, ok := data.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	chatID, _ := fields["chat_id"].(int64)
+	text, _ := fields["text"].(string)
+	from, _ := fields["from"].(string)
+	mention, _ := fields["mention"].(bool)
+
+	n := Notification{ChatID: chatID, ChatName: from, From: from, Text: text, Mention: mention}
+
+	rule, muted := d.cfg.MutedChats[chatID]
+	if ShouldNotify(rule, muted, n, time.Now()) {
+		d.backend.Notify(n)
+	}
+
+	d.mu.Lock()
+	d.pending = append(d.pending, ChatEvent{ChatID: chatID})
+	d.mu.Unlock()
+}
+
+// Poll возвращает и очищает накопленные с прошлого вызова события о новых
+// сообщениях
+func (d *Dispatcher) Poll() []ChatEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.pending) == 0 {
+		return nil
+	}
+
+	events := d.pending
+	d.pending = nil
+	return events
+}
@@ -0,0 +1,25 @@
+package notify
+
+import (
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"vi-tg/config"
+)
+
+// FromTelegramSettings конвертирует account.getNotifySettings в MuteRule —
+// используется как начальный импорт приглушений из официального клиента
+// Telegram (chunk2-6). Возвращает muted=false, если чат не приглушён и
+// импортировать нечего
+func FromTelegramSettings(settings *tg.PeerNotifySettings) (rule config.MuteRule, muted bool) {
+	if silent, ok := settings.GetSilent(); ok && silent {
+		return config.MuteRule{}, true
+	}
+
+	if muteUntil, ok := settings.GetMuteUntil(); ok && int64(muteUntil) > time.Now().Unix() {
+		return config.MuteRule{}, true
+	}
+
+	return config.MuteRule{}, false
+}
@@ -0,0 +1,159 @@
+// Package notify отправляет десктопные уведомления о новых сообщениях
+// (chunk2-6): выбирает доступный бэкенд (libnotify, terminal-notifier,
+// терминальный bell/OSC 9) и применяет per-chat правила приглушения из
+// config.Config.MutedChats, включая начальный импорт настроек из
+// account.getNotifySettings самого Telegram.
+package notify
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"vi-tg/config"
+)
+
+// Notification — одно уведомление о новом сообщении
+type Notification struct {
+	ChatID   int64
+	ChatName string
+	From     string
+	Text     string
+	// Mention — упоминает ли сообщение нас (@username или reply на наше
+	// сообщение); нужно для правила MentionsOnly, само уведомление это не знает
+	Mention bool
+}
+
+// Backend отправляет одно уведомление во внешнюю систему
+type Backend interface {
+	Notify(n Notification) error
+}
+
+// DetectBackend возвращает первый подходящий бэкенд: libnotify (notify-send)
+// на Linux, terminal-notifier на macOS, иначе — терминальный bell/OSC 9
+func DetectBackend() Backend {
+	if _, err := exec.LookPath("notify-send"); err == nil {
+		return libnotifyBackend{}
+	}
+	if _, err := exec.LookPath("terminal-notifier"); err == nil {
+		return terminalNotifierBackend{}
+	}
+	return bellBackend{}
+}
+
+// ShouldNotify решает, показывать ли уведомление, для чата, отсутствующего
+// в config.Config.MutedChats (muted=false) уведомление показывается всегда.
+// Для приглушённого чата правило задаёт исключения: пустое правило глушит
+// полностью, а Keywords/MentionsOnly/QuietHours — условия, при которых
+// уведомление всё же показывается несмотря на приглушение
+func ShouldNotify(rule config.MuteRule, muted bool, n Notification, now time.Time) bool {
+	if !muted {
+		return true
+	}
+
+	if rule.MentionsOnly {
+		return n.Mention
+	}
+
+	if len(rule.Keywords) > 0 {
+		text := strings.ToLower(n.Text)
+		for _, kw := range rule.Keywords {
+			if kw != "" && strings.Contains(text, strings.ToLower(kw)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if rule.QuietHours != "" {
+		// Тихие часы глушат только в заданном диапазоне, вне него — как обычно
+		return !inQuietHours(rule.QuietHours, now)
+	}
+
+	// Пустое правило — полное приглушение
+	return false
+}
+
+// inQuietHours разбирает диапазон "HH:MM-HH:MM" и проверяет, попадает ли now
+// в этот диапазон; диапазоны, переходящие через полночь (например, "22:00-07:00"),
+// поддерживаются. Некорректный формат трактуется как «не в тихих часах»
+func inQuietHours(rng string, now time.Time) bool {
+	start, end, ok := parseQuietHours(rng)
+	if !ok {
+		return false
+	}
+
+	cur := now.Hour()*60 + now.Minute()
+	if start <= end {
+		return cur >= start && cur < end
+	}
+	// диапазон через полночь
+	return cur >= start || cur < end
+}
+
+func parseQuietHours(rng string) (start, end int, ok bool) {
+	parts := strings.SplitN(rng, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	start, ok1 := parseHHMM(parts[0])
+	end, ok2 := parseHHMM(parts[1])
+	if !ok1 || !ok2 {
+		return 0, 0, false
+	}
+
+	return start, end, true
+}
+
+func parseHHMM(s string) (int, bool) {
+	parts := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	if len(parts) != 2 {
+		return 0, false
+	}
+
+	hh, err := strconv.Atoi(parts[0])
+	if err != nil || hh < 0 || hh > 23 {
+		return 0, false
+	}
+
+	mm, err := strconv.Atoi(parts[1])
+	if err != nil || mm < 0 || mm > 59 {
+		return 0, false
+	}
+
+	return hh*60 + mm, true
+}
+
+// libnotifyBackend шлёт уведомления через notify-send (Linux, libnotify)
+type libnotifyBackend struct{}
+
+func (libnotifyBackend) Notify(n Notification) error {
+	title := n.ChatName
+	if n.From != "" && n.From != n.ChatName {
+		title = fmt.Sprintf("%s (%s)", n.ChatName, n.From)
+	}
+	return exec.Command("notify-send", title, n.Text).Run()
+}
+
+// terminalNotifierBackend шлёт уведомления через terminal-notifier (macOS)
+type terminalNotifierBackend struct{}
+
+func (terminalNotifierBackend) Notify(n Notification) error {
+	title := n.ChatName
+	if n.From != "" && n.From != n.ChatName {
+		title = fmt.Sprintf("%s (%s)", n.ChatName, n.From)
+	}
+	return exec.Command("terminal-notifier", "-title", title, "-message", n.Text).Run()
+}
+
+// bellBackend — фоллбэк без внешних зависимостей: звонок терминала (BEL) и
+// OSC 9 (iTerm2/kitty показывают его как системное уведомление)
+type bellBackend struct{}
+
+func (bellBackend) Notify(n Notification) error {
+	fmt.Printf("\a\x1b]9;%s: %s\x07", n.ChatName, n.Text)
+	return nil
+}
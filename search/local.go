@@ -0,0 +1,150 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// LocalIndex — офлайн-зеркало сообщений в SQLite FTS5-таблице, чтобы старые
+// сообщения оставались доступны поиску без сети. Наполняется фоновой
+// горутиной, которую main.go запускает на каждый loadMessages (chunk2-5)
+type LocalIndex struct {
+	db *sql.DB
+}
+
+// defaultIndexPath — путь к индексу по умолчанию: ~/.local/share/vi-tg/index.db,
+// отдельно от ~/.vi-tg/store.db пакета store, который хранит не текст для
+// поиска, а метаданные медиа
+func defaultIndexPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	return filepath.Join(homeDir, ".local", "share", "vi-tg", "index.db")
+}
+
+// OpenLocalIndex открывает (создавая при необходимости) локальный FTS5-индекс
+func OpenLocalIndex() (*LocalIndex, error) {
+	path := defaultIndexPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("ошибка создания директории индекса: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия индекса поиска: %w", err)
+	}
+
+	idx := &LocalIndex{db: db}
+	if err := idx.migrate(); err != nil {
+		return nil, fmt.Errorf("ошибка миграции индекса поиска: %w", err)
+	}
+
+	return idx, nil
+}
+
+func (idx *LocalIndex) migrate() error {
+	// Дедупликация по (chat_id, message_id) сделана вручную в Index (DELETE
+	// перед INSERT) — SQLite не позволяет создавать индексы (в т.ч.
+	// UNIQUE) поверх виртуальных таблиц вроде fts5, это и пробовали раньше
+	// и получали "virtual tables may not be indexed"
+	statements := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+			text, from_name, chat_name,
+			chat_id UNINDEXED, access_hash UNINDEXED, message_id UNINDEXED, timestamp UNINDEXED,
+			tokenize = 'unicode61'
+		)`,
+	}
+
+	for _, stmt := range statements {
+		if _, err := idx.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close закрывает соединение с индексом
+func (idx *LocalIndex) Close() error {
+	return idx.db.Close()
+}
+
+// Index зеркалирует одно сообщение в индекс, заменяя прежнюю запись для
+// того же (ChatID, MessageID), если она уже есть
+func (idx *LocalIndex) Index(r Result) error {
+	_, err := idx.db.Exec(`DELETE FROM messages_fts WHERE chat_id = ? AND message_id = ?`, r.ChatID, r.MessageID)
+	if err != nil {
+		return err
+	}
+
+	_, err = idx.db.Exec(`INSERT INTO messages_fts (text, from_name, chat_name, chat_id, access_hash, message_id, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		r.Text, r.From, r.ChatName, r.ChatID, r.AccessHash, r.MessageID, r.Timestamp.Unix())
+	return err
+}
+
+// Search реализует Backend — полнотекстовый поиск по тексту, имени автора и
+// названию чата через стандартный FTS5 MATCH
+func (idx *LocalIndex) Search(ctx context.Context, query string, limit int) ([]Result, error) {
+	rows, err := idx.db.QueryContext(ctx, `SELECT text, from_name, chat_name, chat_id, access_hash, message_id, timestamp
+		FROM messages_fts WHERE messages_fts MATCH ? ORDER BY rank LIMIT ?`, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка локального поиска: %w", err)
+	}
+	defer rows.Close()
+
+	var results []Result
+	for rows.Next() {
+		var r Result
+		var ts int64
+		if err := rows.Scan(&r.Text, &r.From, &r.ChatName, &r.ChatID, &r.AccessHash, &r.MessageID, &ts); err != nil {
+			return nil, err
+		}
+		r.Timestamp = time.Unix(ts, 0)
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// SearchInChat — то же, что Search, но ограничивает выдачу одним чатом (nil
+// chatID — без ограничения) и ранжирует по свежести вместо релевантности
+// FTS5, для случая "найди это в текущем чате" (chunk6-6)
+func (idx *LocalIndex) SearchInChat(ctx context.Context, query string, chatID *int64, limit int) ([]Result, error) {
+	sqlQuery := `SELECT text, from_name, chat_name, chat_id, access_hash, message_id, timestamp
+		FROM messages_fts WHERE messages_fts MATCH ?`
+	args := []interface{}{query}
+
+	if chatID != nil {
+		sqlQuery += ` AND chat_id = ?`
+		args = append(args, *chatID)
+	}
+	sqlQuery += ` ORDER BY timestamp DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := idx.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка локального поиска: %w", err)
+	}
+	defer rows.Close()
+
+	var results []Result
+	for rows.Next() {
+		var r Result
+		var ts int64
+		if err := rows.Scan(&r.Text, &r.From, &r.ChatName, &r.ChatID, &r.AccessHash, &r.MessageID, &ts); err != nil {
+			return nil, err
+		}
+		r.Timestamp = time.Unix(ts, 0)
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
@@ -0,0 +1,82 @@
+package search
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func openTestIndex(t *testing.T) *LocalIndex {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+
+	idx, err := OpenLocalIndex()
+	if err != nil {
+		t.Fatalf("OpenLocalIndex() вернул ошибку: %v", err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	return idx
+}
+
+func TestOpenLocalIndex_CreatesFTS5Table(t *testing.T) {
+	// Регрессия на chunk2-5: migrate() раньше создавал UNIQUE INDEX поверх
+	// виртуальной FTS5-таблицы, что SQLite безусловно отклоняет, и
+	// OpenLocalIndex всегда проваливался
+	openTestIndex(t)
+}
+
+func TestIndex_DedupesByDeleteThenInsert(t *testing.T) {
+	idx := openTestIndex(t)
+	ctx := context.Background()
+
+	r := Result{ChatID: 1, MessageID: 42, Text: "первая версия", From: "alice", Timestamp: time.Now()}
+	if err := idx.Index(r); err != nil {
+		t.Fatalf("Index() вернул ошибку: %v", err)
+	}
+
+	r.Text = "вторая версия"
+	if err := idx.Index(r); err != nil {
+		t.Fatalf("повторный Index() вернул ошибку: %v", err)
+	}
+
+	results, err := idx.Search(ctx, "версия", 10)
+	if err != nil {
+		t.Fatalf("Search() вернул ошибку: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("len(results) = %d, хотим 1 (повторный Index того же chat_id/message_id не должен дублировать запись)", len(results))
+	}
+	if results[0].Text != "вторая версия" {
+		t.Errorf("results[0].Text = %q, хотим последнюю версию", results[0].Text)
+	}
+}
+
+func TestSearchInChat_FiltersByChatID(t *testing.T) {
+	idx := openTestIndex(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	if err := idx.Index(Result{ChatID: 1, MessageID: 1, Text: "привет мир", Timestamp: now}); err != nil {
+		t.Fatalf("Index() вернул ошибку: %v", err)
+	}
+	if err := idx.Index(Result{ChatID: 2, MessageID: 2, Text: "привет ещё раз", Timestamp: now}); err != nil {
+		t.Fatalf("Index() вернул ошибку: %v", err)
+	}
+
+	chatID := int64(1)
+	results, err := idx.SearchInChat(ctx, "привет", &chatID, 10)
+	if err != nil {
+		t.Fatalf("SearchInChat() вернул ошибку: %v", err)
+	}
+	if len(results) != 1 || results[0].ChatID != 1 {
+		t.Errorf("SearchInChat(chatID=1) = %+v, хотим ровно одну запись с ChatID=1", results)
+	}
+
+	all, err := idx.SearchInChat(ctx, "привет", nil, 10)
+	if err != nil {
+		t.Fatalf("SearchInChat(nil) вернул ошибку: %v", err)
+	}
+	if len(all) != 2 {
+		t.Errorf("SearchInChat(nil) = %d результатов, хотим 2 (без фильтра по чату)", len(all))
+	}
+}
@@ -0,0 +1,28 @@
+// Package search реализует поиск сообщений (chunk2-5): серверный поиск
+// через MTProto messages.search/messages.searchGlobal и локальный офлайн-
+// индекс на SQLite FTS5, в который main.go зеркалирует каждое сообщение,
+// прошедшее через loadMessages.
+package search
+
+import (
+	"context"
+	"time"
+)
+
+// Result — одно найденное сообщение, достаточно данных, чтобы открыть
+// содержащий чат и прокрутить к сообщению (main.go renderSearchPanel)
+type Result struct {
+	ChatID     int64
+	AccessHash int64
+	ChatName   string
+	MessageID  int64
+	Text       string
+	From       string
+	Timestamp  time.Time
+}
+
+// Backend ищет сообщения по тексту запроса. ServerBackend бьёт в MTProto
+// напрямую, LocalIndex — в локальный FTS5-индекс для офлайн-поиска
+type Backend interface {
+	Search(ctx context.Context, query string, limit int) ([]Result, error)
+}
@@ -0,0 +1,68 @@
+package search
+
+import (
+	"context"
+	"fmt"
+
+	"vi-tg/auth"
+)
+
+// ServerBackend ищет сообщения напрямую через MTProto: messages.search, если
+// задан конкретный чат, иначе messages.searchGlobal по всем диалогам
+type ServerBackend struct {
+	mtproto    *auth.MTProtoClient
+	chatID     int64
+	chatName   string
+	accessHash int64
+}
+
+// NewServerBackend создаёт серверный бэкенд поиска. Если chatID == 0, поиск
+// идёт по всем чатам (messages.searchGlobal), иначе — только по этому чату
+func NewServerBackend(mtproto *auth.MTProtoClient, chatID, accessHash int64, chatName string) *ServerBackend {
+	return &ServerBackend{mtproto: mtproto, chatID: chatID, chatName: chatName, accessHash: accessHash}
+}
+
+// Search реализует Backend
+func (b *ServerBackend) Search(ctx context.Context, query string, limit int) ([]Result, error) {
+	if b.mtproto == nil {
+		return nil, fmt.Errorf("MTProto клиент не подключен")
+	}
+
+	if b.chatID != 0 {
+		messages, err := b.mtproto.SearchMessages(ctx, b.chatID, b.accessHash, query, limit)
+		if err != nil {
+			return nil, err
+		}
+		return toResults(messages, b.chatID, b.accessHash, b.chatName), nil
+	}
+
+	messages, err := b.mtproto.SearchGlobal(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	return toResults(messages, 0, 0, ""), nil
+}
+
+// toResults преобразует auth.Message в search.Result; для глобального
+// поиска chatID/chatName берутся из самого сообщения (fallbackChatID == 0)
+func toResults(messages []auth.Message, fallbackChatID, fallbackAccessHash int64, fallbackChatName string) []Result {
+	results := make([]Result, 0, len(messages))
+	for _, msg := range messages {
+		chatID := msg.ChatID
+		if chatID == 0 {
+			chatID = fallbackChatID
+		}
+		chatName := fallbackChatName
+		accessHash := fallbackAccessHash
+		results = append(results, Result{
+			ChatID:     chatID,
+			AccessHash: accessHash,
+			ChatName:   chatName,
+			MessageID:  int64(msg.ID),
+			Text:       msg.Text,
+			From:       msg.From,
+			Timestamp:  msg.Timestamp,
+		})
+	}
+	return results
+}
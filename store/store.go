@@ -0,0 +1,264 @@
+// Package store хранит метаданные чатов, сообщений и медиа в SQL-базе,
+// заменяя опрос /tmp по маске "vi-tg_<kind>_<id>.<ext>" на обычные запросы.
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Store оборачивает *sql.DB и предоставляет методы для работы с
+// чатами, сообщениями и медиафайлами
+type Store struct {
+	db *sql.DB
+}
+
+// Chat описывает запись в таблице chats
+type Chat struct {
+	ID          int64
+	Title       string
+	Type        string
+	Unread      int
+	LastMessage string
+}
+
+// MessageRecord описывает запись в таблице messages
+type MessageRecord struct {
+	ID        int
+	ChatID    int64
+	Text      string
+	From      string
+	Type      string
+	Timestamp time.Time
+}
+
+// Media описывает запись в таблице media — метаданные скачанного файла
+type Media struct {
+	ID           int64
+	Kind         string // "photo", "video", "voice", "audio", "sticker", "location"
+	MIME         string
+	LocalPath    string
+	RemoteFileID string
+	Size         int64
+	Duration     int
+	Width        int
+	Height       int
+	Lat          float64
+	Lng          float64
+	DownloadedAt time.Time
+}
+
+// defaultPath — путь к SQLite-файлу по умолчанию, если DATABASE_URL не задан
+func defaultPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	return filepath.Join(homeDir, ".vi-tg", "store.db")
+}
+
+// Open открывает хранилище — SQLite-файл по пути из DATABASE_URL (если эта
+// переменная окружения задана) либо по умолчанию в ~/.vi-tg/store.db.
+//
+// Раньше здесь был задел под Postgres (DATABASE_URL с префиксом postgres://),
+// но он был нерабочим: ни один Postgres-драйвер нигде в модуле не
+// импортировался (sql.Open("postgres", ...) падал с "unknown driver" при
+// первом же обращении), да и все запросы в файле написаны с плейсхолдерами
+// "?", которые Postgres-драйверы не принимают. Убрано до тех пор, пока
+// Postgres не будет реализован по-настоящему — с драйвером и диалектом
+// плейсхолдеров (fix chunk0-4).
+func Open() (*Store, error) {
+	path := os.Getenv("DATABASE_URL")
+	if path == "" {
+		path = defaultPath()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("ошибка создания директории хранилища: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия базы данных: %w", err)
+	}
+
+	s := &Store{db: db}
+	if err := s.migrate(); err != nil {
+		return nil, fmt.Errorf("ошибка миграции схемы: %w", err)
+	}
+
+	return s, nil
+}
+
+// currentSchemaVersion — версия схемы store.db. Растёт при каждой миграции,
+// добавленной в migrate (chunk6-6); записывается в schema_meta после
+// успешного прогона всех CREATE TABLE/ALTER TABLE.
+const currentSchemaVersion = 1
+
+func (s *Store) migrate() error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS schema_meta (version INTEGER NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS chats (
+			id INTEGER PRIMARY KEY,
+			title TEXT NOT NULL,
+			type TEXT NOT NULL,
+			unread INTEGER NOT NULL DEFAULT 0,
+			last_message TEXT
+		)`,
+		`CREATE TABLE IF NOT EXISTS messages (
+			id INTEGER NOT NULL,
+			chat_id INTEGER NOT NULL,
+			text TEXT,
+			from_name TEXT,
+			type TEXT NOT NULL DEFAULT 'text',
+			timestamp TIMESTAMP,
+			PRIMARY KEY (chat_id, id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS media (
+			id INTEGER PRIMARY KEY,
+			kind TEXT NOT NULL,
+			mime TEXT,
+			local_path TEXT,
+			remote_file_id TEXT,
+			size INTEGER,
+			duration INTEGER,
+			width INTEGER,
+			height INTEGER,
+			lat REAL,
+			lng REAL,
+			downloaded_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages(chat_id)`,
+	}
+
+	for _, stmt := range statements {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	var version int
+	row := s.db.QueryRow(`SELECT version FROM schema_meta LIMIT 1`)
+	if err := row.Scan(&version); err == sql.ErrNoRows {
+		if _, err := s.db.Exec(`INSERT INTO schema_meta (version) VALUES (?)`, currentSchemaVersion); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if version != currentSchemaVersion {
+		// Будущие миграции схемы пойдут сюда — пока единственная версия (1),
+		// вводимая этой самой миграцией, так что расходиться ей не с чем
+		if _, err := s.db.Exec(`UPDATE schema_meta SET version = ?`, currentSchemaVersion); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close закрывает соединение с базой данных
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// UpsertChat создаёт или обновляет запись о чате
+func (s *Store) UpsertChat(c Chat) error {
+	_, err := s.db.Exec(`INSERT INTO chats (id, title, type, unread, last_message)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET title=excluded.title, type=excluded.type,
+			unread=excluded.unread, last_message=excluded.last_message`,
+		c.ID, c.Title, c.Type, c.Unread, c.LastMessage)
+	return err
+}
+
+// UpsertMessage создаёт или обновляет запись о сообщении
+func (s *Store) UpsertMessage(m MessageRecord) error {
+	_, err := s.db.Exec(`INSERT INTO messages (id, chat_id, text, from_name, type, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(chat_id, id) DO UPDATE SET text=excluded.text, from_name=excluded.from_name,
+			type=excluded.type, timestamp=excluded.timestamp`,
+		m.ID, m.ChatID, m.Text, m.From, m.Type, m.Timestamp)
+	return err
+}
+
+// UpsertMedia создаёт или обновляет метаданные скачанного медиафайла.
+// Вызывается путём загрузки MTProto по завершении скачивания.
+func (s *Store) UpsertMedia(m Media) error {
+	if m.DownloadedAt.IsZero() {
+		m.DownloadedAt = time.Now()
+	}
+
+	_, err := s.db.Exec(`INSERT INTO media (id, kind, mime, local_path, remote_file_id, size, duration, width, height, lat, lng, downloaded_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET kind=excluded.kind, mime=excluded.mime, local_path=excluded.local_path,
+			remote_file_id=excluded.remote_file_id, size=excluded.size, duration=excluded.duration,
+			width=excluded.width, height=excluded.height, lat=excluded.lat, lng=excluded.lng,
+			downloaded_at=excluded.downloaded_at`,
+		m.ID, m.Kind, m.MIME, m.LocalPath, m.RemoteFileID, m.Size, m.Duration, m.Width, m.Height, m.Lat, m.Lng, m.DownloadedAt)
+	return err
+}
+
+// GetMedia ищет метаданные медиафайла по типу и ID
+func (s *Store) GetMedia(kind string, id int64) (*Media, error) {
+	row := s.db.QueryRow(`SELECT id, kind, mime, local_path, remote_file_id, size, duration, width, height, lat, lng, downloaded_at
+		FROM media WHERE kind = ? AND id = ?`, kind, id)
+
+	var m Media
+	if err := row.Scan(&m.ID, &m.Kind, &m.MIME, &m.LocalPath, &m.RemoteFileID, &m.Size, &m.Duration, &m.Width, &m.Height, &m.Lat, &m.Lng, &m.DownloadedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// GetChats возвращает все закешированные чаты — используется telegram.Client
+// для офлайн-режима и как резерв, когда свежий список недоступен (chunk6-6)
+func (s *Store) GetChats() ([]Chat, error) {
+	rows, err := s.db.Query(`SELECT id, title, type, unread, last_message FROM chats`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Chat
+	for rows.Next() {
+		var c Chat
+		var lastMessage sql.NullString
+		if err := rows.Scan(&c.ID, &c.Title, &c.Type, &c.Unread, &lastMessage); err != nil {
+			return nil, err
+		}
+		c.LastMessage = lastMessage.String
+		result = append(result, c)
+	}
+
+	return result, rows.Err()
+}
+
+// GetMessages возвращает последние limit сообщений чата, отсортированные по возрастанию ID
+func (s *Store) GetMessages(chatID int64, limit int) ([]MessageRecord, error) {
+	rows, err := s.db.Query(`SELECT id, chat_id, text, from_name, type, timestamp
+		FROM messages WHERE chat_id = ? ORDER BY id DESC LIMIT ?`, chatID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []MessageRecord
+	for rows.Next() {
+		var m MessageRecord
+		if err := rows.Scan(&m.ID, &m.ChatID, &m.Text, &m.From, &m.Type, &m.Timestamp); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	t.Setenv("DATABASE_URL", filepath.Join(t.TempDir(), "store.db"))
+
+	s, err := Open()
+	if err != nil {
+		t.Fatalf("Open() вернул ошибку: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestOpen_CreatesSchemaAndVersion(t *testing.T) {
+	s := openTestStore(t)
+
+	var version int
+	row := s.db.QueryRow(`SELECT version FROM schema_meta LIMIT 1`)
+	if err := row.Scan(&version); err != nil {
+		t.Fatalf("ошибка чтения schema_meta: %v", err)
+	}
+	if version != currentSchemaVersion {
+		t.Errorf("version в schema_meta = %d, хотим %d", version, currentSchemaVersion)
+	}
+}
+
+func TestOpen_IsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	t.Setenv("DATABASE_URL", path)
+
+	s1, err := Open()
+	if err != nil {
+		t.Fatalf("первый Open() вернул ошибку: %v", err)
+	}
+	if err := s1.UpsertChat(Chat{ID: 1, Title: "чат", Type: "private"}); err != nil {
+		t.Fatalf("UpsertChat вернул ошибку: %v", err)
+	}
+	s1.Close()
+
+	s2, err := Open()
+	if err != nil {
+		t.Fatalf("повторный Open() вернул ошибку: %v", err)
+	}
+	defer s2.Close()
+
+	chats, err := s2.GetChats()
+	if err != nil {
+		t.Fatalf("GetChats() вернул ошибку: %v", err)
+	}
+	if len(chats) != 1 || chats[0].Title != "чат" {
+		t.Errorf("GetChats() = %+v, данные из первого открытия не сохранились", chats)
+	}
+}
+
+func TestUpsertChat_UpdatesOnConflict(t *testing.T) {
+	s := openTestStore(t)
+
+	if err := s.UpsertChat(Chat{ID: 1, Title: "первая", Type: "private", Unread: 3}); err != nil {
+		t.Fatalf("UpsertChat вернул ошибку: %v", err)
+	}
+	if err := s.UpsertChat(Chat{ID: 1, Title: "вторая", Type: "private", Unread: 0}); err != nil {
+		t.Fatalf("UpsertChat вернул ошибку: %v", err)
+	}
+
+	chats, err := s.GetChats()
+	if err != nil {
+		t.Fatalf("GetChats() вернул ошибку: %v", err)
+	}
+	if len(chats) != 1 {
+		t.Fatalf("len(chats) = %d, хотим 1 (ON CONFLICT должен обновить, а не дублировать)", len(chats))
+	}
+	if chats[0].Title != "вторая" || chats[0].Unread != 0 {
+		t.Errorf("chats[0] = %+v, ожидали обновлённые title/unread", chats[0])
+	}
+}
+
+func TestUpsertMessage_AndGetMessagesOrdering(t *testing.T) {
+	s := openTestStore(t)
+
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 1; i <= 3; i++ {
+		m := MessageRecord{ID: i, ChatID: 42, Text: "msg", From: "alice", Type: "text", Timestamp: base}
+		if err := s.UpsertMessage(m); err != nil {
+			t.Fatalf("UpsertMessage(%d) вернул ошибку: %v", i, err)
+		}
+	}
+
+	msgs, err := s.GetMessages(42, 2)
+	if err != nil {
+		t.Fatalf("GetMessages() вернул ошибку: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("len(msgs) = %d, хотим 2 (limit)", len(msgs))
+	}
+	if msgs[0].ID != 3 || msgs[1].ID != 2 {
+		t.Errorf("порядок сообщений = [%d, %d], хотим [3, 2] (DESC по id, limit 2)", msgs[0].ID, msgs[1].ID)
+	}
+}
+
+func TestGetMedia_NotFoundReturnsNilWithoutError(t *testing.T) {
+	s := openTestStore(t)
+
+	m, err := s.GetMedia("photo", 999)
+	if err != nil {
+		t.Fatalf("GetMedia() вернул ошибку: %v", err)
+	}
+	if m != nil {
+		t.Errorf("GetMedia() для несуществующей записи = %+v, хотим nil", m)
+	}
+}
+
+func TestUpsertMedia_RoundTrip(t *testing.T) {
+	s := openTestStore(t)
+
+	media := Media{ID: 7, Kind: "photo", MIME: "image/jpeg", LocalPath: "/tmp/x.jpg", Size: 1024}
+	if err := s.UpsertMedia(media); err != nil {
+		t.Fatalf("UpsertMedia() вернул ошибку: %v", err)
+	}
+
+	got, err := s.GetMedia("photo", 7)
+	if err != nil {
+		t.Fatalf("GetMedia() вернул ошибку: %v", err)
+	}
+	if got == nil || got.LocalPath != "/tmp/x.jpg" || got.Size != 1024 {
+		t.Errorf("GetMedia() = %+v, не совпадает с записанным", got)
+	}
+}
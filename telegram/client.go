@@ -1,20 +1,32 @@
 package telegram
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"time"
 
 	"gopkg.in/telebot.v3"
-)
 
-type Client struct {
-	bot *telebot.Bot
-}
+	"vi-tg/auth"
+	"vi-tg/config"
+	"vi-tg/internal/msgtemplate"
+	"vi-tg/search"
+	"vi-tg/store"
+)
 
 type Chat struct {
-	ID   int64
-	Name string
-	Type string
+	ID     int64
+	Name   string
+	Type   string
+	Unread int
+	// LastMessage — текст последнего сообщения чата, для превью в списке
+	LastMessage string
+	// Pinned — чат закреплён пользователем в списке диалогов
+	Pinned bool
+	// FolderID — папка диалога; 0 — основной список, 1 — архив, как и в
+	// auth.Dialog (chunk6-1)
+	FolderID int
 }
 
 type Message struct {
@@ -25,10 +37,217 @@ type Message struct {
 	ChatID    int64
 }
 
-func NewClient(token string) (*Client, error) {
+// Backend — источник чатов и сообщений для Client. botBackend работает через
+// Bot API (исторический путь, единственный до chunk6-1), mtprotoBackend — через
+// настоящий MTProto-клиент auth.MTProtoClient. NewClient выбирает реализацию
+// по Config.UseMTProto (chunk6-1).
+type Backend interface {
+	SendMessage(chatID int64, text string) error
+	GetChats() ([]Chat, error)
+	GetMessages(chatID int64, limit int) ([]Message, error)
+	StartPolling()
+	// Stop останавливает бэкенд. ctx ограничивает время на "вежливые" шаги
+	// остановки (например, deleteWebhook у botBackend в режиме вебхука) —
+	// сама остановка бота при истечении ctx всё равно выполняется (chunk6-7).
+	Stop(ctx context.Context)
+}
+
+type Client struct {
+	backend    Backend
+	templates  config.MessagesConfig
+	store      *store.Store
+	localIndex *search.LocalIndex
+}
+
+// NewClient выбирает бэкенд по cfg.UseMTProto — тот же признак, по которому
+// initialModel в main.go решает, какой из клиентов конструировать. Если
+// UseMTProto включён, используется уже созданный вызывающим кодом mtproto —
+// отдельный MTProtoClient здесь не создаётся, чтобы не плодить вторую
+// авторизованную сессию (chunk6-1). Также открывает локальный кеш чатов и
+// сообщений (store.Store) — GetChats/GetMessages обоих бэкендов кешируют в
+// него свежие данные и при cfg.OfflineMode либо при сбое сети отдают из него
+// же — и локальный FTS5-индекс для SearchMessages (chunk6-6).
+func NewClient(cfg *config.Config, mtproto *auth.MTProtoClient) (*Client, error) {
+	db, err := store.Open()
+	if err != nil {
+		return nil, fmt.Errorf("telegram: ошибка открытия локального кеша: %w", err)
+	}
+
+	// Локальный индекс поиска не критичен для работы Client — при ошибке
+	// открытия просто продолжаем без него, как и main.go поступает со своим
+	// собственным search.LocalIndex; SearchMessages при nil localIndex
+	// возвращает ошибку вместо паники (fix chunk6-6: эта ошибка раньше была
+	// фатальной и роняла NewClient всегда, поскольку OpenLocalIndex всегда
+	// проваливался из-за chunk2-5's bad index — см. fix на том индексе).
+	localIndex, err := search.OpenLocalIndex()
+	if err != nil {
+		localIndex = nil
+	}
+
+	if cfg.UseMTProto {
+		if mtproto == nil {
+			db.Close()
+			if localIndex != nil {
+				localIndex.Close()
+			}
+			return nil, fmt.Errorf("telegram: UseMTProto включён, но MTProto-клиент не передан")
+		}
+		backend := newMTProtoBackend(mtproto, cfg.SessionPath, db, cfg.OfflineMode)
+		return &Client{backend: backend, templates: cfg.Messages, store: db, localIndex: localIndex}, nil
+	}
+
+	backend, err := newBotBackend(cfg, db)
+	if err != nil {
+		db.Close()
+		if localIndex != nil {
+			localIndex.Close()
+		}
+		return nil, err
+	}
+	return &Client{backend: backend, templates: cfg.Messages, store: db, localIndex: localIndex}, nil
+}
+
+// SearchMessages ищет сообщения в локальном офлайн-индексе по тексту,
+// опционально ограничивая выдачу одним чатом (chatID == nil — по всем),
+// с ранжированием по свежести вместо релевантности (chunk6-6)
+func (c *Client) SearchMessages(ctx context.Context, query string, chatID *int64, limit int) ([]search.Result, error) {
+	if c.localIndex == nil {
+		return nil, fmt.Errorf("telegram: локальный индекс поиска недоступен")
+	}
+	return c.localIndex.SearchInChat(ctx, query, chatID, limit)
+}
+
+// StartAuth запускает интерактивную авторизацию MTProto по номеру телефона —
+// TUI читает CodeRequests/PasswordRequests/SignUpRequests у возвращённой
+// AuthSession и отвечает SubmitCode/SubmitPassword/Register по мере
+// прохождения шагов, вместо одного блокирующего вызова, как делает
+// auth.MTProtoClient.AuthAndConnect (chunk6-2). Доступен только когда Client
+// сконструирован с MTProto-бэкендом.
+func (c *Client) StartAuth(phone string) (*AuthSession, error) {
+	backend, ok := c.backend.(*mtprotoBackend)
+	if !ok {
+		return nil, fmt.Errorf("telegram: StartAuth доступен только для MTProto-бэкенда")
+	}
+	return backend.startAuth(phone)
+}
+
+func (c *Client) SendMessage(chatID int64, text string) error {
+	return c.backend.SendMessage(chatID, text)
+}
+
+// SendTemplated рендерит именованный шаблон сообщения (outgoing/notification/
+// status/join/leave — см. config.MessagesConfig) с данными data и отправляет
+// результат в chatID. Возвращает ошибку, если шаблон с таким именем не задан
+// в config.Config.Messages (chunk6-3).
+func (c *Client) SendTemplated(chatID int64, name string, data interface{}) error {
+	text, ok := c.templates.Lookup(name)
+	if !ok {
+		return fmt.Errorf("telegram: шаблон сообщения %q не задан", name)
+	}
+
+	rendered, err := msgtemplate.Render(name, text, data)
+	if err != nil {
+		return err
+	}
+
+	return c.SendMessage(chatID, rendered)
+}
+
+func (c *Client) GetChats() ([]Chat, error) {
+	return c.backend.GetChats()
+}
+
+func (c *Client) GetMessages(chatID int64, limit int) ([]Message, error) {
+	return c.backend.GetMessages(chatID, limit)
+}
+
+func (c *Client) StartPolling() {
+	c.backend.StartPolling()
+}
+
+// SetWebhook регистрирует Config.WebhookURL в Bot API (setWebhook), чтобы
+// Telegram начал слать обновления на WebhookListen вместо выдачи их по
+// long polling. Доступен только для Bot API-бэкенда, сконструированного с
+// непустым WebhookURL (chunk6-7).
+func (c *Client) SetWebhook() error {
+	backend, ok := c.backend.(*botBackend)
+	if !ok {
+		return fmt.Errorf("telegram: SetWebhook доступен только для Bot API-бэкенда")
+	}
+	return backend.setWebhook()
+}
+
+// DeleteWebhook снимает регистрацию вебхука (deleteWebhook), возвращая бота
+// к long polling при следующем StartPolling. Доступен только для Bot
+// API-бэкенда (chunk6-7).
+func (c *Client) DeleteWebhook() error {
+	backend, ok := c.backend.(*botBackend)
+	if !ok {
+		return fmt.Errorf("telegram: DeleteWebhook доступен только для Bot API-бэкенда")
+	}
+	return backend.deleteWebhook()
+}
+
+// Stop останавливает бэкенд и закрывает локальный кеш (store.Store) и индекс
+// поиска (search.LocalIndex), сбрасывая на диск то, что ещё не записано
+// (chunk6-6). ctx ограничивает время на вежливые шаги остановки бэкенда
+// (deleteWebhook в режиме вебхука) — см. Backend.Stop (chunk6-7). main.go
+// вызывает Stop перед перезапуском/выходом из цикла TUI, так что снятие
+// вебхука действительно происходит при остановке, а не только теоретически.
+func (c *Client) Stop(ctx context.Context) {
+	c.backend.Stop(ctx)
+	if c.localIndex != nil {
+		c.localIndex.Close()
+	}
+	if c.store != nil {
+		c.store.Close()
+	}
+}
+
+// botBackend — реализация Backend поверх Bot API (gopkg.in/telebot.v3).
+// У ботов нет аналога messages.getDialogs/messages.getHistory — Bot API в
+// принципе не даёт списка чатов и истории сообщений, которые бот явно не
+// получал через обновления, — поэтому GetChats/GetMessages остаются
+// демонстрационной заглушкой: это осознанное ограничение самого Bot API,
+// а не недоделка chunk6-1.
+type botBackend struct {
+	bot         *telebot.Bot
+	store       *store.Store
+	offlineMode bool
+	// webhook — ненулевой, если бот сконструирован с Config.WebhookURL;
+	// хранится отдельно от bot.Poller, чтобы Stop могла снять регистрацию
+	// вебхука при завершении работы, не разбирая тип Poller (chunk6-7)
+	webhook *telebot.Webhook
+}
+
+// buildPoller выбирает telebot.Poller по webhook-полям cfg: пустой
+// WebhookURL — обычный long polling (исторический путь), непустой — вебхук с
+// HTTPS-листенером на WebhookListen. WebhookCertFile/WebhookKeyFile заданы —
+// вебхук сам поднимает TLS; иначе ожидается TLS-терминирующий прокси перед
+// ним. WebhookSecretToken передаётся в telebot.Webhook, который сверяет его
+// с заголовком X-Telegram-Bot-Api-Secret-Token самостоятельно (chunk6-7).
+func buildPoller(cfg *config.Config) telebot.Poller {
+	if cfg.WebhookURL == "" {
+		return &telebot.LongPoller{Timeout: 10 * time.Second}
+	}
+
+	webhook := &telebot.Webhook{
+		Listen:      cfg.WebhookListen,
+		Endpoint:    &telebot.WebhookEndpoint{PublicURL: cfg.WebhookURL},
+		SecretToken: cfg.WebhookSecretToken,
+	}
+	if cfg.WebhookCertFile != "" && cfg.WebhookKeyFile != "" {
+		webhook.TLS = &telebot.WebhookTLS{Cert: cfg.WebhookCertFile, Key: cfg.WebhookKeyFile}
+	}
+	return webhook
+}
+
+func newBotBackend(cfg *config.Config, db *store.Store) (*botBackend, error) {
+	poller := buildPoller(cfg)
+
 	pref := telebot.Settings{
-		Token:  token,
-		Poller: &telebot.LongPoller{Timeout: 10 * time.Second},
+		Token:  cfg.TelegramToken,
+		Poller: poller,
 	}
 
 	bot, err := telebot.NewBot(pref)
@@ -36,31 +255,70 @@ func NewClient(token string) (*Client, error) {
 		return nil, fmt.Errorf("ошибка создания бота: %w", err)
 	}
 
-	return &Client{bot: bot}, nil
+	webhook, _ := poller.(*telebot.Webhook)
+	return &botBackend{bot: bot, store: db, offlineMode: cfg.OfflineMode, webhook: webhook}, nil
 }
 
-func (c *Client) SendMessage(chatID int64, text string) error {
+// setWebhook регистрирует текущий Poller бота (ожидается *telebot.Webhook) в
+// Bot API через прямой вызов setWebhook
+func (b *botBackend) setWebhook() error {
+	if b.webhook == nil {
+		return fmt.Errorf("telegram: webhook_url не задан в конфиге")
+	}
+
+	params := map[string]string{"url": b.webhook.Endpoint.PublicURL}
+	if b.webhook.SecretToken != "" {
+		params["secret_token"] = b.webhook.SecretToken
+	}
+	if _, err := b.bot.Raw("setWebhook", params); err != nil {
+		return fmt.Errorf("ошибка установки webhook: %w", err)
+	}
+	return nil
+}
+
+// deleteWebhook снимает регистрацию вебхука в Bot API — вызывается из
+// Client.DeleteWebhook и из Stop при graceful shutdown бэкенда, запущенного в
+// режиме вебхука (chunk6-7)
+func (b *botBackend) deleteWebhook() error {
+	if _, err := b.bot.Raw("deleteWebhook", map[string]string{}); err != nil {
+		return fmt.Errorf("ошибка удаления webhook: %w", err)
+	}
+	return nil
+}
+
+func (b *botBackend) SendMessage(chatID int64, text string) error {
 	chat := &telebot.Chat{ID: chatID}
-	_, err := c.bot.Send(chat, text)
+	_, err := b.bot.Send(chat, text)
 	if err != nil {
 		return fmt.Errorf("ошибка отправки сообщения: %w", err)
 	}
 	return nil
 }
 
-func (c *Client) GetChats() ([]Chat, error) {
-	// В реальном приложении здесь нужно получить список чатов
-	// Для демонстрации возвращаем тестовые данные
+func (b *botBackend) GetChats() ([]Chat, error) {
+	if b.offlineMode {
+		return cachedChats(b.store)
+	}
+
+	// Bot API не возвращает список диалогов — см. комментарий к botBackend
 	chats := []Chat{
 		{ID: 1, Name: "Общий чат", Type: "group"},
 		{ID: 2, Name: "Тестовый чат", Type: "private"},
 	}
+
+	for _, c := range chats {
+		b.store.UpsertChat(store.Chat{ID: c.ID, Title: c.Name, Type: c.Type, Unread: c.Unread, LastMessage: c.LastMessage})
+	}
+
 	return chats, nil
 }
 
-func (c *Client) GetMessages(chatID int64, limit int) ([]Message, error) {
-	// В реальном приложении здесь нужно получить сообщения из чата
-	// Для демонстрации возвращаем тестовые данные
+func (b *botBackend) GetMessages(chatID int64, limit int) ([]Message, error) {
+	if b.offlineMode {
+		return cachedMessages(b.store, chatID, limit)
+	}
+
+	// Bot API не возвращает историю сообщений — см. комментарий к botBackend
 	messages := []Message{
 		{
 			ID:        1,
@@ -77,13 +335,231 @@ func (c *Client) GetMessages(chatID int64, limit int) ([]Message, error) {
 			ChatID:    chatID,
 		},
 	}
+
+	for _, m := range messages {
+		b.store.UpsertMessage(store.MessageRecord{ID: m.ID, ChatID: m.ChatID, Text: m.Text, From: m.From, Timestamp: m.Timestamp})
+	}
+
 	return messages, nil
 }
 
-func (c *Client) StartPolling() {
-	c.bot.Start()
+func (b *botBackend) StartPolling() {
+	b.bot.Start()
+}
+
+// Stop останавливает бота. В режиме вебхука сперва снимает регистрацию в Bot
+// API (deleteWebhook), пока ctx не истёк, чтобы Telegram не продолжал слать
+// обновления на уже выключенный листенер; истечение ctx или ошибка снятия
+// регистрации (например, Telegram недоступен) не мешают остановке самого
+// бота (chunk6-7).
+func (b *botBackend) Stop(ctx context.Context) {
+	if b.webhook != nil && ctx.Err() == nil {
+		if err := b.deleteWebhook(); err != nil {
+			fmt.Fprintf(os.Stderr, "telegram: %v\n", err)
+		}
+	}
+	b.bot.Stop()
+}
+
+// mtprotoBackend — реализация Backend поверх настоящего MTProto-клиента
+// (gotd/td), оборачивает auth.MTProtoClient и переводит его типы в Chat/Message
+// этого пакета (chunk6-1). sessionDir — каталог файлов сессии на номер
+// телефона для StartAuth (chunk6-2), см. config.Config.SessionPath.
+type mtprotoBackend struct {
+	client      *auth.MTProtoClient
+	sessionDir  string
+	store       *store.Store
+	offlineMode bool
+}
+
+func newMTProtoBackend(client *auth.MTProtoClient, sessionDir string, db *store.Store, offlineMode bool) *mtprotoBackend {
+	return &mtprotoBackend{client: client, sessionDir: sessionDir, store: db, offlineMode: offlineMode}
+}
+
+func (b *mtprotoBackend) SendMessage(chatID int64, text string) error {
+	return b.client.SendMessage(context.Background(), chatID, text)
+}
+
+// GetChats в офлайн-режиме отдаёт только закешированные чаты. Иначе пробует
+// сходить за свежим списком в MTProto, кеширует результат в store.Store и
+// возвращает его; если запрос не удался (например, нет сети), отдаёт
+// последний закешированный список вместо ошибки (chunk6-6).
+func (b *mtprotoBackend) GetChats() ([]Chat, error) {
+	if b.offlineMode {
+		return cachedChats(b.store)
+	}
+
+	dialogs, err := b.client.GetDialogs(context.Background())
+	if err != nil {
+		if cached, cacheErr := cachedChats(b.store); cacheErr == nil && len(cached) > 0 {
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	chats := make([]Chat, 0, len(dialogs))
+	for _, d := range dialogs {
+		c := Chat{
+			ID:          d.ID,
+			Name:        d.Title,
+			Type:        d.Type,
+			Unread:      d.Unread,
+			LastMessage: d.LastMsg,
+			Pinned:      d.Pinned,
+			FolderID:    d.FolderID,
+		}
+		chats = append(chats, c)
+		b.store.UpsertChat(store.Chat{ID: c.ID, Title: c.Name, Type: c.Type, Unread: c.Unread, LastMessage: c.LastMessage})
+	}
+	return chats, nil
+}
+
+// GetMessages возвращает последние limit сообщений чата. auth.MTProtoClient
+// пока не принимает offsetID/offsetDate для messages.getHistory, поэтому
+// пагинация здесь ограничена тем же набором параметров, что и у
+// MTProtoClient.GetMessages, — без отдельной постраничной подгрузки истории.
+// В офлайн-режиме либо при сбое запроса к MTProto отдаёт закешированные
+// сообщения из store.Store вместо ошибки (chunk6-6).
+func (b *mtprotoBackend) GetMessages(chatID int64, limit int) ([]Message, error) {
+	if b.offlineMode {
+		return cachedMessages(b.store, chatID, limit)
+	}
+
+	msgs, err := b.client.GetMessages(context.Background(), chatID, limit)
+	if err != nil {
+		if cached, cacheErr := cachedMessages(b.store, chatID, limit); cacheErr == nil && len(cached) > 0 {
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(msgs))
+	for _, m := range msgs {
+		msg := Message{
+			ID:        m.ID,
+			Text:      m.Text,
+			From:      m.From,
+			Timestamp: m.Timestamp,
+			ChatID:    m.ChatID,
+		}
+		messages = append(messages, msg)
+		b.store.UpsertMessage(store.MessageRecord{ID: msg.ID, ChatID: msg.ChatID, Text: msg.Text, From: msg.From, Timestamp: msg.Timestamp})
+	}
+	return messages, nil
 }
 
-func (c *Client) Stop() {
-	c.bot.Stop()
-} 
\ No newline at end of file
+// cachedChats и cachedMessages читают store.Store напрямую для офлайн-режима
+// и как резерв на случай сбоя сети — общие для botBackend и mtprotoBackend
+func cachedChats(db *store.Store) ([]Chat, error) {
+	rows, err := db.GetChats()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения кеша чатов: %w", err)
+	}
+
+	chats := make([]Chat, 0, len(rows))
+	for _, c := range rows {
+		chats = append(chats, Chat{ID: c.ID, Name: c.Title, Type: c.Type, Unread: c.Unread, LastMessage: c.LastMessage})
+	}
+	return chats, nil
+}
+
+func cachedMessages(db *store.Store, chatID int64, limit int) ([]Message, error) {
+	rows, err := db.GetMessages(chatID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения кеша сообщений: %w", err)
+	}
+
+	messages := make([]Message, 0, len(rows))
+	for _, m := range rows {
+		messages = append(messages, Message{ID: m.ID, Text: m.Text, From: m.From, Timestamp: m.Timestamp, ChatID: m.ChatID})
+	}
+	return messages, nil
+}
+
+// StartPolling/Stop — нет-опы для mtprotoBackend: доставкой обновлений для
+// MTProto управляет не Client, а MTProtoClient.Updates/AuthAndConnect,
+// подключённые в main.go отдельно от этого пакета (chunk6-1)
+func (b *mtprotoBackend) StartPolling() {}
+
+func (b *mtprotoBackend) Stop(ctx context.Context) {}
+
+// startAuth готовит каталог сессий, заводит TUIAuth для phone и запускает
+// AuthAndConnectWithSession в фоне — сама авторизация блокируется на чтении
+// CodeRequests/PasswordRequests/SignUpRequests, которые разбирает TUI через
+// возвращённую AuthSession, пока эта горутина ждёт её завершения (chunk6-2)
+func (b *mtprotoBackend) startAuth(phone string) (*AuthSession, error) {
+	if err := os.MkdirAll(b.sessionDir, 0700); err != nil {
+		return nil, fmt.Errorf("ошибка создания каталога сессий: %w", err)
+	}
+	sessionPath := auth.SessionPathForPhone(b.sessionDir, phone)
+
+	tui := auth.NewTUIAuth(phone)
+	session := &AuthSession{tui: tui, done: make(chan error, 1)}
+
+	go func() {
+		err := b.client.AuthAndConnectWithSession(context.Background(), tui, sessionPath)
+		if err == nil {
+			// gotd/td уже пишет файл сессии приватным образом, но выставляем
+			// права явно — так 0600 гарантирован независимо от поведения
+			// конкретной версии зависимости (chunk6-2)
+			os.Chmod(sessionPath, 0600)
+		}
+		session.done <- err
+	}()
+
+	return session, nil
+}
+
+// AuthSession — один проход интерактивной авторизации MTProto, запущенный
+// Client.StartAuth. Аналог authorizer из telegabber: вместо единственного
+// блокирующего вызова AuthAndConnect, TUI читает запросы следующего шага из
+// CodeRequests/PasswordRequests/SignUpRequests и отвечает
+// SubmitCode/SubmitPassword/Register, пока авторизация не завершится —
+// результат приходит в Done() (chunk6-2)
+type AuthSession struct {
+	tui  *auth.TUIAuth
+	done chan error
+}
+
+// CodeRequests сигнализирует, что пора спросить у пользователя код
+// подтверждения и ответить SubmitCode
+func (s *AuthSession) CodeRequests() <-chan auth.CodeRequest {
+	return s.tui.CodeRequests()
+}
+
+// PasswordRequests сигнализирует, что пора спросить пароль двухфакторной
+// аутентификации и ответить SubmitPassword
+func (s *AuthSession) PasswordRequests() <-chan struct{} {
+	return s.tui.PasswordRequests()
+}
+
+// SignUpRequests сигнализирует, что номер не зарегистрирован и нужно
+// запросить имя/фамилию для регистрации через Register
+func (s *AuthSession) SignUpRequests() <-chan struct{} {
+	return s.tui.SignUpRequests()
+}
+
+func (s *AuthSession) SubmitCode(code string) {
+	s.tui.SubmitCode(code)
+}
+
+func (s *AuthSession) SubmitPassword(password string) {
+	s.tui.SubmitPassword(password)
+}
+
+// Register отвечает на SignUpRequests именем и фамилией нового аккаунта
+func (s *AuthSession) Register(first, last string) {
+	s.tui.SubmitSignUp(first, last)
+}
+
+// Cancel прерывает текущий шаг авторизации — например, если пользователь
+// закрыл модальное окно ввода кода
+func (s *AuthSession) Cancel(err error) {
+	s.tui.Cancel(err)
+}
+
+// Done возвращает канал, в который один раз придёт итог авторизации: nil при
+// успехе или причина ошибки (включая таймаут AuthAndConnectWithSession)
+func (s *AuthSession) Done() <-chan error {
+	return s.done
+}